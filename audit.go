@@ -0,0 +1,57 @@
+package mulery
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"golift.io/mulery/server"
+	"golift.io/rotatorr"
+	"golift.io/rotatorr/timerotator"
+)
+
+// AuditAdminState is the audit action recorded when someone dumps the server state.
+const AuditAdminState = "adminState"
+
+// setupAuditLog opens the append-only audit log, if one is configured.
+//
+//nolint:gomnd
+func (c *Config) setupAuditLog() {
+	if c.AuditLog == "" {
+		return
+	}
+
+	c.auditLog = log.New(rotatorr.NewMust(&rotatorr.Config{
+		Filepath: c.AuditLog,
+		FileSize: c.AuditLogMB * 1024 * 1024,
+		FileMode: 0o600,
+		Rotatorr: &timerotator.Layout{FileCount: c.AuditLogs},
+	}), "", 0)
+}
+
+// Audit writes an event to the audit log as a line of JSON.
+// This is passed into the server as the Auditor, and is a no-op if no audit log is configured.
+func (c *Config) Audit(event *server.AuditEvent) {
+	if c.auditLog == nil {
+		return
+	}
+
+	line, err := json.Marshal(event)
+	if err != nil {
+		c.Errorf("Encoding audit event: %v", err)
+		return
+	}
+
+	c.auditLog.Println(string(line))
+}
+
+// auditAdmin records an admin action taken through the web server.
+func (c *Config) auditAdmin(action string, req *http.Request) {
+	c.Audit(&server.AuditEvent{
+		Time:    time.Now(),
+		Action:  action,
+		Remote:  req.RemoteAddr,
+		Message: req.Method + " " + req.URL.String(),
+	})
+}
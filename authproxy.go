@@ -0,0 +1,153 @@
+package mulery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Auth proxy failover defaults.
+const (
+	DefaultAuthTimeout = 5 * time.Second
+	DefaultAuthBackoff = 10 * time.Second
+	maxAuthBackoff     = 5 * time.Minute
+)
+
+// ErrAuthProxy is returned when the auth proxy fails, instead of rejecting the key.
+var ErrAuthProxy = errors.New("auth proxy failed")
+
+// authEndpoint is an auth proxy URL, and its recent failures.
+type authEndpoint struct {
+	url      string
+	mu       sync.Mutex
+	failures int
+	until    time.Time // skip this endpoint until then.
+}
+
+// setupAuthProxies builds the endpoint list from AuthURL and AuthURLs.
+func (c *Config) setupAuthProxies() {
+	c.authProxies = nil
+
+	for _, authURL := range append(StringSlice{c.AuthURL}, c.AuthURLs...) {
+		if authURL != "" {
+			c.authProxies = append(c.authProxies, &authEndpoint{url: authURL})
+		}
+	}
+
+	if c.AuthTimeout <= 0 {
+		c.AuthTimeout = DefaultAuthTimeout
+	}
+
+	if c.AuthBackoff <= 0 {
+		c.AuthBackoff = DefaultAuthBackoff
+	}
+}
+
+// failed marks the endpoint down. It is skipped for the backoff, which doubles with each failure.
+func (a *authEndpoint) failed(backoff time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.failures++
+
+	for i := 1; i < a.failures && backoff < maxAuthBackoff; i++ {
+		backoff *= 2
+	}
+
+	a.until = time.Now().Add(min(backoff, maxAuthBackoff))
+}
+
+// working marks the endpoint up.
+func (a *authEndpoint) working() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.failures = 0
+	a.until = time.Time{}
+}
+
+// backingOff returns true if the endpoint failed recently.
+func (a *authEndpoint) backingOff(now time.Time) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return now.Before(a.until)
+}
+
+// authorize asks the auth proxies if a key is valid, and returns when it expires.
+// Endpoints are tried in order, and endpoints that failed recently are skipped,
+// unless they all failed recently; then they are all tried anyway.
+func (c *Config) authorize(ctx context.Context, key string) (time.Time, error) {
+	if len(c.authProxies) == 0 {
+		return time.Time{}, fmt.Errorf("%w: no auth_url configured", ErrAuthProxy)
+	}
+
+	now := time.Now()
+	endpoints := make([]*authEndpoint, 0, len(c.authProxies))
+
+	for _, endpoint := range c.authProxies {
+		if !endpoint.backingOff(now) {
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+
+	if len(endpoints) == 0 {
+		endpoints = c.authProxies
+	}
+
+	var err error
+
+	for _, endpoint := range endpoints {
+		var expires time.Time
+
+		expires, err = c.askAuthProxy(ctx, endpoint.url, key)
+		if err == nil || errors.Is(err, ErrInvalidKey) {
+			endpoint.working()
+			return expires, err
+		}
+
+		if ctx.Err() != nil {
+			return time.Time{}, err // the registration went away, this endpoint may be fine.
+		}
+
+		c.Errorf("Auth proxy %s failed, backing off: %v", endpoint.url, err)
+		endpoint.failed(c.AuthBackoff)
+	}
+
+	return time.Time{}, err
+}
+
+// askAuthProxy asks one auth proxy if a key is valid.
+func (c *Config) askAuthProxy(ctx context.Context, authURL, key string) (time.Time, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.AuthTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, authURL, nil)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("creating auth proxy request: %w", err)
+	}
+
+	req.Header.Add(c.AuthHeader, key)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: connecting to auth proxy: %w", ErrAuthProxy, err)
+	}
+	defer resp.Body.Close()
+
+	_, _ = io.Copy(io.Discard, resp.Body) // avoid memory leak
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return time.Time{}, fmt.Errorf("%w: status: %s", ErrAuthProxy, resp.Status)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return time.Time{}, fmt.Errorf("%w: status: %s", ErrInvalidKey, resp.Status)
+	}
+
+	return parseExpires(resp.Header.Get(c.AuthExpiresHeader)), nil
+}
@@ -0,0 +1,300 @@
+// Package bench runs reproducible load tests against an in-process mulery server and clients.
+// Use it to measure requests per second, latency and allocations when changing the dispatcher
+// or the serialization paths. See cmd/mulerybench for a command line wrapper.
+package bench
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golift.io/mulery/client"
+	"golift.io/mulery/mulch"
+	"golift.io/mulery/server"
+)
+
+// Defaults used for zero values in Config.
+const (
+	DefaultClients     = 1
+	DefaultWorkers     = 1
+	DefaultRequests    = 10000
+	DefaultBodySize    = 2000
+	DefaultPoolIdle    = 10
+	DefaultPoolMax     = 50
+	DefaultDispatchers = 1
+	DefaultWarmup      = 100
+	connectTimeout     = 10 * time.Second
+)
+
+// Errors returned by Run.
+var (
+	ErrNotConnected = errors.New("clients did not connect in time")
+	ErrBadResponse  = errors.New("unexpected response")
+)
+
+// ID header the requests are routed with.
+const idHeader = "x-bench-client"
+
+// Config is the load test to run. Zero values use the defaults above.
+type Config struct {
+	// Clients is the number of tunnel clients to connect. Requests are spread across them.
+	Clients int
+	// Workers is the number of concurrent requesters.
+	Workers int
+	// Requests is the total number of requests to send, not counting warmup.
+	Requests int
+	// BodySize is the size of the response body each client returns.
+	BodySize int
+	// PoolIdleSize and PoolMaxSize are passed to every client.
+	PoolIdleSize int
+	PoolMaxSize  int
+	// Dispatchers is the number of server dispatcher threads.
+	// Only the first Run in a process sets this, because the server is shared.
+	Dispatchers uint
+	// Warmup requests are sent and discarded before measuring.
+	Warmup int
+}
+
+// Result contains the measurements from one Run.
+type Result struct {
+	Requests     int           `json:"requests"`
+	Errors       int64         `json:"errors"`
+	Elapsed      time.Duration `json:"elapsed"`
+	PerSecond    float64       `json:"perSecond"`
+	P50          time.Duration `json:"p50"`
+	P90          time.Duration `json:"p90"`
+	P99          time.Duration `json:"p99"`
+	Max          time.Duration `json:"max"`
+	AllocsPerReq uint64        `json:"allocsPerReq"`
+	BytesPerReq  uint64        `json:"bytesPerReq"`
+}
+
+// String formats a result for humans.
+func (r *Result) String() string {
+	return fmt.Sprintf("requests: %d, errors: %d, elapsed: %v, req/s: %.0f, p50: %v, p90: %v, p99: %v, max: %v, "+
+		"allocs/req: %d, bytes/req: %d", r.Requests, r.Errors, r.Elapsed.Round(time.Millisecond), r.PerSecond,
+		r.P50, r.P90, r.P99, r.Max, r.AllocsPerReq, r.BytesPerReq)
+}
+
+// env is the shared server. The server registers prometheus metrics, so only one may exist per process.
+//
+//nolint:gochecknoglobals
+var (
+	env     *httptest.Server
+	envOnce sync.Once
+	runs    atomic.Int64
+)
+
+func (c *Config) setDefaults() {
+	setDefault(&c.Clients, DefaultClients)
+	setDefault(&c.Workers, DefaultWorkers)
+	setDefault(&c.Requests, DefaultRequests)
+	setDefault(&c.BodySize, DefaultBodySize)
+	setDefault(&c.PoolIdleSize, DefaultPoolIdle)
+	setDefault(&c.PoolMaxSize, DefaultPoolMax)
+	setDefault(&c.Warmup, DefaultWarmup)
+
+	if c.Dispatchers == 0 {
+		c.Dispatchers = DefaultDispatchers
+	}
+}
+
+func setDefault(value *int, def int) {
+	if *value <= 0 {
+		*value = def
+	}
+}
+
+func startServer(dispatchers uint) *httptest.Server {
+	envOnce.Do(func() {
+		config := server.NewConfig()
+		config.IDHeader = idHeader
+		config.Dispatchers = dispatchers
+		config.Timeout = time.Minute
+		config.IdleTimeout = time.Hour
+		config.Logger = &mulch.DefaultLogger{Silent: true}
+		srv := server.NewServer(config)
+
+		go srv.StartDispatcher()
+
+		mux := http.NewServeMux()
+		mux.Handle("/register", srv.HandleRegister())
+		mux.Handle("/", srv.HandleRequest("bench"))
+		env = httptest.NewServer(mux)
+	})
+
+	return env
+}
+
+// Run connects the clients, sends the requests and returns the measurements.
+// The clients are shut down before returning. The server is kept for the next Run.
+func Run(ctx context.Context, config *Config) (*Result, error) {
+	config.setDefaults()
+	srv := startServer(config.Dispatchers)
+	body := strings.Repeat("x", config.BodySize)
+	run := strconv.FormatInt(runs.Add(1), 10)
+	ids := make([]string, config.Clients)
+
+	for idx := range ids {
+		ids[idx] = "bench-" + run + "-" + strconv.Itoa(idx)
+		tunnel, err := startClient(ctx, srv.URL, ids[idx], body, config)
+		if err != nil {
+			return nil, err
+		}
+		defer tunnel.Shutdown()
+	}
+
+	httpClient := &http.Client{Transport: &http.Transport{MaxIdleConnsPerHost: config.Workers}}
+	defer httpClient.CloseIdleConnections()
+
+	if err := waitConnected(ctx, httpClient, srv.URL, ids, len(body)); err != nil {
+		return nil, err
+	}
+
+	load := &loader{client: httpClient, url: srv.URL, ids: ids, size: len(body)}
+	load.send(ctx, config.Workers, config.Warmup)
+
+	return load.measure(ctx, config.Workers, config.Requests), nil
+}
+
+func startClient(ctx context.Context, url, clientID, body string, config *Config) (*client.Client, error) {
+	clientConfig := client.NewConfig()
+	clientConfig.ID = clientID
+	clientConfig.Targets = []string{"ws" + strings.TrimPrefix(url, "http") + "/register"}
+	clientConfig.PoolIdleSize = config.PoolIdleSize
+	clientConfig.PoolMaxSize = config.PoolMaxSize
+	clientConfig.Logger = &mulch.DefaultLogger{Silent: true}
+	clientConfig.Handler = func(resp http.ResponseWriter, _ *http.Request) {
+		resp.Header().Set("Content-Type", "text/plain")
+		_, _ = io.WriteString(resp, body)
+	}
+
+	tunnel, err := client.NewClient(clientConfig)
+	if err != nil {
+		return nil, fmt.Errorf("creating client: %w", err)
+	}
+
+	tunnel.Start(ctx)
+
+	return tunnel, nil
+}
+
+// waitConnected sends a request to every client until they all respond.
+func waitConnected(ctx context.Context, httpClient *http.Client, url string, ids []string, size int) error {
+	ctx, cancel := context.WithTimeout(ctx, connectTimeout)
+	defer cancel()
+
+	for _, clientID := range ids {
+		for get(ctx, httpClient, url, clientID, size) != nil {
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("%w: %s", ErrNotConnected, clientID)
+			case <-time.After(10 * time.Millisecond): //nolint:gomnd
+			}
+		}
+	}
+
+	return nil
+}
+
+type loader struct {
+	client *http.Client
+	url    string
+	ids    []string
+	size   int
+	errors atomic.Int64
+}
+
+// send runs count requests across workers, and returns the latency of each.
+func (l *loader) send(ctx context.Context, workers, count int) []time.Duration {
+	var (
+		next      atomic.Int64
+		latencies = make([]time.Duration, count)
+		wait      sync.WaitGroup
+	)
+
+	for worker := 0; worker < workers; worker++ {
+		wait.Add(1)
+
+		go func() {
+			defer wait.Done()
+
+			for idx := int(next.Add(1) - 1); idx < count && ctx.Err() == nil; idx = int(next.Add(1) - 1) {
+				start := time.Now()
+				if err := get(ctx, l.client, l.url, l.ids[idx%len(l.ids)], l.size); err != nil {
+					l.errors.Add(1)
+				}
+
+				latencies[idx] = time.Since(start)
+			}
+		}()
+	}
+
+	wait.Wait()
+
+	return latencies
+}
+
+func (l *loader) measure(ctx context.Context, workers, count int) *Result {
+	var before, after runtime.MemStats
+
+	l.errors.Store(0)
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	start := time.Now()
+	latencies := l.send(ctx, workers, count)
+	elapsed := time.Since(start)
+
+	runtime.ReadMemStats(&after)
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	return &Result{
+		Requests:     count,
+		Errors:       l.errors.Load(),
+		Elapsed:      elapsed,
+		PerSecond:    float64(count) / elapsed.Seconds(),
+		P50:          latencies[count*50/100],
+		P90:          latencies[count*90/100],
+		P99:          latencies[count*99/100],
+		Max:          latencies[count-1],
+		AllocsPerReq: (after.Mallocs - before.Mallocs) / uint64(count),
+		BytesPerReq:  (after.TotalAlloc - before.TotalAlloc) / uint64(count),
+	}
+}
+
+func get(ctx context.Context, httpClient *http.Client, url, clientID string, size int) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url+"/bench", nil)
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+
+	req.Header.Set(idHeader, clientID)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("sending request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	read, err := io.Copy(io.Discard, resp.Body)
+	if err != nil {
+		return fmt.Errorf("reading response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK || read != int64(size) {
+		return fmt.Errorf("%w: status %d, %d bytes", ErrBadResponse, resp.StatusCode, read)
+	}
+
+	return nil
+}
@@ -0,0 +1,101 @@
+package mulery
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// certCheckInterval is how often the certificate files are checked for changes.
+const certCheckInterval = 10 * time.Second
+
+// certReloader serves a certificate from files, and reloads it when the files change.
+type certReloader struct {
+	certFile string
+	keyFile  string
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	modTime  time.Time // newest modification time of the two files.
+	checked  time.Time
+	logger   *Config
+}
+
+// newCertReloader loads the certificate files, and returns an error if they are invalid.
+func newCertReloader(config *Config) (*certReloader, error) {
+	reloader := &certReloader{certFile: config.SSLCertFile, keyFile: config.SSLKeyFile, logger: config}
+
+	return reloader, reloader.reload()
+}
+
+// reload reads the certificate files. The current certificate is kept if this fails.
+func (r *certReloader) reload() error {
+	modTime, err := r.newest()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("loading ssl certificate: %w", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.cert = &cert
+	r.modTime = modTime
+	r.checked = time.Now()
+
+	return nil
+}
+
+// newest returns the most recent modification time of the certificate and key files.
+func (r *certReloader) newest() (time.Time, error) {
+	var newest time.Time
+
+	for _, path := range []string{r.certFile, r.keyFile} {
+		stat, err := os.Stat(path)
+		if err != nil {
+			return newest, fmt.Errorf("checking ssl certificate file: %w", err)
+		}
+
+		if stat.ModTime().After(newest) {
+			newest = stat.ModTime()
+		}
+	}
+
+	return newest, nil
+}
+
+// changed returns true if it's time to check the files and they were modified.
+func (r *certReloader) changed() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if time.Since(r.checked) < certCheckInterval {
+		return false
+	}
+
+	r.checked = time.Now()
+	modTime, err := r.newest()
+
+	return err == nil && !modTime.Equal(r.modTime)
+}
+
+// getCertificate satisfies tls.Config.GetCertificate.
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if r.changed() {
+		if err := r.reload(); err != nil {
+			r.logger.Errorf("Reloading changed SSL certificate (keeping the old one): %v", err)
+		} else {
+			r.logger.Printf("Reloaded changed SSL certificate: %s", r.certFile)
+		}
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.cert, nil
+}
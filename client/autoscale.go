@@ -0,0 +1,64 @@
+package client
+
+import "time"
+
+// Autoscaling defaults used when the Config values are not set.
+const (
+	DefaultAutoScaleMin   = 1
+	DefaultAutoScaleDelay = time.Minute
+)
+
+// busyRatio is the share of busy connections (3 of 4) that doubles the idle target.
+const (
+	busyNumerator   = 3
+	busyDenominator = 4
+)
+
+// scale adjusts the pool's idle target when AutoScale is enabled. This runs in the pool's go routine.
+// The target doubles (up to PoolMaxSize) while most connections are busy, and halves
+// (down to AutoScaleMin) after AutoScaleDelay passes without a busy connection.
+func (p *Pool) scale(now time.Time, size *PoolSize) {
+	if !p.client.AutoScale {
+		return
+	}
+
+	switch open := size.Idle + size.Running; {
+	case open > 0 && size.Running*busyDenominator >= open*busyNumerator:
+		p.quietSince = time.Time{}
+
+		if grown := min(p.idleSize*2, p.maxSize); grown > p.idleSize { //nolint:gomnd
+			p.logger.Debugf("Scaling idle connections to %s up from %d to %d, busy: %d/%d",
+				p.target, p.idleSize, grown, size.Running, open)
+			p.idleSize = grown
+		}
+	case size.Running > 0:
+		p.quietSince = time.Time{}
+	case p.quietSince.IsZero():
+		p.quietSince = now
+	case now.Sub(p.quietSince) >= p.client.AutoScaleDelay:
+		p.quietSince = now // wait another delay before shrinking again.
+
+		if shrunk := max(p.idleSize/2, p.minSize); shrunk < p.idleSize { //nolint:gomnd
+			p.logger.Debugf("Scaling idle connections to %s down from %d to %d", p.target, p.idleSize, shrunk)
+			p.idleSize = shrunk
+		}
+	}
+}
+
+// closeSurplus drains one idle connection if there are more than the idle target.
+// One per tick keeps this gentle; the pool shrinks over a few seconds. The server is asked to
+// release the connection, so it is not closed under a request the server just dispatched.
+func (p *Pool) closeSurplus(size *PoolSize) {
+	if !p.client.AutoScale || size.Idle <= p.idleSize {
+		return
+	}
+
+	for _, conn := range p.connections {
+		if !conn.draining.Load() && conn.Status() == IDLE {
+			p.logger.Debugf("[%s] Draining surplus idle connection to %s, idle: %d/%d", conn.id, p.target, size.Idle, p.idleSize)
+			conn.drain()
+
+			return
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package client
+
+import (
+	"math/rand"
+	"time"
+)
+
+// DefaultBackoff is the first reconnect delay after a failed connection, if Backoff is not set.
+const DefaultBackoff = time.Second
+
+// backoffDelay returns how long to wait after the given number of consecutive failures.
+// The delay doubles with every failure up to maxDelay, and a random jitter of up to half
+// the delay is subtracted, so a fleet of clients does not reconnect to a restarted server in lock step.
+func backoffDelay(base, maxDelay time.Duration, failures int) time.Duration {
+	if failures < 1 {
+		return 0
+	}
+
+	delay := base
+	for i := 1; i < failures && delay < maxDelay; i++ {
+		delay *= 2
+	}
+
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+
+	if half := int64(delay / 2); half > 0 { //nolint:gomnd
+		delay -= time.Duration(rand.Int63n(half)) //nolint:gosec // jitter does not need crypto.
+	}
+
+	return delay
+}
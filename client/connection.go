@@ -2,30 +2,38 @@ package client
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"math/rand"
+	"net"
 	"net/http"
+	"runtime"
 	"runtime/debug"
 	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
 	"golift.io/mulery/mulch"
 )
 
+// ErrResponseTooLarge is returned when a response body is larger than MaxResponseBytes.
+var ErrResponseTooLarge = errors.New("response body too large")
+
 // Status of a Connection.
 const (
 	UNKNOWN    = -1
 	CONNECTING = iota
 	IDLE
 	RUNNING
+	DRAINING // Waiting for the server to release a surplus connection. Only reported in pool sizes.
 )
 
+// Keep-alive defaults used when the Config values are not set.
 const (
-	keepAliveInterval = 55 * time.Second
-	keepAliveTimeout  = 5 * time.Second
+	DefaultKeepAliveInterval = 55 * time.Second
+	DefaultKeepAliveTimeout  = 5 * time.Second
 )
 
 // Connection handle a single websocket (HTTP/TCP) connection to an Server.
@@ -36,45 +44,91 @@ type Connection struct {
 	setStatus chan int
 	getStatus chan int
 	id        string
+	waiting   bool              // true while waiting for a request; only used by the serve go routine.
+	connected time.Time         // when the greeting was sent; set before the connection joins the pool.
+	stream    bool              // true if the server accepts a streamed body for the current request.
+	framed    bool              // true if the current request arrived in a mulch.Frame, so the reply must be framed.
+	streamID  uint32            // the current request's frame stream ID.
+	failure   *mulch.ErrorFrame // set by the default handler, in middleware, when the backend request fails.
+	throttle  *throttle         // MaxConnBytesPerSecond; nil if unlimited.
+	logger    mulch.Logger
+	// reqLog has the current request's fields. Only used by the serve go routine.
+	reqLog mulch.Logger
+	// closeReason and closeText are sent by the server when it closes the connection. Read by the pool after removal.
+	closeReason mulch.CloseReason
+	closeText   string
+	// draining is set when the client asks the server to stop using this connection.
+	draining atomic.Bool
+	// Request body bytes received from, and response body bytes sent to, the server.
+	bytesRecv atomic.Int64
+	bytesSent atomic.Int64
+}
+
+// countReader counts the bytes read through it.
+type countReader struct {
+	io.Reader
+	count *atomic.Int64
+}
+
+func (r *countReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.count.Add(int64(n))
+
+	return n, err //nolint:wrapcheck // this is a passthrough.
 }
 
 // NewConnection creates a Connection object.
 func NewConnection(pool *Pool) *Connection {
-	return &Connection{
+	conn := &Connection{
 		pool:      pool,
 		status:    CONNECTING,
 		setStatus: make(chan int),
 		getStatus: make(chan int),
 		id:        strconv.Itoa(rand.Intn(899) + 100), //nolint:gomnd,gosec
+		throttle:  newThrottle(pool.client.MaxConnBytesPerSecond),
 	}
+	conn.logger = mulch.With(pool.logger, "conn", conn.id)
+	conn.reqLog = conn.logger
+
+	return conn
 }
 
 // Connect to the remote server using an HTTP websocket.
 func (c *Connection) Connect(ctx context.Context) error {
-	c.pool.client.Debugf("[%s] Connecting to tunnel @ %s", c.id, c.pool.target)
+	c.logger.Debugf("[%s] Connecting to tunnel @ %s", c.id, c.pool.target)
 
 	var err error
+
+	dialCtx, target := dialTarget(ctx, c.pool.target)
 	// Create a new TCP(/TLS) connection (no use of net.http).
 	//nolint:bodyclose // Gets closed in the Close() method.
-	c.ws, _, err = c.pool.client.dialer.DialContext(
-		ctx,
-		c.pool.target,
+	c.ws, _, err = c.pool.dialer.DialContext(
+		dialCtx,
+		target,
 		http.Header{mulch.SecretKeyHeader: {c.pool.secretKey}},
 	)
 	if err != nil {
 		return fmt.Errorf("[%s] tcp dialer failure: %w", c.id, err)
 	}
 
-	// c.ws.EnableWriteCompression(true)
-	// _ = c.ws.SetCompressionLevel(flate.BestCompression)
+	c.ws.EnableWriteCompression(c.pool.client.writeCompression())
+	_ = c.ws.SetCompressionLevel(mulch.CompressionLevel(c.pool.client.CompressionLevel))
+
+	c.ws.SetPongHandler(c.pong)
 
 	// Send the greeting message with proxy id and desired pool size.
 	greeting := &mulch.Handshake{
 		Name:      c.pool.client.Name,
-		ID:        c.pool.client.Config.ID,
-		Size:      c.pool.client.Config.PoolIdleSize,
-		MaxSize:   c.pool.client.Config.PoolMaxSize,
+		ID:        c.pool.id,
+		Size:      c.pool.idleSize,
+		MaxSize:   c.pool.maxSize,
 		ClientIDs: c.pool.client.ClientIDs,
+		Metadata:  c.pool.client.Metadata,
+		Frames:    true,
+		Version:   c.pool.client.Version,
+		GoVersion: runtime.Version(),
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
 	}
 
 	if err := c.ws.WriteJSON(greeting); err != nil {
@@ -82,24 +136,62 @@ func (c *Connection) Connect(ctx context.Context) error {
 		return fmt.Errorf("[%s] greeting failure: %w", c.id, err)
 	}
 
+	c.connected = time.Now()
+
+	if c.pool.client.HealthCheckURL != "" {
+		if err := c.sendHealth(time.Now().Add(c.pool.client.KeepAliveTimeout)); err != nil {
+			c.ws.Close()
+			return fmt.Errorf("[%s] %w", c.id, err)
+		}
+	}
+
 	// We are connected to the server, now start a go routine that waits for incoming server requests.
-	go c.serve()
-	go c.keepAlive()
+	c.pool.client.goRoutine(c.serve)
+	c.pool.client.goRoutine(c.keepAlive)
 
 	return nil
 }
 
+// drain asks the server to retire this idle connection with a mulch.DrainPayload ping. The server closes
+// an idle connection with mulch.CloseDrain, and serve removes it from the pool when the close frame arrives.
+// If the server sent a request before it read the ping, it answers with a pong instead. The request is
+// served as usual, and the connection is kept. See mulch.DrainPayload.
+func (c *Connection) drain() {
+	c.draining.Store(true)
+
+	deadline := time.Now().Add(c.pool.client.KeepAliveTimeout)
+	if err := c.ws.WriteControl(websocket.PingMessage, []byte(mulch.DrainPayload), deadline); err != nil {
+		c.logger.Errorf("[%s] Draining tunnel connection: %v", c.id, err)
+		c.ws.Close() // serve's read fails, and it removes the connection.
+	}
+}
+
 // Keep connection alive.
 func (c *Connection) keepAlive() {
-	ticker := time.NewTicker(keepAliveInterval)
+	ticker := time.NewTicker(c.pool.client.KeepAliveInterval)
 	defer ticker.Stop()
 
+	_, healthChanged := c.pool.client.health.get()
+
 	for {
 		select {
 		case tick := <-ticker.C:
-			err := c.ws.WriteControl(websocket.PingMessage, []byte{}, tick.Add(keepAliveTimeout))
+			var err error
+			// Health checks ride along with keep-alives, so the server stays in sync.
+			if c.pool.client.HealthCheckURL != "" {
+				err = c.sendHealth(tick.Add(c.pool.client.KeepAliveTimeout))
+			} else {
+				err = c.ws.WriteControl(websocket.PingMessage, []byte{}, tick.Add(c.pool.client.KeepAliveTimeout))
+			}
+
 			if err != nil {
-				c.pool.client.Errorf("[%s] Tunnel keep-alive failure: %v", c.id, err)
+				c.logger.Errorf("[%s] Tunnel keep-alive failure: %v", c.id, err)
+				return
+			}
+		case <-healthChanged:
+			_, healthChanged = c.pool.client.health.get()
+			if err := c.sendHealth(time.Now().Add(c.pool.client.KeepAliveTimeout)); err != nil {
+				c.logger.Errorf("[%s] Tunnel health report failure: %v", c.id, err)
 				return
 			}
 		case status, ok := <-c.setStatus:
@@ -117,6 +209,27 @@ func (c *Connection) keepAlive() {
 	}
 }
 
+// pong is called by the websocket library when the server answers a keep-alive ping.
+// While the connection is waiting for a request, every pong pushes back the read deadline.
+// A pong to a drain request means the server kept the connection.
+func (c *Connection) pong(payload string) error {
+	if payload == mulch.DrainPayload {
+		c.draining.Store(false)
+	}
+
+	if c.waiting {
+		c.extendDeadline()
+	}
+
+	return nil
+}
+
+// extendDeadline allows one keep-alive interval, plus the timeout, for the next pong.
+// If nothing is read from the server before then, the connection is half-open and the read fails.
+func (c *Connection) extendDeadline() {
+	_ = c.ws.SetReadDeadline(time.Now().Add(c.pool.client.KeepAliveInterval + c.pool.client.KeepAliveTimeout))
+}
+
 func (c *Connection) Status() int {
 	c.setStatus <- UNKNOWN
 	return <-c.getStatus
@@ -143,9 +256,9 @@ func (c *Connection) catchPanic() {
 	if r := recover(); r != nil {
 		// https://github.com/golang/go/blob/b100e127ca0e398fbb58d04d04e2443b50b3063e/src/runtime/chan.go#LL206C15-L206C15
 		if err, _ := r.(error); err != nil && err.Error() != "send on closed channel" { // ignore this specific panic.
-			c.pool.client.Errorf("[%s] panic error: %v\n%s", c.id, err, string(debug.Stack()))
+			c.logger.Errorf("[%s] panic error: %v\n%s", c.id, err, string(debug.Stack()))
 		} else if err == nil {
-			c.pool.client.Errorf("[%s] panic: %v\n%s", c.id, r, string(debug.Stack()))
+			c.logger.Errorf("[%s] panic: %v\n%s", c.id, r, string(debug.Stack()))
 		}
 	}
 }
@@ -157,78 +270,133 @@ func (c *Connection) serveHandler() bool {
 	// Read request
 	c.setStatus <- IDLE
 
-	_, jsonRequest, err := c.ws.ReadMessage()
+	c.waiting = true
+	c.extendDeadline()
+	msgType, requestReader, err := c.ws.NextReader()
+	c.waiting = false
+
 	if err != nil {
-		if !c.pool.shutdown {
-			c.pool.client.Errorf("[%s] While waiting for a tunnel request: %v", c.id, err)
+		var netErr net.Error
+
+		if c.draining.Load() {
+			c.logger.Debugf("[%s] Server released drained tunnel connection: %v", c.id, err)
+		} else if reason, text, ok := mulch.ReasonFromError(err); ok {
+			c.logger.Printf("[%s] Server closed tunnel connection, reason: %s (%s)", c.id, reason, text)
+			c.closeReason, c.closeText = reason, text
+		} else if errors.As(err, &netErr) && netErr.Timeout() {
+			c.logger.Errorf("[%s] No keep-alive reply from server in %v, closing dead tunnel connection",
+				c.id, c.pool.client.KeepAliveInterval+c.pool.client.KeepAliveTimeout)
+		} else if !c.pool.shutdown.Load() {
+			c.logger.Errorf("[%s] While waiting for a tunnel request: %v", c.id, err)
 		}
 
 		return false
 	}
 
+	if c.draining.Swap(false) {
+		// The server sent this before it read the drain request, so it keeps the connection.
+		c.logger.Debugf("[%s] Server sent a request on a draining tunnel connection, keeping it", c.id)
+	}
+
+	// Requests may take longer than the keep-alive, and the body is read by the handler.
+	_ = c.ws.SetReadDeadline(time.Time{})
+	c.reqLog = c.logger
 	c.setStatus <- RUNNING
+	c.pool.requests.Add(1)
 	c.pool.Remove(nil) // This triggers the pool to make a new connection.
 
+	if err := c.readRequestFrame(msgType, requestReader); err != nil {
+		c.error(mulch.ErrorProtocol, fmt.Sprintf("[%s] Reading tunnel request: %s", c.id, err))
+		return false
+	}
+
 	httpRequest := new(mulch.HTTPRequest) // Deserialize request.
-	if err := json.Unmarshal(jsonRequest, httpRequest); err != nil {
-		c.error(fmt.Sprintf("[%s] Deserializing json tunnel request: %s", c.id, err))
+	if err := mulch.DecodeFrame(requestReader, c.pool.client.MaxHeaderSize, httpRequest); err != nil {
+		c.error(mulch.ErrorProtocol, fmt.Sprintf("[%s] Deserializing json tunnel request: %s", c.id, err))
 		return false
 	}
 
 	req := mulch.UnserializeHTTPRequest(httpRequest)
-	handler := c.customHandler
+	c.reqLog = mulch.With(c.logger, "method", httpRequest.Method, "url", httpRequest.URL,
+		"request_id", httpRequest.RequestID)
+	c.stream = httpRequest.AcceptStream
 
-	if c.pool.client.Config.Handler == nil {
-		handler = c.defaultHandler
-		c.pool.client.Printf("[%s] %s %s", c.pool.client.Config.ID, req.Method, req.URL.String())
+	if c.pool.client.handler() == nil {
+		c.pool.client.rewrite(req) // Rules are checked against the rewritten URL.
+		c.reqLog.Printf("[%s] %s %s%s", c.pool.id, req.Method, req.URL.String(), requestIDSuffix(httpRequest))
 	}
 
 	// Pipe request body.
 	_, bodyReader, err := c.ws.NextReader()
 	if err != nil {
-		c.pool.client.Errorf("[%s] Getting tunnel response body reader: %v", c.id, err)
+		c.reqLog.Errorf("[%s] Getting tunnel response body reader: %v", c.id, err)
 		return false
 	}
 
-	// Create a "fake" body.
-	req.Body = io.NopCloser(bodyReader)
-	// Run defaultHandler or customHandler.
-	return handler(req)
-}
+	if c.framed {
+		if err := mulch.ExpectFrame(bodyReader, mulch.FrameBody, c.streamID); err != nil {
+			c.reqLog.Errorf("[%s] Reading tunnel request body: %v", c.id, err)
+			return false
+		}
+	}
 
-func (c *Connection) defaultHandler(req *http.Request) bool {
-	// This is where a local client sends the server's request off to the Internet.
-	resp, err := c.pool.client.client.Do(req)
-	if err != nil {
-		return !c.error(fmt.Sprintf("[%s] Executing tunneled request: %v", c.id, err))
+	if c.pool.shutdown.Load() {
+		return c.shuttingDown(req)
 	}
 
-	bodyWriter, err := c.writeResponseHeaders(resp)
-	if err != nil {
-		c.pool.client.Errorf("[%s] Making request: %v", c.id, err)
-		return false
+	if limit := int64(c.pool.client.MaxConcurrentRequests); limit > 0 {
+		if c.pool.client.active.Add(1) > limit {
+			c.pool.client.active.Add(-1)
+			return c.tooManyRequests(limit)
+		}
+
+		defer c.pool.client.active.Add(-1)
 	}
 
-	if _, err := io.Copy(bodyWriter, resp.Body); err != nil {
-		c.pool.client.Errorf("[%s] Getting tunnel pipe response body: %v", c.id, err)
-		return false
+	// Create a "fake" body.
+	req.Body = io.NopCloser(&countReader{Reader: bodyReader, count: &c.bytesRecv})
+	// Give up on the backend request when the server gives up on it.
+	req, cancel := mulch.WithTimeout(req, httpRequest.Timeout)
+	defer cancel()
+
+	if c.pool.client.handler() == nil {
+		req = c.pool.client.withDialCheck(req) // The default handler checks the addresses it dials.
 	}
 
-	resp.Body.Close()
-	bodyWriter.Close()
+	if err := c.pool.client.checkRules(req.Context(), req); err != nil {
+		return c.forbidden(err)
+	}
+
+	req, span := c.pool.client.tracer.start(req, httpRequest)
+	defer span.End()
+
+	// Run the handler chain; serveDefault is the handler unless a custom one is configured.
+	start := time.Now()
+	ok := c.serveChain(req)
 
-	return true
+	if c.logger.IsDebug() {
+		elapsed := time.Since(start)
+		mulch.With(c.reqLog, "duration", elapsed, "ok", ok).Debugf("[%s] Finished tunnel request %s %s in %v",
+			c.id, httpRequest.Method, httpRequest.URL, elapsed.Round(time.Microsecond))
+	}
+
+	return ok
 }
 
 func (c *Connection) writeResponseHeaders(resp *http.Response) (io.WriteCloser, error) {
+	return c.writeHeaders(mulch.SerializeHTTPResponse(resp), resp.Header)
+}
+
+// writeHeaders sends a serialized response to the server, and returns a writer for the (first) body message.
+func (c *Connection) writeHeaders(serialized []byte, header http.Header) (io.WriteCloser, error) {
 	// This is where we send the Internet's (http request) response back to the server.
-	err := c.ws.WriteMessage(websocket.TextMessage, mulch.SerializeHTTPResponse(resp))
+	err := c.writeMessage(mulch.FrameResponse, websocket.TextMessage, serialized)
 	if err != nil {
 		return nil, fmt.Errorf("[%s] writing tunnel response: %w", c.id, err)
 	}
 
 	// Pipe response body because an io.ReadCloser (http.Body) doesn't get serialized (above).
-	bodyWriter, err := c.ws.NextWriter(websocket.BinaryMessage)
+	bodyWriter, err := c.nextBodyWriter(header)
 	if err != nil {
 		return nil, fmt.Errorf("[%s] getting tunnel response body writer: %w", c.id, err)
 	}
@@ -236,24 +404,102 @@ func (c *Connection) writeResponseHeaders(resp *http.Response) (io.WriteCloser,
 	return bodyWriter, nil
 }
 
+// checkResponseSize returns an error if a response has a Content-Length larger than MaxResponseBytes.
+func (c *Client) checkResponseSize(resp *http.Response) error {
+	if c.MaxResponseBytes > 0 && resp.ContentLength > c.MaxResponseBytes {
+		return fmt.Errorf("%w: %d bytes, limit %d", ErrResponseTooLarge, resp.ContentLength, c.MaxResponseBytes)
+	}
+
+	return nil
+}
+
+// copyBody copies a response body, and returns an error if it has more than MaxResponseBytes.
+// The body is cut off at the limit.
+func (c *Client) copyBody(dst io.Writer, body io.Reader) (int64, error) {
+	if c.MaxResponseBytes <= 0 {
+		return io.Copy(dst, body) //nolint:wrapcheck // the callers wrap it.
+	}
+
+	size, err := io.Copy(dst, io.LimitReader(body, c.MaxResponseBytes))
+	if err != nil {
+		return size, err //nolint:wrapcheck // the callers wrap it.
+	}
+
+	if extra, _ := io.ReadFull(body, make([]byte, 1)); extra > 0 {
+		return size, fmt.Errorf("%w: more than %d bytes", ErrResponseTooLarge, c.MaxResponseBytes)
+	}
+
+	return size, nil
+}
+
+// refuse answers a request without running it. The unread request body is discarded when the next
+// request is read. Returns false if the response could not be written.
+func (c *Connection) refuse(status int, header http.Header, msg string) bool {
+	header.Set("Content-Type", "text/plain; charset=utf-8")
+
+	bodyWriter, err := c.writeResponseHeaders(&http.Response{
+		StatusCode:    status,
+		Header:        header,
+		ContentLength: int64(len(msg)),
+	})
+	if err != nil {
+		c.reqLog.Errorf("[%s] Writing tunnel response: %v", c.id, err)
+		return false
+	}
+
+	if _, err := io.WriteString(bodyWriter, msg); err != nil {
+		c.reqLog.Errorf("[%s] Writing tunnel response body: %v", c.id, err)
+		return false
+	}
+
+	return bodyWriter.Close() == nil
+}
+
+// tooManyRequests answers a request with 429 when MaxConcurrentRequests are already running.
+func (c *Connection) tooManyRequests(limit int64) bool {
+	c.pool.rejected.Add(1)
+	c.reqLog.Printf("[%s] Refusing tunnel request, %d requests already running", c.id, limit)
+
+	return c.refuse(http.StatusTooManyRequests, http.Header{"Retry-After": {"1"}}, "too many concurrent requests\n")
+}
+
+// forbidden answers a request with 403 when AllowRules or DenyRules refuse it.
+func (c *Connection) forbidden(err error) bool {
+	c.reqLog.Errorf("[%s] Refusing tunnel request: %v", c.id, err)
+	return c.refuse(http.StatusForbidden, make(http.Header), "request forbidden by client rules\n")
+}
+
+// shuttingDown answers a request with 503 when it arrives after Shutdown, and closes the connection.
+func (c *Connection) shuttingDown(req *http.Request) bool {
+	c.reqLog.Debugf("[%s] Refusing tunnel request during shutdown: %s %s", c.id, req.Method, req.URL)
+	c.refuse(http.StatusServiceUnavailable, http.Header{"Connection": {"close"}}, "client is shutting down\n")
+
+	return false
+}
+
 // error is called when an unrecoverable non-socket error happens in the request.
-// The two calls to this method are in the methods above.
+// The calls to this method are in the methods above. Servers that support frames get
+// an error frame with the kind of error; older servers get a 527 response.
 // Returns true if there's an error writing to the socket.
-func (c *Connection) error(msg string) bool {
-	c.pool.client.Errorf(msg)
+func (c *Connection) error(kind mulch.ErrorKind, msg string) bool {
+	c.reqLog.Errorf(msg)
+
+	if c.framed {
+		return c.sendError(mulch.NewErrorFrame(kind, msg)) != nil
+	}
 
 	resp := mulch.NewHTTPResponse(mulch.ClientErrorCode, int64(len(msg)))
 	// Write response
 	err := c.ws.WriteMessage(websocket.TextMessage, resp)
 	if err != nil {
-		c.pool.client.Errorf("[%s] Writing tunnel response: %v", c.id, err)
+		c.reqLog.Errorf("[%s] Writing tunnel response: %v", c.id, err)
 		return true
 	}
 
 	// Write response body
 	err = c.ws.WriteMessage(websocket.BinaryMessage, []byte(msg))
 	if err != nil {
-		c.pool.client.Errorf("[%s] Writing tunnel response body: %v", c.id, err)
+		c.reqLog.Errorf("[%s] Writing tunnel response body: %v", c.id, err)
 		return true
 	}
 
@@ -261,8 +507,23 @@ func (c *Connection) error(msg string) bool {
 }
 
 // Close the ws/tcp connection.
+// The server is told we are shutting down if the pool is shutting down.
 func (c *Connection) Close() {
-	c.ws.Close()
+	if c.pool.shutdown.Load() {
+		_ = mulch.CloseWithCode(c.ws, mulch.CloseShutdown, "client shutdown")
+	} else {
+		c.ws.Close()
+	}
+
 	close(c.setStatus)
 	close(c.getStatus)
 }
+
+// requestIDSuffix returns the request ID for the end of a log line. Older servers do not send one.
+func requestIDSuffix(httpRequest *mulch.HTTPRequest) string {
+	if httpRequest.RequestID == "" {
+		return ""
+	}
+
+	return ", request " + httpRequest.RequestID
+}
@@ -0,0 +1,184 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Address families used by the tunnel dialer.
+const (
+	NetworkAny  = "tcp"  // Dial IPv6 and IPv4 with happy-eyeballs fallback.
+	NetworkIPv4 = "tcp4" // Only dial IPv4 addresses.
+	NetworkIPv6 = "tcp6" // Only dial IPv6 addresses.
+)
+
+// DefaultFallbackDelay is how long we wait for the preferred address family
+// to connect before racing a connection on the other family.
+const DefaultFallbackDelay = 300 * time.Millisecond
+
+// dialResult is passed back from a racing dial go routine.
+type dialResult struct {
+	conn    net.Conn
+	err     error
+	primary bool
+}
+
+// UnixScheme is the target URL scheme for servers listening on a unix socket.
+// The socket path and the HTTP path are separated by a colon, and the HTTP path defaults to /register.
+// Example: ws+unix:///var/run/mulery.sock:/register
+const UnixScheme = "ws+unix"
+
+// unixSocketKey is the context key that holds the unix socket path for a dial.
+type unixSocketKey struct{}
+
+// dialTarget returns the websocket URL to dial for a target. Unix socket targets are
+// rewritten to a ws:// URL, and the socket path is stored in the returned context.
+func dialTarget(ctx context.Context, target string) (context.Context, string) {
+	socket, found := strings.CutPrefix(target, UnixScheme+"://")
+	if !found {
+		return ctx, target
+	}
+
+	path := "/register"
+	if idx := strings.Index(socket, ":"); idx != -1 {
+		socket, path = socket[:idx], socket[idx+1:]
+	}
+
+	return context.WithValue(ctx, unixSocketKey{}, socket), "ws://unix" + path
+}
+
+// ErrProxyScheme is returned when ProxyURL has a scheme we cannot dial.
+var ErrProxyScheme = errors.New("unsupported proxy scheme, use http or socks5")
+
+// proxy is passed into the websocket dialer. It returns the configured proxy URL, if any.
+// An invalid ProxyURL fails the dial, instead of connecting without the proxy.
+func (c *Client) proxy(req *http.Request) (*url.URL, error) {
+	if c.ProxyURL == "" || req.Context().Value(unixSocketKey{}) != nil {
+		return nil, nil //nolint:nilnil // no proxy is not an error.
+	}
+
+	return parseProxyURL(c.ProxyURL)
+}
+
+// parseProxyURL parses and validates a proxy URL.
+func parseProxyURL(proxy string) (*url.URL, error) {
+	proxyURL, err := url.Parse(proxy)
+	if err != nil {
+		return nil, fmt.Errorf("parsing proxy url: %w", err)
+	}
+
+	if proxyURL.Scheme != "http" && proxyURL.Scheme != "socks5" {
+		return nil, fmt.Errorf("%w: %s", ErrProxyScheme, proxyURL.Scheme)
+	}
+
+	return proxyURL, nil
+}
+
+// dialContext is passed into the websocket dialer.
+// This is where the configured address family preference is applied.
+func (c *Client) dialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if socket, ok := ctx.Value(unixSocketKey{}).(string); ok {
+		network, addr = "unix", socket
+	}
+
+	dialer := &net.Dialer{FallbackDelay: c.FallbackDelay}
+
+	if network == "unix" || c.NetDialContext != nil {
+		dial := dialer.DialContext
+		if c.NetDialContext != nil {
+			dial = c.NetDialContext
+		}
+
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, fmt.Errorf("dialing %s: %w", addr, err)
+		}
+
+		return conn, nil
+	}
+
+	if c.Network != "" && c.Network != NetworkAny {
+		network = c.Network
+	}
+
+	if network != NetworkAny || !c.PreferIPv4 {
+		// The standard library already does happy-eyeballs with IPv6 first.
+		conn, err := dialer.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, fmt.Errorf("dialing %s: %w", addr, err)
+		}
+
+		return conn, nil
+	}
+
+	return c.dialRace(ctx, dialer, addr)
+}
+
+// dialRace starts an IPv4 connection and races an IPv6 connection after FallbackDelay.
+// The first successful connection wins, and the loser is closed.
+func (c *Client) dialRace(ctx context.Context, dialer *net.Dialer, addr string) (net.Conn, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan dialResult, 2) //nolint:gomnd // buffered so a losing dial never blocks.
+	dial := func(network string, primary bool, delay time.Duration) {
+		if delay > 0 {
+			timer := time.NewTimer(delay)
+			defer timer.Stop()
+
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				results <- dialResult{err: ctx.Err(), primary: primary}
+				return
+			}
+		}
+
+		conn, err := dialer.DialContext(ctx, network, addr)
+		results <- dialResult{conn: conn, err: err, primary: primary}
+	}
+
+	delay := c.FallbackDelay
+	if delay == 0 {
+		delay = DefaultFallbackDelay
+	}
+
+	dials := 1
+	go dial(NetworkIPv4, true, 0)
+
+	if delay > 0 { // negative FallbackDelay disables the race.
+		dials++
+		go dial(NetworkIPv6, false, delay)
+	}
+
+	var firstErr error
+
+	for ; dials > 0; dials-- {
+		res := <-results
+		if res.err == nil {
+			go closeLosers(results, dials-1)
+			return res.conn, nil
+		}
+
+		if firstErr == nil || res.primary {
+			firstErr = res.err
+		}
+	}
+
+	return nil, fmt.Errorf("dialing %s: %w", addr, firstErr)
+}
+
+// closeLosers closes any connections that finish after a dial race was won.
+func closeLosers(results chan dialResult, count int) {
+	for ; count > 0; count-- {
+		if res := <-results; res.conn != nil {
+			res.conn.Close()
+		}
+	}
+}
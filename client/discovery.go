@@ -0,0 +1,112 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"golift.io/mulery/mulch"
+)
+
+// DefaultDiscoveryInterval is how often DiscoveryURL is polled if DiscoveryInterval is not set.
+const DefaultDiscoveryInterval = time.Minute
+
+// DiscoveryIDHeader contains the client ID in requests to DiscoveryURL.
+// The client's SecretKey is sent in the usual secret key header.
+const DiscoveryIDHeader = "X-Mulery-Client-ID"
+
+// maxDiscoveryBody is the largest discovery response we read.
+const maxDiscoveryBody = 1024 * 1024
+
+// ErrDiscovery is returned when the discovery URL returns something we cannot use.
+var ErrDiscovery = errors.New("target discovery failed")
+
+// DiscoveryResponse is the JSON document DiscoveryURL must return. Empty keys, IDs and pins use the Config values.
+// Example: {"targets":[{"url":"wss://mulery1.example.com/register"},{"url":"wss://m2.example.com/register","id":"x"}]}
+type DiscoveryResponse struct {
+	Targets []*DiscoveryTarget `json:"targets"`
+}
+
+// DiscoveryTarget is one target in a DiscoveryResponse.
+type DiscoveryTarget struct {
+	URL       string   `json:"url"`
+	SecretKey string   `json:"secretKey,omitempty"`
+	ID        string   `json:"id,omitempty"`
+	Pins      []string `json:"pins,omitempty"`
+	Weight    int      `json:"weight,omitempty"`
+}
+
+// pollDiscovery fetches the targets from DiscoveryURL every DiscoveryInterval until the context is cancelled.
+func (c *Client) pollDiscovery(ctx context.Context) {
+	ticker := time.NewTicker(c.DiscoveryInterval)
+	defer ticker.Stop()
+
+	current := make(map[string]bool)
+
+	for {
+		if found, err := c.discover(ctx); err != nil {
+			c.Errorf("Discovering targets from %s (keeping current targets): %v", c.DiscoveryURL, err)
+		} else {
+			c.syncTargets(ctx, c.DiscoveryURL, current, found)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// discover makes one request to DiscoveryURL and returns the targets, keyed by URL.
+func (c *Client) discover(ctx context.Context) (map[string]*Target, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.DiscoveryInterval)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.DiscoveryURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating discovery request: %w", err)
+	}
+
+	req.Header.Set(mulch.SecretKeyHeader, c.SecretKey)
+	req.Header.Set(DiscoveryIDHeader, c.ID)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("discovery request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil, fmt.Errorf("%w: %s", ErrDiscovery, resp.Status)
+	}
+
+	var discovered DiscoveryResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxDiscoveryBody)).Decode(&discovered); err != nil {
+		return nil, fmt.Errorf("%w: decoding response: %w", ErrDiscovery, err)
+	}
+
+	found := make(map[string]*Target, len(discovered.Targets))
+
+	for _, target := range discovered.Targets {
+		if target == nil || target.URL == "" || isSRV(target.URL) {
+			continue
+		}
+
+		found[target.URL] = &Target{
+			URL:       target.URL,
+			SecretKey: target.SecretKey,
+			ID:        target.ID,
+			Pins:      target.Pins,
+			Weight:    target.Weight,
+		}
+	}
+
+	return found, nil
+}
@@ -0,0 +1,108 @@
+package client
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"strings"
+)
+
+// FileServerOption changes the handler made by NewFileServerHandler.
+type FileServerOption func(*fileServer)
+
+type fileServer struct {
+	files    http.Handler
+	prefix   string // without a trailing slash.
+	username string
+	password string
+}
+
+// FileServerBasicAuth requires this username and password for every request.
+func FileServerBasicAuth(username, password string) FileServerOption {
+	return func(f *fileServer) { f.username, f.password = username, password }
+}
+
+// NewFileServerHandler returns a handler that serves the files in dir. The prefix (like /files/) is removed
+// from request paths before they are looked up in dir; paths outside the prefix, like /files-old, get a 404.
+// Paths may not leave dir, and hidden files (names that
+// begin with a dot) are neither served nor listed. Symbolic links inside dir are followed.
+// Use the returned handler as Config.HandlerHTTP.
+func NewFileServerHandler(dir, prefix string, opts ...FileServerOption) http.Handler {
+	prefix = strings.TrimSuffix(prefix, "/")
+	server := &fileServer{
+		files:  http.StripPrefix(prefix, http.FileServer(hiddenFS{http.Dir(dir)})),
+		prefix: prefix,
+	}
+
+	for _, opt := range opts {
+		opt(server)
+	}
+
+	return server
+}
+
+func (f *fileServer) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	// StripPrefix alone would serve /files-old/x from /files as -old/x.
+	if f.prefix != "" && req.URL.Path != f.prefix && !strings.HasPrefix(req.URL.Path, f.prefix+"/") {
+		http.NotFound(resp, req)
+		return
+	}
+
+	if f.username == "" && f.password == "" {
+		f.files.ServeHTTP(resp, req)
+		return
+	}
+
+	user, pass, _ := req.BasicAuth()
+	// Compare both, so the time taken does not say which one was wrong.
+	userOK := subtle.ConstantTimeCompare([]byte(user), []byte(f.username)) == 1
+	passOK := subtle.ConstantTimeCompare([]byte(pass), []byte(f.password)) == 1
+
+	if !userOK || !passOK {
+		resp.Header().Set("WWW-Authenticate", `Basic realm="mulery", charset="UTF-8"`)
+		http.Error(resp, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+
+		return
+	}
+
+	f.files.ServeHTTP(resp, req)
+}
+
+// hiddenFS does not open or list files and directories that begin with a dot.
+type hiddenFS struct {
+	http.FileSystem
+}
+
+// hiddenFile filters hidden files from directory listings.
+type hiddenFile struct {
+	http.File
+}
+
+func (h hiddenFS) Open(name string) (http.File, error) {
+	for _, part := range strings.Split(name, "/") {
+		if strings.HasPrefix(part, ".") {
+			return nil, fmt.Errorf("%w: %s", fs.ErrNotExist, name)
+		}
+	}
+
+	file, err := h.FileSystem.Open(name)
+	if err != nil {
+		return nil, err //nolint:wrapcheck // http.FileServer checks these errors.
+	}
+
+	return hiddenFile{File: file}, nil
+}
+
+func (h hiddenFile) Readdir(count int) ([]fs.FileInfo, error) {
+	files, err := h.File.Readdir(count)
+	filtered := files[:0]
+
+	for _, file := range files {
+		if !strings.HasPrefix(file.Name(), ".") {
+			filtered = append(filtered, file)
+		}
+	}
+
+	return filtered, err //nolint:wrapcheck // this is a passthrough.
+}
@@ -0,0 +1,43 @@
+package client
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileServerPrefix(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	for _, name := range []string{"a.txt", "-old/b.txt", ".hidden"} {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0o700); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	handler := NewFileServerHandler(dir, "/files/")
+
+	for reqPath, want := range map[string]int{
+		"/files/a.txt":      http.StatusOK,
+		"/files/-old/b.txt": http.StatusOK,
+		"/files/":           http.StatusOK,
+		"/files":            http.StatusOK,
+		"/files-old/b.txt":  http.StatusNotFound,
+		"/filesa.txt":       http.StatusNotFound,
+		"/files/.hidden":    http.StatusNotFound,
+	} {
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, reqPath, nil))
+
+		if resp.Code != want {
+			t.Errorf("%s: got %d, want %d", reqPath, resp.Code, want)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package client
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gorilla/websocket"
+	"golift.io/mulery/mulch"
+)
+
+// readRequestFrame reads the frame header from a request message. Servers that support frames send
+// requests in binary framed messages; older servers send them as text, and get unframed replies.
+func (c *Connection) readRequestFrame(msgType int, reader io.Reader) error {
+	c.framed = msgType == websocket.BinaryMessage
+	if !c.framed {
+		return nil
+	}
+
+	typ, streamID, err := mulch.ReadFrameHeader(reader)
+	if err != nil {
+		return err //nolint:wrapcheck // it is already descriptive.
+	}
+
+	if typ != mulch.FrameRequest {
+		return fmt.Errorf("%w: %s for stream %d, expected a request", mulch.ErrUnexpectedFrame, typ, streamID)
+	}
+
+	c.streamID = streamID
+
+	return nil
+}
+
+// writeMessage sends a message as a frame of typ when the request was framed, or as msgType when it was not.
+func (c *Connection) writeMessage(typ mulch.FrameType, msgType int, payload []byte) error {
+	if c.framed {
+		return mulch.WriteFrame(c.ws, &mulch.Frame{Type: typ, StreamID: c.streamID, Payload: payload})
+	}
+
+	return c.ws.WriteMessage(msgType, payload) //nolint:wrapcheck // the callers wrap it.
+}
+
+// sendError sends an error frame in place of a response. Only call this when the request was framed.
+func (c *Connection) sendError(frame *mulch.ErrorFrame) error {
+	if err := c.writeMessage(mulch.FrameError, websocket.BinaryMessage, frame.Marshal()); err != nil {
+		c.reqLog.Errorf("[%s] Writing tunnel error: %v", c.id, err)
+		return err
+	}
+
+	return nil
+}
+
+// nextBodyWriter returns a writer for a response body message, framed if the request was.
+func (c *Connection) nextBodyWriter(header http.Header) (io.WriteCloser, error) {
+	if c.framed {
+		return mulch.NextFrameWriter(c.ws, c.pool.client.writeCompression(), header, mulch.FrameBody, c.streamID)
+	}
+
+	return mulch.NextBodyWriter(c.ws, c.pool.client.writeCompression(), header)
+}
@@ -0,0 +1,58 @@
+package client
+
+import (
+	"context"
+	"time"
+
+	"golift.io/mulery/mulch"
+)
+
+// closedBy reacts to the reason a server gave for closing a connection. This runs in the pool's go routine.
+// See mulch.CloseReason.Reconnect for what each reason does.
+//   - ReconnectNever: remove the target, and call OnStop.
+//   - ReconnectElsewhere: round robin clients move to the next target now. Other clients wait a backoff
+//     before reconnecting, and their other targets carry the requests.
+//   - ReconnectLater: wait a backoff before replacing the connection.
+func (p *Pool) closedBy(ctx context.Context, now time.Time, conn *Connection) {
+	if p.goingAway || p.shutdown.Load() || conn.closeReason == "" {
+		return
+	}
+
+	switch reason := conn.closeReason; reason.Reconnect() {
+	case mulch.ReconnectNever:
+		p.goingAway = true
+		p.logger.Errorf("Server @ %s refuses this client (%s), no longer connecting: %s", p.target, reason, conn.closeText)
+		p.client.goRoutine(func() { p.client.stopTarget(ctx, p.target, reason, conn.closeText) })
+	case mulch.ReconnectElsewhere:
+		if p.client.RoundRobinConfig != nil {
+			p.goingAway = true
+			p.logger.Printf("Server @ %s is going away (%s), failing over: %s", p.target, reason, conn.closeText)
+			p.client.restart(ctx)
+
+			return
+		}
+
+		fallthrough
+	case mulch.ReconnectLater:
+		p.delay(now, backoffDelay(p.client.Backoff, p.client.MaxBackoff, 1))
+	case mulch.ReconnectNow:
+	}
+}
+
+// delay postpones the next connection attempt. An attempt that is already later is not moved.
+func (p *Pool) delay(now time.Time, delay time.Duration) {
+	if next := now.Add(delay); next.After(p.nextTry) {
+		p.nextTry = next
+	}
+}
+
+// stopTarget removes a target a server told us to stop connecting to, and calls OnStop.
+func (c *Client) stopTarget(ctx context.Context, target string, reason mulch.CloseReason, text string) {
+	if err := c.RemoveTarget(ctx, target); err != nil {
+		c.Errorf("Removing target %s: %v", target, err)
+	}
+
+	if c.OnStop != nil {
+		c.OnStop(ctx, target, reason, text)
+	}
+}
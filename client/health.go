@@ -0,0 +1,122 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"golift.io/mulery/mulch"
+)
+
+// ErrUnhealthy is returned when a backend health check gets a bad response.
+var ErrUnhealthy = errors.New("backend is unhealthy")
+
+// DefaultHealthCheckInterval is used when a HealthCheckURL is set without an interval.
+const DefaultHealthCheckInterval = 30 * time.Second
+
+// health tracks the backend health, and notifies connections when it changes.
+type health struct {
+	mu      sync.RWMutex
+	healthy bool
+	changed chan struct{} // closed and replaced when healthy changes.
+}
+
+func newHealth() *health {
+	return &health{healthy: true, changed: make(chan struct{})}
+}
+
+// get returns the current health, and a channel that is closed when it changes.
+func (h *health) get() (bool, chan struct{}) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return h.healthy, h.changed
+}
+
+// set updates the health and returns true if it changed.
+func (h *health) set(healthy bool) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.healthy == healthy {
+		return false
+	}
+
+	h.healthy = healthy
+	close(h.changed)
+	h.changed = make(chan struct{})
+
+	return true
+}
+
+// Healthy returns false if the last backend health check failed.
+// Always true if HealthCheckURL is not set.
+func (c *Client) Healthy() bool {
+	healthy, _ := c.health.get()
+	return healthy
+}
+
+// healthCheck probes the backend every interval until the context is cancelled.
+func (c *Client) healthCheck(ctx context.Context) {
+	ticker := time.NewTicker(c.HealthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		err := c.probe(ctx)
+		if c.health.set(err == nil) {
+			if err != nil {
+				c.Errorf("Backend health check failed, reporting degraded to server: %v", err)
+			} else {
+				c.Printf("Backend health check passed, reporting healthy to server.")
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// probe makes one request to the HealthCheckURL. Any 2xx or 3xx response is healthy.
+func (c *Client) probe(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, c.HealthCheckInterval)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.HealthCheckURL, nil)
+	if err != nil {
+		return fmt.Errorf("creating health check request: %w", err)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("health check request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("%w: %s", ErrUnhealthy, resp.Status)
+	}
+
+	return nil
+}
+
+// sendHealth reports the current backend health to the server in a ping frame.
+func (c *Connection) sendHealth(deadline time.Time) error {
+	healthy, _ := c.pool.client.health.get()
+
+	err := c.ws.WriteControl(websocket.PingMessage, []byte(mulch.HealthPayload(healthy)), deadline)
+	if err != nil {
+		return fmt.Errorf("sending health: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,77 @@
+package client
+
+import (
+	"crypto/rand"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LoadOrCreateID returns the client ID saved in a file. If the file does not exist, or is empty,
+// a random UUID is created and saved in it. Use this to keep the same ID across restarts:
+//
+//	config.ID, err = client.LoadOrCreateID("/var/lib/myapp/client-id")
+func LoadOrCreateID(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return "", fmt.Errorf("reading client id: %w", err)
+	}
+
+	if id := strings.TrimSpace(string(data)); id != "" {
+		return id, nil
+	}
+
+	id, err := newUUID()
+	if err != nil {
+		return "", err
+	}
+
+	if err := writeID(path, id); err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+// newUUID returns a random (version 4) UUID.
+func newUUID() (string, error) {
+	uuid := make([]byte, 16) //nolint:gomnd
+
+	if _, err := rand.Read(uuid); err != nil {
+		return "", fmt.Errorf("creating client id: %w", err)
+	}
+
+	uuid[6] = (uuid[6] & 0x0f) | 0x40 //nolint:gomnd // version 4
+	uuid[8] = (uuid[8] & 0x3f) | 0x80 //nolint:gomnd // variant 10
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:]), nil
+}
+
+// writeID saves the ID with a rename, so a crash never leaves a partial ID behind.
+func writeID(path, id string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil { //nolint:gomnd
+		return fmt.Errorf("creating client id directory: %w", err)
+	}
+
+	temp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("creating client id file: %w", err)
+	}
+	defer os.Remove(temp.Name()) // Does nothing after the rename.
+
+	if _, err := temp.WriteString(id + "\n"); err != nil {
+		temp.Close()
+		return fmt.Errorf("writing client id: %w", err)
+	}
+
+	if err := temp.Close(); err != nil {
+		return fmt.Errorf("writing client id: %w", err)
+	}
+
+	if err := os.Rename(temp.Name(), path); err != nil {
+		return fmt.Errorf("saving client id: %w", err)
+	}
+
+	return nil
+}
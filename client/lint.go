@@ -0,0 +1,62 @@
+package client
+
+import (
+	"fmt"
+
+	"golift.io/mulery/mulch"
+)
+
+// Lint returns warnings about suspicious configuration combinations. Settings that cannot work are
+// errors from Validate instead. NewClient logs these; call it yourself to refuse to start with a questionable config.
+func (c *Config) Lint() []string {
+	var warnings []string
+
+	if len(c.targets()) == 0 && c.DiscoveryURL == "" {
+		warnings = append(warnings, "Targets, TargetList and DiscoveryURL are empty: "+
+			"nothing connects until AddTarget is called")
+	}
+
+	if c.ReadBufferSize < 0 || c.WriteBufferSize < 0 {
+		warnings = append(warnings, "ReadBufferSize and WriteBufferSize may not be negative: the default (4KB) is used")
+	}
+
+	if c.CompressionLevel != 0 && c.CompressionLevel != mulch.CompressionLevel(c.CompressionLevel) {
+		warnings = append(warnings, fmt.Sprintf("CompressionLevel (%d) is not between -2 and 9: level %d is used",
+			c.CompressionLevel, mulch.DefaultCompressionLevel))
+	}
+
+	if c.AutoScale && c.AutoScaleMin > c.PoolMaxSize {
+		warnings = append(warnings, fmt.Sprintf("AutoScaleMin (%d) is larger than PoolMaxSize (%d): "+
+			"the pool never shrinks below PoolMaxSize", c.AutoScaleMin, c.PoolMaxSize))
+	}
+
+	if c.Handler != nil && c.HandlerHTTP != nil {
+		warnings = append(warnings, "Handler and HandlerHTTP are both set: Handler is ignored")
+	}
+
+	if (c.LocalTarget != "" || c.LocalSocket != "") && c.handler() != nil {
+		warnings = append(warnings, "LocalTarget or LocalSocket is set with a custom handler: they are ignored")
+	}
+
+	if c.MaxBytesPerSecond < 0 || c.MaxConnBytesPerSecond < 0 {
+		warnings = append(warnings, "MaxBytesPerSecond and MaxConnBytesPerSecond may not be negative: "+
+			"responses are not limited")
+	}
+
+	if c.MaxResponseBytes < 0 {
+		warnings = append(warnings, fmt.Sprintf("MaxResponseBytes (%d) is negative: responses are not limited",
+			c.MaxResponseBytes))
+	}
+
+	if c.MaxConcurrentRequests < 0 {
+		warnings = append(warnings, fmt.Sprintf("MaxConcurrentRequests (%d) is negative: requests are not limited",
+			c.MaxConcurrentRequests))
+	}
+
+	if c.MaxBackoff > 0 && c.Backoff > c.MaxBackoff {
+		warnings = append(warnings, fmt.Sprintf("Backoff (%v) is larger than MaxBackoff (%v): "+
+			"every failure waits MaxBackoff", c.Backoff, c.MaxBackoff))
+	}
+
+	return warnings
+}
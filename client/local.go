@@ -0,0 +1,97 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// localDialTimeout matches the http.DefaultTransport dialer.
+const localDialTimeout = 30 * time.Second
+
+// ErrLocalTarget is returned when LocalTarget is not an http or https base URL.
+var ErrLocalTarget = errors.New("local target must be an http or https url with a host")
+
+// parseLocalTarget parses and validates a LocalTarget base URL.
+func parseLocalTarget(target string) (*url.URL, error) {
+	base, err := url.Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("parsing local target: %w", err)
+	}
+
+	if (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return nil, fmt.Errorf("%w: %s", ErrLocalTarget, target)
+	}
+
+	return base, nil
+}
+
+// newLocalClient returns the http client used by the default handler.
+// If a socket is provided, every request is sent to it. Otherwise, when rules have CIDRs,
+// every dialed address is checked against the rules.
+func (c *Client) newLocalClient() *http.Client {
+	if c.LocalSocket == "" && !c.needAddrs() {
+		return &http.Client{}
+	}
+
+	transport, _ := http.DefaultTransport.(*http.Transport)
+	transport = transport.Clone()
+	transport.Proxy = nil // The dialed address must be the backend's, not a proxy's.
+
+	if c.LocalSocket == "" {
+		dialer := &net.Dialer{Timeout: localDialTimeout, KeepAlive: localDialTimeout, ControlContext: c.controlDial}
+		transport.DialContext = dialer.DialContext
+
+		return &http.Client{Transport: transport}
+	}
+
+	transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+		var dialer net.Dialer
+		return dialer.DialContext(ctx, "unix", c.LocalSocket)
+	}
+
+	return &http.Client{Transport: transport}
+}
+
+// rewrite points a tunneled request at LocalTarget, keeping its path and query.
+// The base path is prefixed to the request path. The Host header is not changed,
+// unless the request is sent to LocalSocket.
+func (c *Client) rewrite(req *http.Request) {
+	if c.LocalSocket != "" {
+		req.RequestURI = ""
+		req.Host = "" // Use the URL host.
+
+		if c.local == nil {
+			req.URL.Scheme = "http"
+			req.URL.Host = "localhost"
+
+			return
+		}
+	}
+
+	if c.local == nil {
+		return
+	}
+
+	req.RequestURI = ""
+	req.URL.Scheme = c.local.Scheme
+	req.URL.Host = c.local.Host
+	req.URL.User = c.local.User
+
+	if req.URL.RawPath != "" {
+		req.URL.RawPath = strings.TrimSuffix(c.local.EscapedPath(), "/") + req.URL.RawPath
+	}
+
+	req.URL.Path = strings.TrimSuffix(c.local.Path, "/") + req.URL.Path
+
+	if req.URL.RawQuery == "" {
+		req.URL.RawQuery = c.local.RawQuery
+	} else if c.local.RawQuery != "" {
+		req.URL.RawQuery = c.local.RawQuery + "&" + req.URL.RawQuery
+	}
+}
@@ -1,39 +1,224 @@
 package client
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"runtime/debug"
+	"strconv"
 	"sync"
+
+	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golift.io/mulery/mulch"
 )
 
 /* This file turns http.ResponseWriter into an http.Response. */
 
+// ErrAborted is returned when a handler panics after it sent the headers, like with http.ErrAbortHandler.
+var ErrAborted = errors.New("handler aborted the response")
+
+// connectionKey is the context key for the connection serving a request to the default handler.
+type connectionKey struct{}
+
 type req2Handler struct {
 	req  *http.Request
 	resp *http.Response
 	conn *Connection
-	body io.WriteCloser
+	body io.WriteCloser // the current body message; nil before the headers and after a Flush.
 	mu   sync.Mutex
 	err  error
+	// stream is true when the body is sent in chunks, so Flush works. See mulch.HTTPResponse.Stream.
+	stream  bool
+	header  bool  // true after the headers are sent.
+	pending int64 // bytes written to the current body message.
 }
 
-// customHandler builds the logic to convert an http.ResponseWriter into an http.Response.
-// Returns true on success (ok), false on error.
-func (c *Connection) customHandler(req *http.Request) bool {
+// serveChain runs the handler chain with an http.ResponseWriter that writes to the tunnel.
+// Returns true on success (ok), false on error. A panic in the handler is answered with a 500.
+func (c *Connection) serveChain(req *http.Request) (ok bool) {
 	writer := &req2Handler{
-		req:  req,
-		resp: &http.Response{Header: make(http.Header)},
-		conn: c,
+		req:    req,
+		resp:   &http.Response{Header: make(http.Header), ContentLength: -1}, // unknown until the handler returns.
+		conn:   c,
+		stream: c.stream,
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			writer.recovered(r)
+		}
+
+		writer.finish()
+		ok = writer.err == nil
+	}()
+
+	if c.pool.client.handler() == nil { // The default handler is wrapped in middleware.
+		c.failure = nil
+		req = req.WithContext(context.WithValue(req.Context(), connectionKey{}, c))
+	}
+
+	c.pool.client.chain.ServeHTTP(writer, req)
+
+	return true // replaced in the deferred function.
+}
+
+// recovered answers the request with a 500 after the handler panics.
+// If the handler already sent the headers, the response is incomplete, so the tunnel connection
+// is closed without ending the body. The server does not pass on a truncated body as complete.
+func (r *req2Handler) recovered(panicked any) {
+	if panicked != http.ErrAbortHandler { //nolint:errorlint,goerr113 // this is how net/http checks it.
+		r.conn.reqLog.Errorf("[%s] Handler panic: %s %s: %v\n%s",
+			r.conn.id, r.req.Method, r.req.URL, panicked, string(debug.Stack()))
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.header {
+		r.err = ErrAborted // Close the tunnel connection, so the server does not see a complete body.
+		return
+	}
+
+	msg := fmt.Sprintf("handler panic: %v\n", panicked)
+	r.resp.Header = http.Header{"Content-Type": {"text/plain; charset=utf-8"}}
+	r.resp.ContentLength = int64(len(msg))
+	r.writeHeader(http.StatusInternalServerError)
+
+	if r.err == nil {
+		size, err := io.WriteString(r.body, msg)
+		r.pending += int64(size)
+		r.err = err
+	}
+}
+
+// finish sends the end of the body. A streamed body ends with an empty message.
+func (r *req2Handler) finish() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if errors.Is(r.err, ErrAborted) {
+		return // Leave the body unfinished; the connection is closed.
+	}
+
+	if !r.header && r.conn.failure != nil {
+		r.header = true
+		r.err = r.conn.sendError(r.conn.failure)
+
+		return
+	}
+
+	if !r.header {
+		r.writeHeader(http.StatusOK)
 	}
 
-	c.pool.client.Config.Handler(writer, req)
+	if r.body != nil {
+		if err := r.body.Close(); err != nil && r.err == nil {
+			r.err = err
+		}
 
-	if writer.body != nil {
-		writer.body.Close()
+		if !r.stream || r.pending == 0 {
+			return // An empty message was just sent.
+		}
 	}
 
-	return writer.err == nil
+	if r.stream && r.err == nil {
+		r.err = r.conn.writeMessage(mulch.FrameBody, websocket.BinaryMessage, nil)
+	}
+}
+
+// handler returns the custom handler for proxied requests, or nil if there is none.
+func (c *Config) handler() http.Handler {
+	if c.HandlerHTTP != nil {
+		return c.HandlerHTTP
+	}
+
+	if c.Handler != nil {
+		return http.HandlerFunc(c.Handler)
+	}
+
+	return nil
+}
+
+// buildChain wraps the custom handler in the middleware. Without a custom handler, serveDefault is wrapped.
+func (c *Client) buildChain() http.Handler {
+	chain := c.handler()
+	if chain == nil {
+		chain = http.HandlerFunc(c.serveDefault)
+	}
+
+	for idx := len(c.Middleware) - 1; idx >= 0; idx-- {
+		if c.Middleware[idx] != nil {
+			chain = c.Middleware[idx](chain)
+		}
+	}
+
+	return chain
+}
+
+// serveDefault is the default handler. This is where a client sends the server's request off to the Internet.
+// It runs when there is no custom handler, wrapped in middleware if there is any.
+func (c *Client) serveDefault(resp http.ResponseWriter, req *http.Request) {
+	c.tracer.inject(req)
+
+	span := trace.SpanFromContext(req.Context())
+	conn, _ := req.Context().Value(connectionKey{}).(*Connection)
+	logger := c.Logger
+
+	if conn != nil {
+		logger = conn.reqLog
+	}
+
+	res, err := c.client.Do(req)
+	if errors.Is(err, ErrForbidden) {
+		fail(span, err)
+		logger.Errorf("Refusing tunneled request: %v", err)
+		http.Error(resp, "request forbidden by client rules", http.StatusForbidden)
+
+		return
+	} else if err != nil {
+		fail(span, err)
+		logger.Errorf("Executing tunneled request: %v", err)
+
+		msg := "Executing tunneled request: " + err.Error()
+		if conn != nil && conn.framed {
+			conn.failure = mulch.NewErrorFrame(mulch.ErrorKindOf(err), msg) // Sent when the handler returns.
+		} else {
+			http.Error(resp, msg, mulch.ClientErrorCode)
+		}
+
+		return
+	}
+	defer res.Body.Close()
+
+	span.SetAttributes(attribute.Int("http.response.status_code", res.StatusCode))
+
+	if err := c.checkResponseSize(res); err != nil {
+		fail(span, err)
+		logger.Errorf("Refusing tunneled response: %v", err)
+		http.Error(resp, "response body too large", http.StatusBadGateway)
+
+		return
+	}
+
+	for key, values := range res.Header {
+		resp.Header()[key] = values
+	}
+
+	resp.WriteHeader(res.StatusCode)
+
+	body := io.Writer(resp)
+	if conn != nil {
+		body = conn.throttleWriter(req.Context(), resp)
+	}
+
+	if _, err := c.copyBody(body, res.Body); err != nil {
+		logger.Errorf("Copying tunneled response body: %v", err)
+		panic(http.ErrAbortHandler) // The response is incomplete.
+	}
 }
 
 // Write satisfies the ResponseWriter interface and handles
@@ -42,16 +227,23 @@ func (r *req2Handler) Write(data []byte) (int, error) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	if r.body == nil {
-		r.WriteHeader(http.StatusOK)
+	if !r.header {
+		r.writeHeader(http.StatusOK)
+	} else if r.body == nil && r.err == nil && len(data) > 0 { // Start the next chunk after a Flush.
+		r.body, r.err = r.conn.nextBodyWriter(r.resp.Header)
 	}
 
-	if r.body == nil {
+	if r.err != nil {
 		return 0, fmt.Errorf("[%s] tunnel write failed: %w", r.conn.id, r.err)
 	}
 
+	if r.body == nil {
+		return 0, nil
+	}
+
 	size, err := r.body.Write(data)
-	r.resp.ContentLength += int64(size)
+	r.pending += int64(size)
+	r.conn.bytesSent.Add(int64(size))
 
 	if err != nil {
 		r.err = err
@@ -64,9 +256,48 @@ func (r *req2Handler) Write(data []byte) (int, error) {
 // WriteHeader satisfies the ResponseWriter interface and sends the response
 // body off to the server.
 func (r *req2Handler) WriteHeader(statusCode int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.header {
+		r.writeHeader(statusCode)
+	}
+}
+
+func (r *req2Handler) writeHeader(statusCode int) {
+	r.header = true
 	r.resp.StatusCode = statusCode
-	r.resp.Status = http.StatusText(statusCode)
-	r.body, r.err = r.conn.writeResponseHeaders(r.resp)
+	r.resp.Status = strconv.Itoa(statusCode) + " " + http.StatusText(statusCode)
+
+	if size, err := strconv.ParseInt(r.resp.Header.Get("Content-Length"), 10, 64); err == nil && size >= 0 {
+		r.resp.ContentLength = size // Like net/http, trust the handler's Content-Length.
+	}
+
+	if r.stream {
+		r.body, r.err = r.conn.writeHeaders(mulch.SerializeStreamResponse(r.resp), r.resp.Header)
+	} else {
+		r.body, r.err = r.conn.writeResponseHeaders(r.resp)
+	}
+}
+
+// Flush satisfies the http.Flusher interface. The body written so far is sent to the server in its own
+// message, and the server flushes it to the http client. Servers that do not accept streamed bodies
+// (see mulch.HTTPResponse.Stream) get the whole body when the handler returns; Flush does nothing.
+func (r *req2Handler) Flush() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.header {
+		r.writeHeader(http.StatusOK)
+	}
+
+	if !r.stream || r.body == nil || r.pending == 0 || r.err != nil {
+		return
+	}
+
+	r.err = r.body.Close()
+	r.body = nil
+	r.pending = 0
 }
 
 // Header returns the response headers.
@@ -74,5 +305,8 @@ func (r *req2Handler) Header() http.Header {
 	return r.resp.Header
 }
 
-// Make sure the req2Handler satisfies the ResponseWriter interface.
-var _ = http.ResponseWriter(&req2Handler{})
+// Make sure the req2Handler satisfies the ResponseWriter and Flusher interfaces.
+var (
+	_ = http.ResponseWriter(&req2Handler{})
+	_ = http.Flusher(&req2Handler{})
+)
@@ -0,0 +1,94 @@
+package client
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+// ErrPinMismatch is returned when no certificate presented by a server matches the target's pins.
+var ErrPinMismatch = errors.New("server certificate does not match any pinned public key")
+
+// pinPrefix is optional in pins, and matches the format used by curl and HPKP.
+const pinPrefix = "sha256/"
+
+// parsePins decodes pins into SHA-256 hashes. A pin is the base64 SHA-256 hash of a certificate's
+// subject public key info, optionally prefixed with sha256/. Create one with:
+//
+//	openssl x509 -in cert.pem -pubkey -noout | openssl pkey -pubin -outform der |
+//	  openssl dgst -sha256 -binary | base64
+func parsePins(pins []string) ([][]byte, error) {
+	hashes := make([][]byte, 0, len(pins))
+
+	for _, pin := range pins {
+		hash, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(strings.TrimSpace(pin), pinPrefix))
+		if err != nil {
+			return nil, fmt.Errorf("decoding pin %q: %w", pin, err)
+		}
+
+		if len(hash) != sha256.Size {
+			return nil, fmt.Errorf("pin %q is %d bytes, not a sha256 hash", pin, len(hash)) //nolint:goerr113
+		}
+
+		hashes = append(hashes, hash)
+	}
+
+	return hashes, nil
+}
+
+// pinnedDialer returns a copy of the client's dialer that verifies the target's pins during the TLS
+// handshake. The client's dialer is returned if the target has no pins. Invalid pins never match,
+// so connections fail instead of going unpinned. Validate reports them.
+func (c *Client) pinnedDialer(target *Target) *websocket.Dialer {
+	if len(target.Pins) == 0 {
+		return c.dialer
+	}
+
+	hashes, err := parsePins(target.Pins)
+	if err != nil {
+		c.Errorf("Target %s has invalid pins, connections will fail: %v", target.URL, err)
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if c.dialer.TLSClientConfig != nil {
+		tlsConfig = c.dialer.TLSClientConfig.Clone()
+	}
+
+	tlsConfig.VerifyConnection = func(state tls.ConnectionState) error { return verifyPins(state, hashes) }
+	dialer := *c.dialer
+	dialer.TLSClientConfig = tlsConfig
+
+	return &dialer
+}
+
+// verifyPins returns nil if the leaf, or a certificate in a verified chain, matches a pin.
+// Certificates that are only presented, and not part of a verified chain, are not trusted.
+func verifyPins(state tls.ConnectionState, hashes [][]byte) error {
+	if len(state.PeerCertificates) == 0 {
+		return ErrPinMismatch
+	}
+
+	certs := []*x509.Certificate{state.PeerCertificates[0]}
+	for _, chain := range state.VerifiedChains {
+		certs = append(certs, chain...)
+	}
+
+	for _, cert := range certs {
+		sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+
+		for _, hash := range hashes {
+			if bytes.Equal(sum[:], hash) {
+				return nil
+			}
+		}
+	}
+
+	return ErrPinMismatch
+}
@@ -3,7 +3,11 @@ package client
 import (
 	"context"
 	"fmt"
+	"sync/atomic"
 	"time"
+
+	"github.com/gorilla/websocket"
+	"golift.io/mulery/mulch"
 )
 
 // Pool of connections to a remote Server.
@@ -11,16 +15,33 @@ type Pool struct {
 	client      *Client
 	target      string
 	secretKey   string
+	id          string
+	dialer      *websocket.Dialer // verifies the target's pins.
 	connections []*Connection
 	disconnects int
+	bytesRecv   int64 // from removed connections.
+	bytesSent   int64 // from removed connections.
 	done        chan struct{}
 	getSize     chan struct{}
 	repSize     chan *PoolSize
 	conChan     chan *Connection
 	repChan     chan struct{}
-	shutdown    bool
+	shutdown    atomic.Bool
 	lastTry     time.Time
-	backOff     time.Duration
+	nextTry     time.Time // no connections are attempted before this time.
+	failures    int       // consecutive failed connection attempts.
+	backoff     time.Duration
+	lastErr     error
+	lastErrTime time.Time
+	connected   time.Time // last successful connection.
+	idleSize    int       // idle connections to maintain; changes with AutoScale.
+	minSize     int       // fewest idle connections AutoScale keeps; AutoScaleMin, or less for a light target.
+	maxSize     int       // PoolMaxSize after the target's weight.
+	quietSince  time.Time // when the pool last had no busy connections, for AutoScale.
+	goingAway   bool      // the server said it is going away, and this pool is failing over or stopping.
+	logger      mulch.Logger
+	requests    atomic.Int64 // requests served by every connection.
+	rejected    atomic.Int64 // requests answered with 429 because of MaxConcurrentRequests.
 }
 
 // PoolSize represent the number of open connections per status.
@@ -33,37 +54,77 @@ type PoolSize struct {
 	LastConn    time.Time
 	LastTry     time.Time
 	Active      bool
+	// BytesRecv is the count of request body bytes received from the server.
+	BytesRecv int64
+	// BytesSent is the count of response body bytes sent to the server.
+	BytesSent int64
+	// Failures is the count of consecutive failed connection attempts. Zero after a success.
+	Failures int
+	// Backoff is the delay that was chosen after the last failed attempt.
+	Backoff time.Duration
+	// NextTry is the earliest time the next connection attempt is made.
+	NextTry time.Time
+	// IdleTarget is the number of idle connections the pool maintains.
+	// This is PoolIdleSize after the target's weight, unless AutoScale changed it.
+	IdleTarget int
+	// LastConnect is the last time a connection to the target was made.
+	LastConnect time.Time
+	// Requests is the count of requests served through this target.
+	Requests int64
+	// Rejected is the count of requests refused because MaxConcurrentRequests were already running.
+	Rejected int64
+	// LastError is the error from the most recent failed connection attempt, and when it happened.
+	// These are kept after a successful connection.
+	LastError     string
+	LastErrorTime time.Time
+	// Connections describes each open connection, oldest first.
+	Connections []*ConnectionInfo
+}
+
+// ConnectionInfo describes one connection in a pool.
+type ConnectionInfo struct {
+	ID     string
+	Status string // connecting, idle or running.
+	// Connected is when the connection was made, and Age is how long ago that was.
+	Connected time.Time
+	Age       time.Duration
 }
 
 // StartPool creates and starts a pool in one command.
-func StartPool(ctx context.Context, client *Client, target string, secretKey string) *Pool {
-	pool := NewPool(client, target, secretKey)
+func StartPool(ctx context.Context, client *Client, target *Target) *Pool {
+	pool := NewPool(client, target)
 	pool.Start(ctx)
 
 	return pool
 }
 
 // NewPool creates a new Pool.
-func NewPool(client *Client, target string, secretKey string) *Pool {
+func NewPool(client *Client, target *Target) *Pool {
 	return &Pool{
 		client:      client,
-		target:      target,
-		secretKey:   secretKey,
+		target:      target.URL,
+		secretKey:   target.SecretKey,
+		id:          target.ID,
+		dialer:      client.pinnedDialer(target),
+		logger:      mulch.With(client.Logger, "target", target.URL, "clientId", target.ID),
+		idleSize:    weighted(client.PoolIdleSize, target.Weight),
+		minSize:     min(client.AutoScaleMin, weighted(client.PoolMaxSize, target.Weight)),
+		maxSize:     weighted(client.PoolMaxSize, target.Weight),
 		connections: []*Connection{},
 		done:        make(chan struct{}),
 		getSize:     make(chan struct{}),
 		repSize:     make(chan *PoolSize),
 		conChan:     make(chan *Connection),
 		repChan:     make(chan struct{}),
-		backOff:     time.Second,
 	}
 }
 
-// Start connects to the remote server and runs a ticker loop to maintain the connection.
+// Start runs a go routine that connects to the remote server and runs a ticker loop to maintain the connection.
+// It returns before the first connection is made, so callers holding the client lock do not wait on a dial.
 func (p *Pool) Start(ctx context.Context) {
-	p.connector(ctx, time.Now())
+	p.client.goRoutine(func() {
+		p.connector(ctx, time.Now())
 
-	go func() {
 		ticker := time.NewTicker(p.client.CleanInterval)
 
 		defer func() {
@@ -91,12 +152,13 @@ func (p *Pool) Start(ctx context.Context) {
 					p.connector(ctx, time.Now())
 				} else {
 					p.remove(conn)
+					p.closedBy(ctx, time.Now(), conn)
 				}
 
 				p.repChan <- struct{}{}
 			}
 		}
-	}()
+	})
 }
 
 // The garbage collector runs every second or so.
@@ -104,18 +166,16 @@ func (p *Pool) Start(ctx context.Context) {
 // then N go functions are created that add additional pool connections.
 // If the connection fails, the connection is removed from the pool.
 func (p *Pool) connector(ctx context.Context, now time.Time) {
-	if p.backOff > p.client.MaxBackoff {
-		p.backOff = p.client.BackoffReset // keep bringing it back down.
-	}
-
-	if now.Sub(p.lastTry) < p.backOff {
+	if now.Before(p.nextTry) {
 		return
 	}
 
 	p.lastTry = now
 	poolSize := p.size()
+	p.scale(now, poolSize)
+	p.closeSurplus(poolSize)
 	// Create enough connection to fill the pool.
-	toCreate := p.client.Config.PoolIdleSize - poolSize.Idle
+	toCreate := p.idleSize - poolSize.Idle
 
 	// Create only one connection if the pool is empty.
 	if poolSize.Total == 0 && toCreate < 1 {
@@ -123,8 +183,8 @@ func (p *Pool) connector(ctx context.Context, now time.Time) {
 	}
 
 	// Open at most PoolMaxSize connections.
-	if poolSize.Total+toCreate > p.client.Config.PoolMaxSize {
-		toCreate = p.client.Config.PoolMaxSize - poolSize.Total
+	if poolSize.Total+toCreate > p.maxSize {
+		toCreate = p.maxSize - poolSize.Total
 	}
 
 	p.fillConnectionPool(ctx, now, toCreate)
@@ -147,20 +207,28 @@ func (p *Pool) fillConnectionPool(ctx context.Context, now time.Time, toCreate i
 		// This is the only place a connection is added to the pool.
 		conn := NewConnection(p)
 		if err := conn.Connect(ctx); err != nil {
-			p.client.Errorf("Connecting to tunnel @ %s: %s", p.target, err)
-			p.backOff += p.client.Backoff
+			p.failures++
+			delay := backoffDelay(p.client.Backoff, p.client.MaxBackoff, p.failures)
+			p.nextTry = now.Add(delay)
+			p.backoff = delay
+			p.lastErr = err
+			p.lastErrTime = now
+			p.logger.Errorf("Connecting to tunnel @ %s (attempt %d, retrying in %v): %s",
+				p.target, p.failures, delay.Round(time.Millisecond), err)
 
 			break // don't try any more this round.
 		}
 
 		p.connections = append(p.connections, conn)
-		p.backOff = p.client.Backoff
+		p.connected = now
+		p.failures = 0
+		p.backoff = 0
 	}
 }
 
 // Remove a connection from the pool.
 func (p *Pool) Remove(conn *Connection) {
-	if !p.shutdown {
+	if !p.shutdown.Load() {
 		p.conChan <- conn
 		<-p.repChan
 	}
@@ -174,6 +242,8 @@ func (p *Pool) remove(connection *Connection) {
 			filtered = append(filtered, conn)
 		} else {
 			p.disconnects++
+			p.bytesRecv += conn.bytesRecv.Load()
+			p.bytesSent += conn.bytesSent.Load()
 			conn.Close() //nolint:wsl
 		}
 	}
@@ -183,13 +253,17 @@ func (p *Pool) remove(connection *Connection) {
 
 // Shutdown and close all connections in the pool.
 func (p *Pool) Shutdown() {
-	if !p.shutdown {
-		p.shutdown = true
+	if p.shutdown.CompareAndSwap(false, true) {
 		close(p.done)
 	}
 }
 
 func (ps *PoolSize) String() string {
+	if ps.Failures > 0 {
+		return fmt.Sprintf("Connecting %d, idle %d, running %d, total %d, failures %d, next try in %v: %s",
+			ps.Connecting, ps.Idle, ps.Running, ps.Total, ps.Failures, time.Until(ps.NextTry).Round(time.Second), ps.LastError)
+	}
+
 	return fmt.Sprintf("Connecting %d, idle %d, running %d, total %d",
 		ps.Connecting, ps.Idle, ps.Running, ps.Total)
 }
@@ -205,25 +279,58 @@ func (p *Pool) size() *PoolSize {
 	poolSize.Total = len(p.connections)
 	poolSize.Disconnects = p.disconnects
 	poolSize.LastTry = p.lastTry
-	poolSize.Active = !p.shutdown
+	poolSize.Active = !p.shutdown.Load()
+	poolSize.BytesRecv = p.bytesRecv
+	poolSize.BytesSent = p.bytesSent
+	poolSize.Failures = p.failures
+	poolSize.Backoff = p.backoff
+	poolSize.NextTry = p.nextTry
+	poolSize.LastErrorTime = p.lastErrTime
+	poolSize.LastConnect = p.connected
+	poolSize.IdleTarget = p.idleSize
+	poolSize.Requests = p.requests.Load()
+	poolSize.Rejected = p.rejected.Load()
+
+	if p.lastErr != nil {
+		poolSize.LastError = p.lastErr.Error()
+	}
 
-	if poolSize.LastConn = p.lastTry; !p.shutdown && p.client.RoundRobinConfig != nil {
+	if poolSize.LastConn = p.lastTry; !p.shutdown.Load() && p.client.RoundRobinConfig != nil {
 		poolSize.LastConn = p.client.lastConn
 	}
 
-	if p.shutdown {
+	if p.shutdown.Load() {
 		return poolSize
 	}
 
+	now := time.Now()
+	poolSize.Connections = make([]*ConnectionInfo, 0, len(p.connections))
+
 	for _, connection := range p.connections {
-		switch connection.Status() {
+		poolSize.BytesRecv += connection.bytesRecv.Load()
+		poolSize.BytesSent += connection.bytesSent.Load()
+		info := &ConnectionInfo{ID: connection.id, Connected: connection.connected, Age: now.Sub(connection.connected)}
+
+		status := connection.Status()
+		if connection.draining.Load() {
+			status = DRAINING
+		}
+
+		switch status {
+		case DRAINING:
+			info.Status = "draining"
 		case CONNECTING:
 			poolSize.Connecting++
+			info.Status = "connecting"
 		case IDLE:
 			poolSize.Idle++
+			info.Status = "idle"
 		case RUNNING:
 			poolSize.Running++
+			info.Status = "running"
 		}
+
+		poolSize.Connections = append(poolSize.Connections, info)
 	}
 
 	return poolSize
@@ -0,0 +1,76 @@
+package client
+
+import (
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"time"
+
+	"golift.io/mulery/mulch"
+)
+
+// ReverseProxyOption changes the reverse proxy made by NewReverseProxyHandler.
+type ReverseProxyOption func(*reverseProxy)
+
+type reverseProxy struct {
+	*httputil.ReverseProxy
+	preserveHost bool
+	logger       mulch.Logger
+}
+
+// ProxyFlushInterval sets how often response bodies are flushed while copying. A negative value flushes
+// after every write. Streaming responses, like server-sent events, are always flushed immediately.
+func ProxyFlushInterval(interval time.Duration) ReverseProxyOption {
+	return func(p *reverseProxy) { p.FlushInterval = interval }
+}
+
+// ProxyTransport sets the round tripper used to reach the target. The default is http.DefaultTransport.
+func ProxyTransport(transport http.RoundTripper) ReverseProxyOption {
+	return func(p *reverseProxy) { p.Transport = transport }
+}
+
+// ProxyPreserveHost keeps the Host header from the tunneled request, instead of using the target's host.
+func ProxyPreserveHost() ReverseProxyOption {
+	return func(p *reverseProxy) { p.preserveHost = true }
+}
+
+// ProxyLogger logs failed requests to the target. Pass the client's Logger to see them with the tunnel logs.
+func ProxyLogger(logger mulch.Logger) ReverseProxyOption {
+	return func(p *reverseProxy) { p.logger = logger }
+}
+
+// ProxyModifyResponse sets a function that may change every response from the target.
+// An error returned by the function is answered with 502 Bad Gateway.
+func ProxyModifyResponse(modify func(*http.Response) error) ReverseProxyOption {
+	return func(p *reverseProxy) { p.ModifyResponse = modify }
+}
+
+// NewReverseProxyHandler returns a handler that proxies every tunneled request to target, keeping the path
+// and query of each request. X-Forwarded-For, X-Forwarded-Host and X-Forwarded-Proto are set.
+// Failed requests are answered with 502 Bad Gateway. Use the returned handler as Config.HandlerHTTP.
+func NewReverseProxyHandler(target *url.URL, opts ...ReverseProxyOption) http.Handler {
+	proxy := &reverseProxy{
+		ReverseProxy: &httputil.ReverseProxy{},
+		logger:       &mulch.DefaultLogger{Silent: true},
+	}
+
+	for _, opt := range opts {
+		opt(proxy)
+	}
+
+	proxy.Rewrite = func(req *httputil.ProxyRequest) {
+		req.SetURL(target)
+		req.SetXForwarded()
+
+		if proxy.preserveHost {
+			req.Out.Host = req.In.Host
+		}
+	}
+
+	proxy.ErrorHandler = func(resp http.ResponseWriter, req *http.Request, err error) {
+		proxy.logger.Errorf("Proxying %s %s to %s: %v", req.Method, req.URL.Path, target.Host, err)
+		http.Error(resp, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+	}
+
+	return proxy.ReverseProxy
+}
@@ -0,0 +1,333 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"path"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+)
+
+// maxRedirects matches the http.Client default.
+const maxRedirects = 10
+
+// ErrForbidden is returned for requests refused by AllowRules or DenyRules.
+var ErrForbidden = errors.New("request forbidden by client rules")
+
+// Rule matches tunneled requests for AllowRules and DenyRules. Every non-empty field must match,
+// and a field matches if any one of its values matches. A rule with no values matches every request.
+type Rule struct {
+	// Hosts are host names, like example.com. A leading *. matches any subdomain: *.example.com.
+	Hosts []string
+	// CIDRs are networks, like 10.0.0.0/8 or 127.0.0.1/32. The default handler checks the address it
+	// dials, so a DNS name cannot point it at an internal address, and it does not use HTTP_PROXY.
+	// Custom handlers and LocalSocket have no dialed address; their host names are resolved before the
+	// handler runs. An allow rule matches if every address is inside these networks, and a deny rule
+	// matches if any address is.
+	CIDRs []string
+	// Ports are destination ports. Ports 80 and 443 are used for http and https URLs without one.
+	Ports []int
+	// Paths are URL path prefixes, like /api. A prefix matches whole path segments: /api matches
+	// /api and /api/keys, but not /apikeys. Paths are cleaned before they are compared.
+	Paths    []string
+	prefixes []netip.Prefix
+	err      error // a rule with an invalid CIDR matches every denied request and no allowed request.
+}
+
+// destination is the parts of a request that rules compare.
+type destination struct {
+	host  string
+	port  int
+	path  string
+	addrs []netip.Addr // resolved only when a rule has CIDRs.
+	// dial is true when the addresses are checked by the dialer, not resolved here.
+	dial bool
+}
+
+// dialCheckKey is the context key for the destination the local dialer checks addresses against.
+type dialCheckKey struct{}
+
+// dialCheck holds the destination of the request being dialed. Redirects replace it.
+type dialCheck struct {
+	dest atomic.Pointer[destination]
+}
+
+// parseRules parses the CIDRs in every rule.
+func parseRules(rules []*Rule) {
+	for _, rule := range rules {
+		if rule == nil {
+			continue
+		}
+
+		rule.prefixes = make([]netip.Prefix, 0, len(rule.CIDRs))
+
+		for _, cidr := range rule.CIDRs {
+			prefix, err := netip.ParsePrefix(cidr)
+			if err != nil {
+				rule.err = fmt.Errorf("parsing cidr: %w", err)
+				break
+			}
+
+			rule.prefixes = append(rule.prefixes, prefix.Masked())
+		}
+	}
+}
+
+// checkRules returns an error if the request may not be executed.
+// A request is refused if it matches a deny rule, or if allow rules exist and it matches none of them.
+// When the request's context has a dialCheck, addresses are checked when the connection is dialed.
+func (c *Client) checkRules(ctx context.Context, req *http.Request) error {
+	if len(c.AllowRules) == 0 && len(c.DenyRules) == 0 {
+		return nil
+	}
+
+	check, _ := ctx.Value(dialCheckKey{}).(*dialCheck)
+
+	dest, err := c.destination(ctx, req, check != nil)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrForbidden, err)
+	}
+
+	if check != nil {
+		check.dest.Store(dest)
+	}
+
+	return c.evaluate(dest)
+}
+
+// evaluate applies the deny rules, then the allow rules, to a destination.
+func (c *Client) evaluate(dest *destination) error {
+	for idx, rule := range c.DenyRules {
+		if rule != nil && (rule.err != nil || rule.match(dest, true)) {
+			return fmt.Errorf("%w: %s matches deny rule %d", ErrForbidden, dest, idx+1)
+		}
+	}
+
+	if len(c.AllowRules) == 0 {
+		return nil
+	}
+
+	for _, rule := range c.AllowRules {
+		if rule != nil && rule.err == nil && rule.match(dest, false) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: %s matches no allow rule", ErrForbidden, dest)
+}
+
+// withDialCheck returns a request the local dialer checks against the rules, when any rule has CIDRs.
+// Requests sent to LocalSocket have no address to check, so they are returned unchanged.
+func (c *Client) withDialCheck(req *http.Request) *http.Request {
+	if c.LocalSocket != "" || !c.needAddrs() {
+		return req
+	}
+
+	return req.WithContext(context.WithValue(req.Context(), dialCheckKey{}, &dialCheck{}))
+}
+
+// controlDial refuses connections to addresses the rules do not allow for the request being dialed.
+// Connections without a dialCheck are not tunneled requests, like health checks, so they are allowed.
+func (c *Client) controlDial(ctx context.Context, _, address string, _ syscall.RawConn) error {
+	check, _ := ctx.Value(dialCheckKey{}).(*dialCheck)
+	if check == nil {
+		return nil
+	}
+
+	dest := check.dest.Load()
+	if dest == nil {
+		return fmt.Errorf("%w: dialing %s: request was not checked", ErrForbidden, address)
+	}
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrForbidden, err)
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrForbidden, err)
+	}
+
+	dialed := *dest
+	dialed.addrs, dialed.dial = []netip.Addr{addr.Unmap()}, false
+
+	return c.evaluate(&dialed)
+}
+
+// checkRedirect applies the rules to redirects followed by the default handler.
+func (c *Client) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxRedirects {
+		return fmt.Errorf("stopped after %d redirects", maxRedirects) //nolint:goerr113
+	}
+
+	return c.checkRules(req.Context(), req)
+}
+
+// destination finds the host, port and path of a request, and resolves the host if a rule needs it.
+// The host is not resolved when the dialer checks the addresses.
+func (c *Client) destination(ctx context.Context, req *http.Request, dial bool) (*destination, error) {
+	if req.URL == nil {
+		return nil, fmt.Errorf("request has no url") //nolint:goerr113 // this is wrapped.
+	}
+
+	dest := &destination{host: req.URL.Hostname(), path: path.Clean("/" + req.URL.Path)}
+	port := req.URL.Port()
+
+	if dest.host == "" { // The custom handler gets relative URLs.
+		dest.host, port = req.Host, ""
+		if host, hostPort, err := net.SplitHostPort(req.Host); err == nil {
+			dest.host, port = host, hostPort
+		}
+	}
+
+	dest.host = strings.ToLower(strings.TrimSuffix(dest.host, "."))
+
+	switch {
+	case port != "":
+		dest.port, _ = strconv.Atoi(port)
+	case req.URL.Scheme == "https":
+		dest.port = 443 //nolint:gomnd
+	default:
+		dest.port = 80 //nolint:gomnd
+	}
+
+	if !c.needAddrs() {
+		return dest, nil
+	}
+
+	if dial {
+		dest.dial = true
+		return dest, nil
+	}
+
+	if addr, err := netip.ParseAddr(dest.host); err == nil {
+		dest.addrs = []netip.Addr{addr.Unmap()}
+		return dest, nil
+	}
+
+	resolver := c.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+
+	addrs, err := resolver.LookupNetIP(ctx, "ip", dest.host)
+	if err != nil {
+		return nil, fmt.Errorf("resolving %s: %w", dest.host, err)
+	}
+
+	for _, addr := range addrs {
+		dest.addrs = append(dest.addrs, addr.Unmap())
+	}
+
+	return dest, nil
+}
+
+// needAddrs returns true if any rule has CIDRs, so hosts must be resolved.
+func (c *Client) needAddrs() bool {
+	for _, rules := range [][]*Rule{c.AllowRules, c.DenyRules} {
+		for _, rule := range rules {
+			if rule != nil && len(rule.CIDRs) > 0 {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (d *destination) String() string {
+	return net.JoinHostPort(d.host, strconv.Itoa(d.port)) + d.path
+}
+
+// match returns true if every non-empty field in the rule matches the destination.
+func (r *Rule) match(dest *destination, deny bool) bool {
+	return r.matchHost(dest.host) && r.matchCIDR(dest.addrs, dest.dial, deny) && r.matchPort(dest.port) && r.matchPath(dest.path)
+}
+
+func (r *Rule) matchHost(host string) bool {
+	if len(r.Hosts) == 0 {
+		return true
+	}
+
+	for _, want := range r.Hosts {
+		want = strings.ToLower(strings.TrimSuffix(want, "."))
+
+		if suffix, ok := strings.CutPrefix(want, "*"); ok && strings.HasSuffix(host, suffix) {
+			return true
+		} else if host == want {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchCIDR returns true if every address (or any address, for deny rules) is inside the rule's networks.
+// Before the dialer checks the addresses, allow rules may still match and deny rules do not match yet.
+func (r *Rule) matchCIDR(addrs []netip.Addr, dial, deny bool) bool {
+	if len(r.prefixes) == 0 {
+		return true
+	}
+
+	if dial {
+		return !deny
+	}
+
+	if len(addrs) == 0 {
+		return false
+	}
+
+	for _, addr := range addrs {
+		if r.contains(addr) == deny {
+			return deny
+		}
+	}
+
+	return !deny
+}
+
+func (r *Rule) contains(addr netip.Addr) bool {
+	for _, prefix := range r.prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r *Rule) matchPort(port int) bool {
+	if len(r.Ports) == 0 {
+		return true
+	}
+
+	for _, want := range r.Ports {
+		if port == want {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r *Rule) matchPath(reqPath string) bool {
+	if len(r.Paths) == 0 {
+		return true
+	}
+
+	for _, prefix := range r.Paths {
+		prefix = strings.TrimSuffix(prefix, "/")
+		if reqPath == prefix || strings.HasPrefix(reqPath, prefix+"/") {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,71 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRuleMatchPath(t *testing.T) {
+	t.Parallel()
+
+	rule := &Rule{Paths: []string{"/api", "/static/"}}
+
+	for reqPath, want := range map[string]bool{
+		"/api":         true,
+		"/api/keys":    true,
+		"/apikeys":     false,
+		"/static":      true,
+		"/static/x.js": true,
+		"/statics":     false,
+		"/":            false,
+	} {
+		if got := rule.matchPath(reqPath); got != want {
+			t.Errorf("path %s: got %v, want %v", reqPath, got, want)
+		}
+	}
+}
+
+// TestDialCheck proves the rules are applied to the address that is dialed, not to a separate lookup.
+func TestDialCheck(t *testing.T) {
+	t.Parallel()
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(backend.Close)
+
+	_, port, _ := net.SplitHostPort(backend.Listener.Addr().String())
+	client := &Client{Config: &Config{DenyRules: []*Rule{{CIDRs: []string{"127.0.0.0/8", "::1/128"}}}}}
+	parseRules(client.DenyRules)
+	client.client = client.newLocalClient()
+
+	// The request is allowed before the dial, because a host name's addresses are not known yet.
+	req := client.withDialCheck(httptest.NewRequest(http.MethodGet, "http://localhost:"+port+"/", nil))
+	req.RequestURI = ""
+
+	if err := client.checkRules(req.Context(), req); err != nil {
+		t.Fatalf("checking rules before the dial: %v", err)
+	}
+
+	if resp, err := client.client.Do(req); !errors.Is(err, ErrForbidden) {
+		if err == nil {
+			resp.Body.Close()
+		}
+
+		t.Fatalf("dialing a denied address: got %v, want %v", err, ErrForbidden)
+	}
+
+	// Requests that are not tunneled, like health checks, are not checked.
+	req, _ = http.NewRequestWithContext(context.Background(), http.MethodGet, backend.URL, nil)
+
+	resp, err := client.client.Do(req)
+	if err != nil {
+		t.Fatalf("request without a dial check: %v", err)
+	}
+
+	resp.Body.Close()
+}
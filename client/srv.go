@@ -0,0 +1,191 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SRVScheme is the target URL scheme for servers discovered with DNS SRV records.
+// Example: dns+srv://_mulery._tcp.example.com/register
+// Discovered servers are dialed with wss, unless the URL contains ?scheme=ws.
+// The path (default /register) is appended to every discovered host.
+const SRVScheme = "dns+srv"
+
+// DefaultSRVInterval is how often SRV targets are resolved if SRVInterval is not set.
+const DefaultSRVInterval = time.Minute
+
+// ErrSRVTarget is returned for dns+srv target URLs that cannot be resolved.
+var ErrSRVTarget = errors.New("invalid srv target")
+
+// srvTarget is a parsed SRV target, and the targets it currently resolves to.
+type srvTarget struct {
+	*Target
+	name    string // the SRV record name to look up.
+	scheme  string // ws or wss
+	path    string
+	current map[string]bool // URLs added from the last lookup.
+}
+
+// isSRV returns true if a target URL should be resolved with DNS SRV records.
+func isSRV(target string) bool {
+	return strings.HasPrefix(target, SRVScheme+"://")
+}
+
+// splitSRV separates SRV targets from the targets that are dialed directly.
+func splitSRV(targets []*Target) ([]*Target, []*Target) {
+	var direct, srv []*Target
+
+	for _, target := range targets {
+		if isSRV(target.URL) {
+			srv = append(srv, target)
+		} else {
+			direct = append(direct, target)
+		}
+	}
+
+	return direct, srv
+}
+
+// parseSRV parses an SRV target URL.
+func parseSRV(target *Target) (*srvTarget, error) {
+	srvURL, err := url.Parse(target.URL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing srv target: %w", err)
+	}
+
+	parsed := &srvTarget{
+		Target:  target,
+		name:    srvURL.Host,
+		scheme:  "wss",
+		path:    srvURL.EscapedPath(),
+		current: make(map[string]bool),
+	}
+
+	if scheme := srvURL.Query().Get("scheme"); scheme != "" {
+		parsed.scheme = scheme
+	}
+
+	if parsed.name == "" {
+		return nil, fmt.Errorf("%w: missing record name", ErrSRVTarget)
+	}
+
+	if parsed.scheme != "ws" && parsed.scheme != "wss" {
+		return nil, fmt.Errorf("%w: scheme must be ws or wss, not %s", ErrSRVTarget, parsed.scheme)
+	}
+
+	if parsed.path == "" {
+		parsed.path = "/register"
+	}
+
+	return parsed, nil
+}
+
+// discoverTargets resolves the SRV targets every SRVInterval until the context is cancelled.
+// Targets found are added to the client, and targets that disappear are removed.
+func (c *Client) discoverTargets(ctx context.Context) {
+	srvs := make([]*srvTarget, 0, len(c.srv))
+
+	for _, target := range c.srv {
+		parsed, err := parseSRV(target)
+		if err != nil {
+			c.Errorf("Skipping SRV target %s: %v", target.URL, err)
+			continue
+		}
+
+		srvs = append(srvs, parsed)
+	}
+
+	ticker := time.NewTicker(c.SRVInterval)
+	defer ticker.Stop()
+
+	for {
+		for _, srv := range srvs {
+			c.resolveSRV(ctx, srv)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// resolveSRV looks up one SRV record, and adds or removes targets to match it.
+// If the lookup fails, the current targets are kept.
+func (c *Client) resolveSRV(ctx context.Context, srv *srvTarget) {
+	resolver := c.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+
+	// Only the lookup has a deadline. Pools started from the records run on the parent context.
+	lookupCtx, cancel := context.WithTimeout(ctx, c.SRVInterval)
+	defer cancel()
+
+	_, records, err := resolver.LookupSRV(lookupCtx, "", "", srv.name)
+	if err != nil {
+		c.Errorf("Looking up SRV target %s: %v", srv.name, err)
+		return
+	}
+
+	found := make(map[string]*Target, len(records))
+
+	for _, record := range records {
+		host := strings.TrimSuffix(record.Target, ".")
+		target := srv.scheme + "://" + net.JoinHostPort(host, strconv.Itoa(int(record.Port))) + srv.path
+		found[target] = &Target{URL: target, SecretKey: srv.SecretKey, ID: srv.ID, Pins: srv.Pins}
+	}
+
+	c.syncTargets(ctx, srv.name, srv.current, found)
+}
+
+// syncTargets adds the found targets that are not current, and removes the current targets that were not found.
+// current is updated to match found. source is only used in logs.
+func (c *Client) syncTargets(ctx context.Context, source string, current map[string]bool, found map[string]*Target) {
+	for _, url := range sortedKeys(found) {
+		if current[url] {
+			continue
+		}
+
+		if err := c.AddTarget(ctx, found[url]); errors.Is(err, ErrTargetExists) {
+			c.Debugf("Target %s from %s is already configured", url, source)
+			continue
+		} else if err != nil {
+			c.Errorf("Adding target %s from %s: %v", url, source, err)
+			continue
+		}
+
+		current[url] = true
+	}
+
+	for _, url := range sortedKeys(current) {
+		if found[url] != nil {
+			continue
+		}
+
+		delete(current, url)
+
+		if err := c.RemoveTarget(ctx, url); err != nil {
+			c.Errorf("Removing target %s from %s: %v", url, source, err)
+		}
+	}
+}
+
+func sortedKeys[V any](input map[string]V) []string {
+	keys := make([]string, 0, len(input))
+	for key := range input {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
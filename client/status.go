@@ -0,0 +1,87 @@
+package client
+
+import "sort"
+
+// Tunnel states reported by Status.
+const (
+	StateHealthy  = "healthy"  // Connected, and nothing is failing.
+	StateDegraded = "degraded" // Connected, but some connections or the backend are failing.
+	StateDown     = "down"     // Not connected.
+	StateInactive = "inactive" // Not in use; a round robin target that is waiting its turn.
+)
+
+// Status is a health snapshot of the client and each of its targets.
+type Status struct {
+	// State is the overall tunnel state: healthy, degraded or down.
+	State string
+	// BackendHealthy is false if the last HealthCheckURL probe failed. Always true without one.
+	BackendHealthy bool
+	// Targets contains the state of each target, sorted by URL.
+	Targets []*TargetStatus
+}
+
+// TargetStatus is the state of one target.
+// The connection counts, errors, backoff and request counts are in the embedded PoolSize.
+type TargetStatus struct {
+	URL   string
+	State string
+	*PoolSize
+}
+
+// Status returns a health snapshot of every target, for applications to display.
+func (c *Client) Status() *Status {
+	status := &Status{BackendHealthy: c.Healthy()}
+
+	for url, size := range c.PoolStats() {
+		status.Targets = append(status.Targets, &TargetStatus{
+			URL:      url,
+			State:    targetState(size, status.BackendHealthy),
+			PoolSize: size,
+		})
+	}
+
+	sort.Slice(status.Targets, func(i, j int) bool { return status.Targets[i].URL < status.Targets[j].URL })
+	status.State = overallState(status.Targets)
+
+	return status
+}
+
+func targetState(size *PoolSize, backendHealthy bool) string {
+	switch {
+	case !size.Active:
+		return StateInactive
+	case size.Idle+size.Running == 0:
+		return StateDown
+	case size.Failures > 0 || !backendHealthy:
+		return StateDegraded
+	default:
+		return StateHealthy
+	}
+}
+
+// overallState is healthy if every active target is healthy, and down if none are connected.
+func overallState(targets []*TargetStatus) string {
+	var active, down, healthy int
+
+	for _, target := range targets {
+		switch target.State {
+		case StateInactive:
+			continue
+		case StateDown:
+			down++
+		case StateHealthy:
+			healthy++
+		}
+
+		active++
+	}
+
+	switch {
+	case active == 0 || down == active:
+		return StateDown
+	case healthy == active:
+		return StateHealthy
+	default:
+		return StateDegraded
+	}
+}
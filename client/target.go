@@ -0,0 +1,168 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Errors returned by AddTarget and RemoveTarget.
+var (
+	ErrTargetURL      = errors.New("target has no url")
+	ErrTargetExists   = errors.New("target already exists")
+	ErrTargetNotFound = errors.New("target not found")
+)
+
+// Target is a server this client registers with, and the credentials it registers with.
+type Target struct {
+	// URL is the websocket URL of the server's register path.
+	URL string
+	// SecretKey is sent to this server. Config.SecretKey is used if this is empty.
+	SecretKey string
+	// ID is the client identifier registered with this server. Config.ID is used if this is empty.
+	ID string
+	// Pins are base64 SHA-256 hashes of public keys (SPKI) this server's certificate, or its chain, must have.
+	// The connection fails if none match, even when the certificate is trusted. Config.Pins is used if this is empty.
+	Pins []string
+	// Weight is the percent (1-100) of PoolIdleSize and PoolMaxSize this target's pool maintains.
+	// Zero is 100. Lower it for standby servers, or to split connections, like 80 and 20, across regions.
+	// A weighted pool keeps at least one connection.
+	Weight int
+}
+
+// targets combines Targets and TargetList into one list, filling in the default key and ID.
+func (c *Config) targets() []*Target {
+	targets := make([]*Target, 0, len(c.Targets)+len(c.TargetList))
+
+	for _, url := range c.Targets {
+		targets = append(targets, c.withDefaults(&Target{URL: url}))
+	}
+
+	for _, target := range c.TargetList {
+		if target != nil {
+			targets = append(targets, c.withDefaults(target))
+		}
+	}
+
+	return targets
+}
+
+// withDefaults returns a copy of a target with the empty key, ID and pins taken from the Config.
+func (c *Config) withDefaults(target *Target) *Target {
+	custom := *target
+	if custom.SecretKey == "" {
+		custom.SecretKey = c.SecretKey
+	}
+
+	if custom.ID == "" {
+		custom.ID = c.ID
+	}
+
+	if len(custom.Pins) == 0 {
+		custom.Pins = c.Pins
+	}
+
+	return &custom
+}
+
+// weighted returns the part of size a target with this weight gets. The result is at least 1 if size is positive.
+func weighted(size, weight int) int {
+	if weight <= 0 || weight >= 100 || size <= 0 { //nolint:gomnd
+		return size
+	}
+
+	return max(size*weight/100, 1) //nolint:gomnd
+}
+
+// GetTargets returns the servers this client registers with.
+func (c *Client) GetTargets() []*Target {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	targets := make([]*Target, len(c.targets))
+	for idx, target := range c.targets {
+		copied := *target
+		copied.Pins = append([]string(nil), target.Pins...)
+		targets[idx] = &copied
+	}
+
+	return targets
+}
+
+// AddTarget adds a server to register with. If the client is running, connections to it
+// are started right away, except in round robin mode where it waits its turn.
+// SecretKey, ID and Pins are taken from the Config if they are empty.
+func (c *Client) AddTarget(ctx context.Context, target *Target) error {
+	if target == nil || target.URL == "" {
+		return ErrTargetURL
+	}
+
+	added := c.withDefaults(target)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, existing := range c.targets {
+		if existing.URL == added.URL {
+			return fmt.Errorf("%w: %s", ErrTargetExists, added.URL)
+		}
+	}
+
+	c.targets = append(c.targets, added)
+	c.Printf("Added tunnel target: %s", added.URL)
+
+	if !c.running {
+		return nil
+	}
+
+	if c.RoundRobinConfig == nil {
+		c.pools[added.URL] = StartPool(ctx, c, added)
+	} else if len(c.targets) == 1 {
+		c.target = -1
+		c.startOnePool(ctx) // It was empty, so nothing is connected.
+	}
+
+	return nil
+}
+
+// RemoveTarget stops registering with a server, and closes its connections.
+// In round robin mode, removing the active target connects to the next one.
+func (c *Client) RemoveTarget(ctx context.Context, url string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	idx := -1
+
+	for i, target := range c.targets {
+		if target.URL == url {
+			idx = i
+			break
+		}
+	}
+
+	if idx == -1 {
+		return fmt.Errorf("%w: %s", ErrTargetNotFound, url)
+	}
+
+	c.targets = append(c.targets[:idx], c.targets[idx+1:]...)
+	c.Printf("Removed tunnel target: %s", url)
+
+	if pool := c.pools[url]; pool != nil {
+		pool.Shutdown()
+		delete(c.pools, url)
+	}
+
+	if c.RoundRobinConfig == nil {
+		return nil
+	}
+
+	switch {
+	case idx < c.target:
+		c.target--
+	case idx == c.target && c.running:
+		c.target-- // startOnePool moves to the target that took this one's place.
+		c.startOnePool(ctx)
+	}
+
+	return nil
+}
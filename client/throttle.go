@@ -0,0 +1,123 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// throttleSlices is how many writes a second of throttled data is split into, so the rate is smooth.
+const throttleSlices = 10
+
+// throttle paces writes to a number of bytes per second. It is safe for concurrent use.
+type throttle struct {
+	rate int64
+	mu   sync.Mutex
+	next time.Time // when the next write may start.
+}
+
+// throttledWriter writes to a writer no faster than every throttle allows.
+type throttledWriter struct {
+	ctx       context.Context //nolint:containedctx // the writer lives for one request.
+	writer    io.Writer
+	throttles []*throttle
+	chunk     int
+}
+
+// newThrottle returns nil if the rate is not positive. A nil throttle does not limit anything.
+func newThrottle(rate int64) *throttle {
+	if rate <= 0 {
+		return nil
+	}
+
+	return &throttle{rate: rate}
+}
+
+// reserve takes size bytes from the throttle, and returns how long to wait before writing them.
+func (t *throttle) reserve(now time.Time, size int) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.next.Before(now) {
+		t.next = now
+	}
+
+	wait := t.next.Sub(now)
+	t.next = t.next.Add(time.Duration(int64(size) * int64(time.Second) / t.rate))
+
+	return wait
+}
+
+// throttleWriter wraps a writer with the client's and the connection's response rate limits.
+// The writer is returned as-is if there are no limits.
+func (c *Connection) throttleWriter(ctx context.Context, writer io.Writer) io.Writer {
+	throttled := &throttledWriter{ctx: ctx, writer: writer}
+
+	for _, limit := range []*throttle{c.pool.client.throttle, c.throttle} {
+		if limit == nil {
+			continue
+		}
+
+		throttled.throttles = append(throttled.throttles, limit)
+
+		if chunk := int(max(limit.rate/throttleSlices, 1)); throttled.chunk == 0 || chunk < throttled.chunk {
+			throttled.chunk = chunk
+		}
+	}
+
+	if len(throttled.throttles) == 0 {
+		return writer
+	}
+
+	return throttled
+}
+
+func (w *throttledWriter) Write(data []byte) (int, error) {
+	var written int
+
+	for len(data) > 0 {
+		chunk := data[:min(len(data), w.chunk)]
+		if err := w.wait(len(chunk)); err != nil {
+			return written, err
+		}
+
+		size, err := w.writer.Write(chunk)
+		written += size
+
+		if err != nil {
+			return written, err //nolint:wrapcheck // this is a passthrough.
+		}
+
+		data = data[size:]
+	}
+
+	return written, nil
+}
+
+// wait sleeps until every throttle allows size more bytes.
+func (w *throttledWriter) wait(size int) error {
+	var (
+		now  = time.Now()
+		wait time.Duration
+	)
+
+	for _, limit := range w.throttles {
+		wait = max(wait, limit.reserve(now, size))
+	}
+
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-w.ctx.Done():
+		return fmt.Errorf("throttled response: %w", w.ctx.Err())
+	}
+}
@@ -0,0 +1,66 @@
+package client
+
+import (
+	"net/http"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"golift.io/mulery/mulch"
+)
+
+const tracerName = "golift.io/mulery/client"
+
+// tracer wraps the optional OpenTelemetry instrumentation.
+// A nil tracer means tracing is disabled, and all methods are no-ops.
+type tracer struct {
+	trace.Tracer
+	propagation.TextMapPropagator
+}
+
+func newTracer(config *Config) *tracer {
+	if config.TracerProvider == nil {
+		return nil
+	}
+
+	propagator := config.Propagator
+	if propagator == nil {
+		propagator = propagation.TraceContext{}
+	}
+
+	return &tracer{
+		Tracer:            config.TracerProvider.Tracer(tracerName),
+		TextMapPropagator: propagator,
+	}
+}
+
+// start continues the server's trace and starts a span around a tunneled request.
+// The returned request carries the span in its context.
+func (t *tracer) start(req *http.Request, httpReq *mulch.HTTPRequest) (*http.Request, trace.Span) {
+	if t == nil {
+		return req, trace.SpanFromContext(req.Context())
+	}
+
+	ctx := t.Extract(req.Context(), propagation.MapCarrier(httpReq.Trace))
+	ctx, span := t.Start(ctx, "mulery.tunnel "+req.Method, trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.request.method", req.Method),
+			attribute.String("url.full", req.URL.String()),
+		))
+
+	return req.WithContext(ctx), span
+}
+
+// inject adds the span in the request's context to the outgoing request headers.
+func (t *tracer) inject(req *http.Request) {
+	if t != nil {
+		t.Inject(req.Context(), propagation.HeaderCarrier(req.Header))
+	}
+}
+
+// fail marks a span as failed.
+func fail(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
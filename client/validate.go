@@ -0,0 +1,117 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"net/url"
+)
+
+// ErrInvalidConfig is wrapped by every error Validate returns.
+var ErrInvalidConfig = errors.New("invalid client config")
+
+// Validate returns an error for every setting that prevents the client from working.
+// NewClient calls this, and returns the error. Lint returns warnings for settings that only look wrong.
+func (c *Config) Validate() error {
+	var errs []error
+
+	invalid := func(format string, v ...any) {
+		errs = append(errs, fmt.Errorf("%w: "+format, append([]any{ErrInvalidConfig}, v...)...))
+	}
+
+	for idx, target := range c.TargetList {
+		if target == nil || target.URL == "" {
+			invalid("TargetList[%d] has no URL: set it, or remove the target", idx)
+		} else if _, err := parsePins(target.Pins); err != nil {
+			invalid("TargetList[%d] Pins: %v", idx, err)
+		} else if target.Weight < 0 || target.Weight > 100 {
+			invalid("TargetList[%d] Weight (%d) is not between 0 and 100", idx, target.Weight)
+		}
+	}
+
+	for _, target := range c.targets() {
+		if err := validateTarget(target.URL); target.URL != "" && err != nil {
+			invalid("target %s: %v", target.URL, err)
+		}
+	}
+
+	if _, err := parsePins(c.Pins); err != nil {
+		invalid("Pins: %v", err)
+	}
+
+	if c.PoolMaxSize < 1 {
+		invalid("PoolMaxSize (%d) is less than 1: no connections can be made", c.PoolMaxSize)
+	}
+
+	if c.PoolIdleSize > c.PoolMaxSize {
+		invalid("PoolIdleSize (%d) is larger than PoolMaxSize (%d): lower PoolIdleSize or raise PoolMaxSize",
+			c.PoolIdleSize, c.PoolMaxSize)
+	}
+
+	if targets, srv := splitSRV(c.targets()); c.RoundRobinConfig != nil && len(targets) == 1 &&
+		len(srv) == 0 && c.DiscoveryURL == "" {
+		invalid("RoundRobinConfig is set with 1 target: add a target or remove RoundRobinConfig")
+	}
+
+	if _, err := parseProxyURL(c.ProxyURL); c.ProxyURL != "" && err != nil {
+		invalid("ProxyURL: %v", err)
+	}
+
+	if _, err := parseLocalTarget(c.LocalTarget); c.LocalTarget != "" && err != nil {
+		invalid("LocalTarget: %v", err)
+	}
+
+	for idx, rule := range c.AllowRules {
+		if err := validateRule(rule); err != nil {
+			invalid("AllowRules[%d]: %v", idx, err)
+		}
+	}
+
+	for idx, rule := range c.DenyRules {
+		if err := validateRule(rule); err != nil {
+			invalid("DenyRules[%d]: %v", idx, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// validateTarget returns an error if a target URL cannot be dialed.
+func validateTarget(target string) error {
+	if isSRV(target) {
+		_, err := parseSRV(&Target{URL: target})
+		return err
+	}
+
+	parsed, err := url.Parse(target)
+	if err != nil {
+		return fmt.Errorf("parsing url: %w", err)
+	}
+
+	switch parsed.Scheme {
+	case "ws", "wss", UnixScheme:
+	default:
+		return fmt.Errorf("scheme must be ws, wss, %s or %s, not %q", UnixScheme, SRVScheme, parsed.Scheme) //nolint:goerr113
+	}
+
+	if parsed.Host == "" && parsed.Scheme != UnixScheme {
+		return fmt.Errorf("missing host") //nolint:goerr113
+	}
+
+	return nil
+}
+
+// validateRule returns an error if a rule has an invalid CIDR.
+func validateRule(rule *Rule) error {
+	if rule == nil {
+		return nil
+	}
+
+	for _, cidr := range rule.CIDRs {
+		if _, err := netip.ParsePrefix(cidr); err != nil {
+			return fmt.Errorf("invalid CIDR: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,33 @@
+package client
+
+import "runtime/debug"
+
+// modulePath is this module, as it appears in build info.
+const modulePath = "golift.io/mulery"
+
+// buildVersion returns the version of this module compiled into the binary, like v0.1.2.
+// Binaries built inside this module, and binaries without build info, return (devel).
+func buildVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "(devel)"
+	}
+
+	if info.Main.Path == modulePath && info.Main.Version != "" {
+		return info.Main.Version
+	}
+
+	for _, dep := range info.Deps {
+		if dep.Path != modulePath {
+			continue
+		}
+
+		if dep.Replace != nil && dep.Replace.Version != "" {
+			return dep.Replace.Version
+		}
+
+		return dep.Version
+	}
+
+	return "(devel)"
+}
@@ -1,25 +1,55 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 
 	"golift.io/mulery"
+	"golift.io/mulery/mulch"
 )
 
 func main() {
 	configFile := flag.String("config", "/config/mulery.conf", "config file path")
+	strict := flag.Bool("strict", false, "refuse to start if the config has warnings")
+	validate := flag.Bool("validate", false, "validate the config, print errors and warnings, and exit")
+	printConfig := flag.Bool("print-config", false, "print the config with defaults (secrets redacted), and exit")
+	version := flag.Bool("version", false, "print the version and exit")
+	writeConfig := flag.String("write-config", "", "write an example config file with defaults to this path (- for stdout), and exit")
 	flag.Parse()
 
+	if *version {
+		fmt.Println("mulery", mulch.ReadBuildInfo())
+		return
+	}
+
+	if *writeConfig != "" {
+		if err := mulery.WriteConfigFile(*writeConfig); err != nil {
+			log.Fatalf("Writing Config File: %s", err)
+		}
+
+		return
+	}
+
 	// Load configuration file.
 	mulery, err := mulery.LoadConfigFile(*configFile)
 	if err != nil {
 		log.Fatalf("Config File Error: %s", err)
 	}
 
+	if *validate || *printConfig {
+		os.Exit(check(mulery, *validate, *printConfig, *strict))
+	}
+
+	if warnings := mulery.Lint(); *strict && len(warnings) > 0 {
+		log.Fatalf("Config File Warnings (strict mode):\n - %s", strings.Join(warnings, "\n - "))
+	}
+
 	mulery.SetupLogs()
 	mulery.PrintConfig()
 
@@ -27,7 +57,56 @@ func main() {
 	defer mulery.Shutdown()
 
 	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
-	// Wait here for a signal to shut down.
-	<-sigCh
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	// Wait here for a signal to shut down. SIGHUP reloads.
+	for sig := <-sigCh; sig == syscall.SIGHUP; sig = <-sigCh {
+		mulery.Reload()
+	}
+}
+
+// check validates and/or prints the config without starting anything. Returns the exit code:
+// 1 if the config has errors, or warnings in strict mode.
+func check(config *mulery.Config, validate, printConfig, strict bool) int {
+	if printConfig {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", " ")
+
+		if err := encoder.Encode(config.Redacted()); err != nil {
+			log.Printf("Encoding config: %v", err)
+			return 1
+		}
+	}
+
+	if !validate {
+		return 0
+	}
+
+	code, errs := 0, ""
+
+	if err := config.Validate(); err != nil {
+		code, errs = 1, err.Error()
+		fmt.Fprintf(os.Stderr, "Config File Errors:\n - %s\n", strings.ReplaceAll(errs, "\n", "\n - "))
+	}
+
+	var warnings []string
+
+	for _, warning := range config.Lint() {
+		if !strings.Contains(errs, strings.TrimSuffix(warning, ": it is ignored")) { // Already an error.
+			warnings = append(warnings, warning)
+		}
+	}
+
+	if len(warnings) > 0 {
+		if strict {
+			code = 1
+		}
+
+		fmt.Fprintf(os.Stderr, "Config File Warnings:\n - %s\n", strings.Join(warnings, "\n - "))
+	}
+
+	if code == 0 {
+		fmt.Fprintln(os.Stderr, "Config File OK")
+	}
+
+	return code
 }
@@ -0,0 +1,50 @@
+// Command mulerybench load tests an in-process mulery server and clients.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+
+	"golift.io/mulery/bench"
+	"golift.io/mulery/mulch"
+)
+
+func main() {
+	config := &bench.Config{}
+	flag.IntVar(&config.Clients, "clients", bench.DefaultClients, "tunnel clients to connect")
+	flag.IntVar(&config.Workers, "workers", bench.DefaultWorkers, "concurrent requesters")
+	flag.IntVar(&config.Requests, "requests", bench.DefaultRequests, "requests to send")
+	flag.IntVar(&config.BodySize, "body", bench.DefaultBodySize, "response body size in bytes")
+	flag.IntVar(&config.PoolIdleSize, "idle", bench.DefaultPoolIdle, "idle connections per client")
+	flag.IntVar(&config.PoolMaxSize, "max", bench.DefaultPoolMax, "maximum connections per client")
+	flag.UintVar(&config.Dispatchers, "dispatchers", bench.DefaultDispatchers, "server dispatcher threads")
+	flag.IntVar(&config.Warmup, "warmup", bench.DefaultWarmup, "requests to send before measuring")
+	asJSON := flag.Bool("json", false, "print the result as JSON")
+	version := flag.Bool("version", false, "print the version and exit")
+	flag.Parse()
+
+	if *version {
+		fmt.Println("mulerybench", mulch.ReadBuildInfo())
+		return
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	result, err := bench.Run(ctx, config)
+	if err != nil {
+		log.Fatalf("Benchmark failed: %v", err) //nolint:gocritic // cancel does not matter here.
+	}
+
+	if *asJSON {
+		_ = json.NewEncoder(os.Stdout).Encode(result)
+		return
+	}
+
+	log.Println(result)
+}
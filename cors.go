@@ -0,0 +1,50 @@
+package mulery
+
+import (
+	"net/http"
+	"strings"
+)
+
+// defaultCORSMethods are allowed when cors_origins is set without cors_methods.
+//
+//nolint:gochecknoglobals
+var defaultCORSMethods = StringSlice{http.MethodGet, http.MethodOptions}
+
+// CORS adds cross-origin headers to the monitoring handlers, so browser dashboards hosted
+// elsewhere can read them. Preflight requests are answered here, before the monitor tokens
+// are checked, because browsers do not send an Authorization header with them.
+func (c *Config) CORS(next http.Handler) http.Handler {
+	if len(c.CORSOrigins) == 0 {
+		return next
+	}
+
+	methods := c.CORSMethods
+	if len(methods) == 0 {
+		methods = defaultCORSMethods
+	}
+
+	headers := "Authorization, Content-Type"
+	if c.IDHeader != "" {
+		headers += ", " + c.IDHeader // the stats handler reads the client ID from this header.
+	}
+
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		origin := req.Header.Get("Origin")
+		if origin == "" || (!c.CORSOrigins.Contains("*") && !c.CORSOrigins.Contains(origin)) {
+			next.ServeHTTP(resp, req)
+			return
+		}
+
+		resp.Header().Set("Access-Control-Allow-Origin", origin)
+		resp.Header().Add("Vary", "Origin")
+
+		if req.Method != http.MethodOptions || req.Header.Get("Access-Control-Request-Method") == "" {
+			next.ServeHTTP(resp, req)
+			return
+		}
+
+		resp.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+		resp.Header().Set("Access-Control-Allow-Headers", headers)
+		resp.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,287 @@
+package mulery
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Service discovery providers.
+const (
+	DiscoveryConsul = "consul"
+	DiscoveryEtcd   = "etcd"
+)
+
+// Service discovery defaults.
+const (
+	DefaultDiscoveryName     = "mulery"
+	DefaultDiscoveryInterval = 15 * time.Second
+	DefaultEtcdPrefix        = "/mulery/instances/"
+	// discoveryTTLs is how many intervals may be missed before the instance disappears.
+	discoveryTTLs = 3
+)
+
+// ErrDiscovery is returned when the discovery service returns an error.
+var ErrDiscovery = errors.New("service discovery request failed")
+
+// Discovery registers this server in Consul or etcd, so callers and peers can find it.
+// The registration includes the connected client count and health, and is updated every interval.
+type Discovery struct {
+	// Provider is consul or etcd.
+	Provider string `json:"provider" toml:"provider" yaml:"provider" xml:"provider"`
+	// URL is the consul agent (like http://127.0.0.1:8500) or etcd (like http://127.0.0.1:2379) address.
+	URL string `json:"url" toml:"url" yaml:"url" xml:"url"`
+	// Token is a consul ACL token, or an etcd auth token.
+	Token string `json:"token" toml:"token" yaml:"token" xml:"token"`
+	// Name is the service name. Default is mulery.
+	Name string `json:"name" toml:"name" yaml:"name" xml:"name"`
+	// ID identifies this instance. Default is the hostname.
+	ID string `json:"id" toml:"id" yaml:"id" xml:"id"`
+	// Address is the URL callers use to reach this server, like https://mulery1.example.com.
+	Address string `json:"address" toml:"address" yaml:"address" xml:"address"`
+	// Prefix is the etcd key prefix. Default is /mulery/instances/.
+	Prefix string `json:"prefix" toml:"prefix" yaml:"prefix" xml:"prefix"`
+	// Interval is how often the registration is updated. Default is 15 seconds.
+	Interval time.Duration `json:"interval" toml:"interval" yaml:"interval" xml:"interval"`
+	lease    string        // etcd lease ID.
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// Instance is the information registered for this server.
+type Instance struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name"`
+	Address string    `json:"address"`
+	Clients int       `json:"clients"`
+	Status  string    `json:"status"`
+	Updated time.Time `json:"updated"`
+}
+
+// setupDiscovery fills in discovery defaults. Runs once on startup.
+func (c *Config) setupDiscovery() error {
+	dsc := c.Discovery
+	if dsc == nil {
+		return nil
+	}
+
+	if dsc.Provider != DiscoveryConsul && dsc.Provider != DiscoveryEtcd {
+		return fmt.Errorf("%w: unknown provider: %s", ErrDiscovery, dsc.Provider)
+	}
+
+	if dsc.Name == "" {
+		dsc.Name = DefaultDiscoveryName
+	}
+
+	if dsc.ID == "" {
+		dsc.ID, _ = os.Hostname()
+	}
+
+	if dsc.Prefix == "" {
+		dsc.Prefix = DefaultEtcdPrefix
+	}
+
+	if dsc.Interval <= 0 {
+		dsc.Interval = DefaultDiscoveryInterval
+	}
+
+	dsc.URL = strings.TrimSuffix(dsc.URL, "/")
+
+	return nil
+}
+
+// runDiscovery keeps this server registered until Shutdown, then removes the registration.
+func (c *Config) runDiscovery() {
+	dsc := c.Discovery
+	ticker := time.NewTicker(dsc.Interval)
+
+	defer func() {
+		ticker.Stop()
+		c.deregister()
+		close(dsc.done)
+	}()
+
+	for {
+		if err := c.register(); err != nil {
+			c.Errorf("Service discovery (%s): %v", dsc.Provider, err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-dsc.stop:
+			return
+		}
+	}
+}
+
+// instance returns the current information about this server.
+func (c *Config) instance(ctx context.Context) *Instance {
+	return &Instance{
+		ID:      c.Discovery.ID,
+		Name:    c.Discovery.Name,
+		Address: c.Discovery.Address,
+		Clients: len(c.dispatch.Clients()),
+		Status:  c.Health(ctx).Status,
+		Updated: time.Now().UTC(),
+	}
+}
+
+// register creates or updates the registration.
+func (c *Config) register() error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.Discovery.Interval)
+	defer cancel()
+
+	instance := c.instance(ctx)
+
+	if c.Discovery.Provider == DiscoveryEtcd {
+		return c.registerEtcd(ctx, instance)
+	}
+
+	return c.registerConsul(ctx, instance)
+}
+
+// deregister removes the registration. Errors are logged.
+func (c *Config) deregister() {
+	ctx, cancel := context.WithTimeout(context.Background(), c.Discovery.Interval)
+	defer cancel()
+
+	var err error
+
+	if c.Discovery.Provider == DiscoveryEtcd {
+		if c.Discovery.lease != "" {
+			err = c.discoveryCall(ctx, http.MethodPost, "/v3/lease/revoke", map[string]string{"ID": c.Discovery.lease}, nil)
+		}
+	} else {
+		err = c.discoveryCall(ctx, http.MethodPut, "/v1/agent/service/deregister/"+c.Discovery.ID, nil, nil)
+	}
+
+	if err != nil {
+		c.Errorf("Service discovery (%s) deregistration: %v", c.Discovery.Provider, err)
+	}
+}
+
+// registerConsul registers the service with a TTL check, and passes the check with the current health.
+// Registering again updates the client count in the service metadata.
+func (c *Config) registerConsul(ctx context.Context, instance *Instance) error {
+	ttl := c.Discovery.Interval * discoveryTTLs
+	service := map[string]any{
+		"ID":      instance.ID,
+		"Name":    instance.Name,
+		"Address": instance.Address,
+		"Meta":    map[string]string{"clients": strconv.Itoa(instance.Clients), "status": instance.Status},
+		"Check": map[string]any{
+			"CheckID":                        "service:" + instance.ID,
+			"TTL":                            ttl.String(),
+			"DeregisterCriticalServiceAfter": (ttl * discoveryTTLs).String(),
+		},
+	}
+
+	if err := c.discoveryCall(ctx, http.MethodPut, "/v1/agent/service/register", service, nil); err != nil {
+		return err
+	}
+
+	status := map[string]string{HealthOK: "passing", HealthWarn: "warning", HealthFail: "critical"}[instance.Status]
+	update := map[string]string{"Status": status, "Output": strconv.Itoa(instance.Clients) + " clients"}
+
+	return c.discoveryCall(ctx, http.MethodPut, "/v1/agent/check/update/service:"+instance.ID, update, nil)
+}
+
+// registerEtcd writes the instance as JSON to a key attached to a lease.
+// The lease expires if this server stops updating it. A new lease is made if it expired.
+func (c *Config) registerEtcd(ctx context.Context, instance *Instance) error {
+	dsc := c.Discovery
+
+	if dsc.lease != "" {
+		var alive struct {
+			Result struct {
+				TTL string `json:"TTL"`
+			} `json:"result"`
+		}
+
+		err := c.discoveryCall(ctx, http.MethodPost, "/v3/lease/keepalive", map[string]string{"ID": dsc.lease}, &alive)
+		if err != nil || alive.Result.TTL == "" || alive.Result.TTL == "0" {
+			dsc.lease = "" // expired.
+		}
+	}
+
+	if dsc.lease == "" {
+		var grant struct {
+			ID string `json:"ID"`
+		}
+
+		ttl := int((dsc.Interval * discoveryTTLs).Seconds())
+		if err := c.discoveryCall(ctx, http.MethodPost, "/v3/lease/grant", map[string]int{"TTL": ttl}, &grant); err != nil {
+			return err
+		}
+
+		dsc.lease = grant.ID
+	}
+
+	value, _ := json.Marshal(instance)
+
+	return c.discoveryCall(ctx, http.MethodPost, "/v3/kv/put", map[string]string{
+		"key":   base64.StdEncoding.EncodeToString([]byte(dsc.Prefix + instance.ID)),
+		"value": base64.StdEncoding.EncodeToString(value),
+		"lease": dsc.lease,
+	}, nil)
+}
+
+// discoveryCall sends a JSON request to the discovery service, and decodes the response into output.
+func (c *Config) discoveryCall(ctx context.Context, method, path string, input, output any) error {
+	var body io.Reader
+
+	if input != nil {
+		data, err := json.Marshal(input)
+		if err != nil {
+			return fmt.Errorf("encoding request: %w", err)
+		}
+
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.Discovery.URL+path, body)
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	if c.Discovery.Token != "" {
+		if c.Discovery.Provider == DiscoveryConsul {
+			req.Header.Set("X-Consul-Token", c.Discovery.Token)
+		} else {
+			req.Header.Set("Authorization", c.Discovery.Token)
+		}
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrDiscovery, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512)) //nolint:gomnd
+		return fmt.Errorf("%w: %s %s: %s: %s", ErrDiscovery, method, path, resp.Status, bytes.TrimSpace(msg))
+	}
+
+	if output == nil {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(output); err != nil {
+		return fmt.Errorf("decoding response: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,226 @@
+package mulery
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Health check statuses. Any failed check makes /health return 503.
+const (
+	HealthOK   = "ok"
+	HealthWarn = "warn"
+	HealthFail = "fail"
+)
+
+const (
+	// healthTimeout is how long to wait for the dispatcher and the auth proxy.
+	healthTimeout = 2 * time.Second
+	// authCheckInterval is how long an auth proxy check result is cached.
+	authCheckInterval = 30 * time.Second
+	// certWarnDays is how close to expiration a certificate is before it's a warning.
+	certWarnDays = 7 * 24 * time.Hour
+)
+
+// HealthReport is the /health response. Checks are only shown to monitors.
+type HealthReport struct {
+	Status string                  `json:"status"`
+	Time   time.Time               `json:"time"`
+	Checks map[string]*HealthCheck `json:"checks,omitempty"`
+}
+
+// HealthCheck is the result of one component's health check.
+type HealthCheck struct {
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+// authCheck caches the last auth proxy check, so load balancers do not hammer it.
+type authCheck struct {
+	sync.Mutex
+	checked time.Time
+	result  *HealthCheck
+}
+
+// HandleHealth reports the health of the dispatcher, pools, auth proxy, certificate and logs.
+// Returns 503 if any check fails, so load balancers can use it. Only the overall status is sent,
+// unless the request is from an upstream or has a monitor token, like the stats handlers.
+// The checks include internal URLs, errors and paths.
+func (c *Config) HandleHealth(resp http.ResponseWriter, req *http.Request) {
+	report := c.Health(req.Context())
+	resp.Header().Set("Content-Type", "application/json")
+
+	if !c.allow.Contains(req.RemoteAddr) && !c.validToken(req) {
+		report.Checks = nil
+	}
+
+	if report.Status == HealthFail {
+		resp.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	if err := json.NewEncoder(resp).Encode(report); err != nil {
+		c.Errorf("Encoding health report: %v", err)
+	}
+}
+
+// Health runs every health check and returns a report.
+func (c *Config) Health(ctx context.Context) *HealthReport {
+	report := &HealthReport{Status: HealthOK, Time: time.Now(), Checks: make(map[string]*HealthCheck)}
+	report.Checks["dispatcher"], report.Checks["pools"] = c.checkDispatcher(ctx)
+	report.Checks["authProxy"] = c.checkAuthProxy(ctx)
+	report.Checks["certificate"] = c.checkCertificate()
+	report.Checks["logs"] = c.checkLogs()
+
+	for _, check := range report.Checks {
+		if check.Status == HealthFail || (check.Status == HealthWarn && report.Status == HealthOK) {
+			report.Status = check.Status
+		}
+	}
+
+	return report
+}
+
+// checkDispatcher makes sure the main loop is answering, and counts the connected clients.
+func (c *Config) checkDispatcher(ctx context.Context) (*HealthCheck, *HealthCheck) {
+	ctx, cancel := context.WithTimeout(ctx, healthTimeout)
+	defer cancel()
+
+	clients, err := c.dispatch.ClientsContext(ctx)
+	if err != nil {
+		failed := &HealthCheck{Status: HealthFail, Message: "dispatcher did not answer: " + err.Error()}
+		return failed, &HealthCheck{Status: HealthWarn, Message: "unknown"}
+	}
+
+	pools := &HealthCheck{Status: HealthOK, Message: fmt.Sprintf("%d connected", len(clients))}
+	degraded := 0
+
+	for _, client := range clients {
+		if client.Degraded {
+			degraded++
+		}
+	}
+
+	if degraded > 0 {
+		pools.Status = HealthWarn
+		pools.Message += fmt.Sprintf(", %d degraded", degraded)
+	}
+
+	return &HealthCheck{Status: HealthOK}, pools
+}
+
+// checkAuthProxy makes sure the auth proxies answer. Any HTTP response is healthy.
+// Fails if none of them answer, and warns if only some of them answer.
+func (c *Config) checkAuthProxy(ctx context.Context) *HealthCheck {
+	if len(c.authProxies) == 0 {
+		return &HealthCheck{Status: HealthWarn, Message: "auth_url is not configured"}
+	}
+
+	c.auth.Lock()
+	defer c.auth.Unlock()
+
+	if time.Since(c.auth.checked) < authCheckInterval && c.auth.result != nil {
+		return c.auth.result
+	}
+
+	c.auth.checked = time.Now()
+	messages := make([]string, len(c.authProxies))
+	failed := 0
+
+	for idx, endpoint := range c.authProxies {
+		if err := c.pingAuthProxy(ctx, endpoint.url); err != nil {
+			messages[idx] = err.Error()
+			failed++
+		} else {
+			messages[idx] = endpoint.url + ": ok"
+		}
+	}
+
+	c.auth.result = &HealthCheck{Status: HealthOK, Message: strings.Join(messages, "; ")}
+
+	switch failed {
+	case 0:
+	case len(c.authProxies):
+		c.auth.result.Status = HealthFail
+	default:
+		c.auth.result.Status = HealthWarn
+	}
+
+	return c.auth.result
+}
+
+// pingAuthProxy returns an error if an auth proxy does not answer.
+func (c *Config) pingAuthProxy(ctx context.Context, authURL string) error {
+	ctx, cancel := context.WithTimeout(ctx, healthTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, authURL, nil)
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err //nolint:wrapcheck // the url is in the error.
+	}
+	defer resp.Body.Close()
+
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	return nil
+}
+
+// checkCertificate makes sure the TLS certificate is not expired, or about to expire.
+func (c *Config) checkCertificate() *HealthCheck {
+	if c.tls == nil || c.tls.GetCertificate == nil {
+		return &HealthCheck{Status: HealthOK, Message: "tls is not configured"}
+	}
+
+	hello := &tls.ClientHelloInfo{}
+	if len(c.SSLNames) > 0 {
+		hello.ServerName = c.SSLNames[0]
+	}
+
+	cert, err := c.tls.GetCertificate(hello)
+	if err != nil || cert == nil || len(cert.Certificate) == 0 {
+		return &HealthCheck{Status: HealthFail, Message: fmt.Sprintf("no certificate: %v", err)}
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return &HealthCheck{Status: HealthFail, Message: err.Error()}
+	}
+
+	check := &HealthCheck{Status: HealthOK, Message: "expires " + leaf.NotAfter.Format(time.RFC3339)}
+
+	switch left := time.Until(leaf.NotAfter); {
+	case left <= 0:
+		check.Status = HealthFail
+	case left < certWarnDays:
+		check.Status = HealthWarn
+	}
+
+	return check
+}
+
+// checkLogs makes sure the log files still exist. They disappear if a volume is unmounted.
+func (c *Config) checkLogs() *HealthCheck {
+	for _, path := range []string{c.LogFile, c.HTTPLog, c.AuditLog} {
+		if path == "" {
+			continue
+		}
+
+		if _, err := os.Stat(path); err != nil {
+			return &HealthCheck{Status: HealthFail, Message: err.Error()}
+		}
+	}
+
+	return &HealthCheck{Status: HealthOK}
+}
@@ -0,0 +1,70 @@
+package mulery
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golift.io/mulery/mulch"
+	"golift.io/mulery/server"
+)
+
+// TestHandleHealthDetails makes sure only upstreams and monitors see the health checks.
+func TestHandleHealthDetails(t *testing.T) {
+	t.Parallel()
+
+	config := &Config{
+		Config:        server.NewConfig(),
+		MonitorTokens: StringSlice{"monitor-token"},
+		auth:          &authCheck{},
+		allow:         MakeIPs([]string{"10.0.0.1"}),
+	}
+	config.Logger = &mulch.DefaultLogger{Silent: true}
+	config.dispatch = server.NewServer(config.Config)
+	t.Cleanup(config.allow.Stop)
+
+	go config.dispatch.StartDispatcher()
+	t.Cleanup(config.dispatch.Shutdown)
+
+	tests := []struct {
+		name    string
+		remote  string
+		token   string
+		details bool
+	}{
+		{name: "public", remote: "192.0.2.1:1234", details: false},
+		{name: "bad token", remote: "192.0.2.1:1234", token: "wrong", details: false},
+		{name: "monitor token", remote: "192.0.2.1:1234", token: "monitor-token", details: true},
+		{name: "upstream", remote: "10.0.0.1:1234", details: true},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/health", nil)
+		req.RemoteAddr = test.remote
+
+		if test.token != "" {
+			req.Header.Set("Authorization", "Bearer "+test.token)
+		}
+
+		resp := httptest.NewRecorder()
+		config.HandleHealth(resp, req)
+
+		var report HealthReport
+		if err := json.Unmarshal(resp.Body.Bytes(), &report); err != nil {
+			t.Fatalf("%s: decoding report: %v", test.name, err)
+		}
+
+		if report.Status == "" {
+			t.Errorf("%s: report has no status", test.name)
+		}
+
+		if (len(report.Checks) > 0) != test.details {
+			t.Errorf("%s: got checks %v, want details: %v", test.name, report.Checks, test.details)
+		}
+
+		if test.details && report.Checks["dispatcher"].Status != HealthOK {
+			t.Errorf("%s: dispatcher check: %+v", test.name, report.Checks["dispatcher"])
+		}
+	}
+}
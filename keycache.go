@@ -0,0 +1,154 @@
+package mulery
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Key cache lookup results used in the key cache metric.
+const (
+	cacheHit      = "hit"
+	cacheNegative = "negative-hit"
+	cacheMiss     = "miss"
+)
+
+// ErrCachedKey is wrapped with ErrInvalidKey when a key failed validation recently.
+var ErrCachedKey = errors.New("key failed validation recently")
+
+// keyResult is a cached auth proxy response for a key.
+type keyResult struct {
+	Valid   bool      `json:"valid"`
+	Expires time.Time `json:"expires"`
+}
+
+// keyCache stores auth proxy results. Keys are hashed before they are stored.
+type keyCache interface {
+	get(ctx context.Context, hash string) (*keyResult, bool)
+	set(ctx context.Context, hash string, result *keyResult, ttl time.Duration)
+}
+
+// memoryCache is a keyCache that lives in this process.
+type memoryCache struct {
+	mu      sync.Mutex
+	entries map[string]*memoryEntry
+	pruned  time.Time
+}
+
+type memoryEntry struct {
+	*keyResult
+	until time.Time
+}
+
+func newMemoryCache() *memoryCache {
+	return &memoryCache{entries: make(map[string]*memoryEntry), pruned: time.Now()}
+}
+
+func (m *memoryCache) get(_ context.Context, hash string) (*keyResult, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry := m.entries[hash]
+	if entry == nil || time.Now().After(entry.until) {
+		return nil, false
+	}
+
+	return entry.keyResult, true
+}
+
+func (m *memoryCache) set(_ context.Context, hash string, result *keyResult, ttl time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	m.entries[hash] = &memoryEntry{keyResult: result, until: now.Add(ttl)}
+
+	if now.Sub(m.pruned) < pruneInterval {
+		return
+	}
+
+	m.pruned = now
+
+	for hash, entry := range m.entries {
+		if now.After(entry.until) {
+			delete(m.entries, hash)
+		}
+	}
+}
+
+// setupKeyCache creates the key cache if a TTL is configured.
+// The cache is kept in memory, unless a redis URL is provided.
+func (c *Config) setupKeyCache() error {
+	switch {
+	case c.KeyCacheTTL <= 0 && c.KeyCacheNegativeTTL <= 0:
+		return nil
+	case c.KeyCacheRedis == "":
+		c.keys = newMemoryCache()
+		return nil
+	}
+
+	redis, err := newRedisCache(c.KeyCacheRedis, c.Errorf)
+	if err != nil {
+		return err
+	}
+
+	c.keys = redis
+
+	return nil
+}
+
+// hashKey returns the cache key for a client secret key, so keys are never stored in plain text.
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// cachedKey returns a cached validation result for a key.
+// The bool is false if the key is not cached, and the auth proxy must be asked.
+func (c *Config) cachedKey(ctx context.Context, key string) (time.Time, bool, error) {
+	if c.keys == nil {
+		return time.Time{}, false, nil
+	}
+
+	result, ok := c.keys.get(ctx, hashKey(key))
+
+	switch {
+	case !ok:
+		c.metrics.addKeyCache(cacheMiss)
+		return time.Time{}, false, nil
+	case !result.Valid:
+		c.metrics.addKeyCache(cacheNegative)
+		return time.Time{}, true, fmt.Errorf("%w: %w", ErrInvalidKey, ErrCachedKey)
+	default:
+		c.metrics.addKeyCache(cacheHit)
+		return result.Expires, true, nil
+	}
+}
+
+// cacheKey saves an auth proxy result. Only definite answers are cached; connection errors are not.
+// Valid keys are never cached beyond their expiration.
+func (c *Config) cacheKey(ctx context.Context, key string, expires time.Time, err error) {
+	if c.keys == nil {
+		return
+	}
+
+	result := &keyResult{Valid: err == nil, Expires: expires}
+	ttl := c.KeyCacheTTL
+
+	switch {
+	case err != nil && !errors.Is(err, ErrInvalidKey):
+		return
+	case err != nil:
+		ttl = c.KeyCacheNegativeTTL
+	case !expires.IsZero() && time.Until(expires) < ttl:
+		ttl = time.Until(expires)
+	}
+
+	if ttl > 0 {
+		c.keys.set(ctx, hashKey(key), result, ttl)
+	}
+}
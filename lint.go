@@ -0,0 +1,132 @@
+package mulery
+
+import (
+	"strings"
+)
+
+// Lint returns warnings about suspicious app and server configuration combinations.
+func (c *Config) Lint() []string {
+	return append(c.lintApp(), c.Config.Lint()...)
+}
+
+// lintApp returns warnings for the app configuration.
+// The server logs its own warnings when it starts.
+func (c *Config) lintApp() []string {
+	var warnings []string
+
+	for idx, input := range c.allow.input {
+		if c.allow.nets[idx] == nil {
+			warnings = append(warnings, "upstream '"+input+"' is not a valid IP or CIDR and failed DNS lookup: it is ignored")
+		}
+	}
+
+	for idx, input := range c.trusted.input {
+		if c.trusted.nets[idx] == nil {
+			warnings = append(warnings, "trusted proxy '"+input+"' is not a valid IP or CIDR and failed DNS lookup: it is ignored")
+		}
+	}
+
+	if len(c.authProxies) == 0 {
+		warnings = append(warnings, "auth_url is empty: every client registration will fail")
+	} else if c.AuthHeader == "" {
+		warnings = append(warnings, "auth_url is set without auth_header: the auth proxy will not receive client keys")
+	}
+
+	if (len(c.Vhosts) > 0 || c.VhostWildcard != "") && c.IDHeader == "" {
+		warnings = append(warnings, "vhosts are configured without id_header: vhost requests go to random clients")
+	}
+
+	if len(c.Peers) > 0 && (c.PeerToken == "" || c.IDHeader == "") {
+		warnings = append(warnings, "peers are configured without peer_token or id_header: requests are never sent to peers")
+	}
+
+	if c.Discovery != nil && c.Discovery.Address == "" {
+		warnings = append(warnings, "discovery is configured without an address: callers cannot reach this server")
+	}
+
+	if c.RateLimit != nil && c.RateLimit.Client > 0 && c.IDHeader == "" {
+		warnings = append(warnings, "rate_limit.client is set without id_header: it is ignored")
+	}
+
+	if c.VhostWildcard != "" && c.wildcard == "" {
+		warnings = append(warnings, "vhost_wildcard '"+c.VhostWildcard+"' does not begin with '*.': it is ignored")
+	} else if c.wildcard != "" && len(c.VhostLabels) == 0 {
+		warnings = append(warnings, "vhost_wildcard is set without vhost_labels: no wildcard hostname is routed")
+	}
+
+	warnings = append(warnings, c.lintListeners()...)
+
+	if c.RedirectHTTP != "" && !c.tlsEnabled() {
+		warnings = append(warnings, "redirect_http is set, but SSL is not configured: it is ignored")
+	}
+
+	if (c.SSLCertFile == "") != (c.SSLKeyFile == "") {
+		warnings = append(warnings, "ssl_cert_file and ssl_key_file must both be set to use a static certificate")
+	}
+
+	if !(StringSlice{ChallengeDNS, ChallengeHTTP, ChallengeTLSALPN}).Contains(c.ACMEChallenge) {
+		warnings = append(warnings, "acme_challenge '"+c.ACMEChallenge+"' is not dns, http or tls-alpn: dns is used")
+	}
+
+	ssl := []bool{c.CacheDir != "", len(c.SSLNames) > 0, c.CFToken != "" || !c.needsCFToken()}
+	if c.SSLCertFile == "" && !allEqual(ssl) {
+		warnings = append(warnings, "cache_dir, ssl_names and cf_token (for dns challenges) must all be set "+
+			"to enable SSL: missing "+strings.Join(missingSSL(c), ", "))
+	}
+
+	return warnings
+}
+
+func allEqual(values []bool) bool {
+	for _, v := range values {
+		if v != values[0] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func missingSSL(c *Config) []string {
+	var missing []string
+
+	if c.CacheDir == "" {
+		missing = append(missing, "cache_dir")
+	}
+
+	if len(c.SSLNames) == 0 {
+		missing = append(missing, "ssl_names")
+	}
+
+	if c.CFToken == "" && c.needsCFToken() {
+		missing = append(missing, "cf_token")
+	}
+
+	return missing
+}
+
+func (c *Config) lintListeners() []string {
+	var (
+		warnings []string
+		ssl      = c.tlsEnabled()
+	)
+
+	if c.ListenAddr == "" && len(c.Listeners) == 0 {
+		warnings = append(warnings, "listen_addr is empty and no listeners are configured: nothing is served")
+	}
+
+	for _, listener := range c.Listeners {
+		if listener.TLS && !ssl {
+			warnings = append(warnings, "listener "+listener.Addr+" has tls enabled, but SSL is not configured")
+		}
+
+		for _, name := range listener.Handlers {
+			if !handlerSets.Contains(name) {
+				warnings = append(warnings, "listener "+listener.Addr+" has unknown handlers '"+name+"': "+
+					"choose from "+strings.Join(handlerSets, ", "))
+			}
+		}
+	}
+
+	return warnings
+}
@@ -0,0 +1,208 @@
+package mulery
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+
+	apachelog "github.com/lestrrat-go/apache-logformat/v2"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Handler sets that may be enabled on a Listener.
+const (
+	HandlersRegister = "register" // /register for clients.
+	HandlersRequest  = "request"  // /request/ and vhosts for upstreams.
+	HandlersStats    = "stats"    // /stats and /stats/history.
+	HandlersState    = "state"    // /state.
+	HandlersMetrics  = "metrics"  // /metrics.
+	HandlersHealth   = "health"   // /health with component checks.
+	HandlersPeer     = "peer"     // /peer for other mulery servers, only with a peer_token.
+	HandlersVersion  = "version"  // /version with build info.
+)
+
+// disabledPath turns off a handler set when it is used in Paths.
+const disabledPath = "-"
+
+// ErrInvalidPath is returned when a configured handler path is not usable.
+var ErrInvalidPath = errors.New("invalid path")
+
+// handlerSets is every handler set name. The default path for each is /name.
+//
+//nolint:gochecknoglobals
+var handlerSets = StringSlice{
+	HandlersRegister, HandlersRequest, HandlersStats, HandlersState, HandlersMetrics, HandlersHealth, HandlersPeer,
+	HandlersVersion,
+}
+
+// Listener is an additional address to listen on, with its own set of handlers.
+// Use this to serve upstream /request traffic on an internal port, and /register on a public port.
+type Listener struct {
+	Addr string `json:"addr" toml:"addr" yaml:"addr" xml:"addr"`
+	// TLS serves this listener with the certmagic certificate, if one is configured.
+	TLS bool `json:"tls" toml:"tls" yaml:"tls" xml:"tls"`
+	// Handlers are the handler sets to enable on this listener. Empty enables all of them.
+	// Choose from: register, request, stats, state, metrics, health, peer, version.
+	Handlers StringSlice `json:"handlers" toml:"handlers" yaml:"handlers" xml:"handlers"`
+}
+
+// route is a path and handler in a handler set.
+type route struct {
+	path    string
+	handler http.Handler
+}
+
+// routes returns every handler set, at the configured paths. Disabled sets are not included.
+func (c *Config) routes(apache *apachelog.ApacheLog) map[string][]route {
+	wrap := func(handler http.Handler) http.Handler { return apache.Wrap(handler, c.httpLog.Writer()) }
+	monitor := func(handler http.Handler) http.Handler { return wrap(c.CORS(c.ValidateMonitor(handler))) }
+	request := c.path(HandlersRequest)
+	stats := c.path(HandlersStats)
+	peer := c.path(HandlersPeer)
+	routes := map[string][]route{
+		HandlersRegister: {{c.path(HandlersRegister), c.dispatch.HandleRegister()}}, // apache log can't do websockets.
+		HandlersRequest: {
+			{request, wrap(http.HandlerFunc(c.HandleAll))}, // handleAll
+			{request + "/", wrap(http.StripPrefix(request, c.ValidateUpstream(c.Limit(c.labelPath()))))},
+		},
+		HandlersStats: {
+			{stats, monitor(http.HandlerFunc(c.dispatch.HandleStats))},
+			{stats + "/history", monitor(http.HandlerFunc(c.dispatch.HandleStatsHistory))},
+		},
+		HandlersState:   {{c.path(HandlersState), monitor(http.HandlerFunc(c.HandleState))}},
+		HandlersMetrics: {{c.path(HandlersMetrics), monitor(promhttp.Handler())}},
+		HandlersHealth:  {{c.path(HandlersHealth), wrap(c.CORS(http.HandlerFunc(c.HandleHealth)))}},
+		HandlersVersion: {{c.path(HandlersVersion), monitor(http.HandlerFunc(c.HandleVersion))}},
+		HandlersPeer: {
+			{peer, wrap(http.HandlerFunc(c.HandlePeers))},
+			{peer + peerRequestPath + "/", wrap(http.StripPrefix(peer+peerRequestPath, c.ValidatePeer(c.labelPath())))},
+		},
+	}
+
+	for set := range routes {
+		if c.path(set) == disabledPath || (set == HandlersPeer && c.PeerToken == "") {
+			delete(routes, set)
+		}
+	}
+
+	return routes
+}
+
+// path returns the configured path for a handler set, or its default path.
+func (c *Config) path(set string) string {
+	if path := c.Paths[set]; path != "" {
+		return strings.TrimSuffix(path, "/")
+	}
+
+	return "/" + set
+}
+
+// parsePaths makes sure the configured paths are for known handler sets, look like paths, and are unique.
+func (c *Config) parsePaths() error {
+	for set, path := range c.Paths {
+		switch {
+		case !handlerSets.Contains(set):
+			return fmt.Errorf("%w: unknown handler set in paths: %s", ErrInvalidPath, set)
+		case path != disabledPath && (!strings.HasPrefix(path, "/") || strings.TrimSuffix(path, "/") == ""):
+			return fmt.Errorf("%w: %s path must begin with / and may not be /: %s", ErrInvalidPath, set, path)
+		}
+	}
+
+	return c.uniquePaths()
+}
+
+// uniquePaths returns an error if two handler sets use the same path; http.ServeMux panics on those.
+// The paths each set adds to its own path are the ones in routes.
+func (c *Config) uniquePaths() error {
+	used := make(map[string]string)
+
+	for _, set := range handlerSets {
+		path := c.path(set)
+		if path == disabledPath || (set == HandlersPeer && c.PeerToken == "") {
+			continue
+		}
+
+		paths := []string{path}
+
+		switch set {
+		case HandlersRequest:
+			paths = append(paths, path+"/")
+		case HandlersStats:
+			paths = append(paths, path+"/history")
+		case HandlersPeer:
+			paths = append(paths, path+peerRequestPath+"/")
+		}
+
+		for _, path := range paths {
+			if other, ok := used[path]; ok {
+				return fmt.Errorf("%w: %s and %s handlers both use path %s", ErrInvalidPath, other, set, path)
+			}
+
+			used[path] = set
+		}
+	}
+
+	return nil
+}
+
+// newHandler returns a mux with the provided handler sets. Empty sets enables all of them.
+func (c *Config) newHandler(apache *apachelog.ApacheLog, routes map[string][]route, sets StringSlice) http.Handler {
+	smx := http.NewServeMux()
+
+	for name, set := range routes {
+		if len(sets) > 0 && !sets.Contains(name) {
+			continue
+		}
+
+		for _, route := range set {
+			smx.Handle(route.path, route.handler)
+		}
+	}
+
+	smx.Handle("/", apache.Wrap(http.HandlerFunc(c.HandleAll), c.httpLog.Writer()))
+
+	if len(sets) > 0 && !sets.Contains(HandlersRequest) {
+		return c.RealIP(smx)
+	}
+
+	return c.RealIP(c.VhostRouter(smx, apache.Wrap(c.Limit(c.dispatch.HandleRequest(vhostHandler)), c.httpLog.Writer())))
+}
+
+// listen binds a server's address. This happens before serving, so the port is open when systemd is told we
+// are ready. Exits if the address cannot be bound, like a failed web server does.
+func (c *Config) listen(srv *http.Server) net.Listener {
+	addr := srv.Addr
+
+	switch {
+	case addr != "":
+	case srv.TLSConfig != nil:
+		addr = ":https"
+	default:
+		addr = ":http"
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatalln("Web server failed, exiting:", err)
+	}
+
+	return listener
+}
+
+// runWebServer serves a bound listener until it is shutdown.
+func (c *Config) runWebServer(srv *http.Server, listener net.Listener) {
+	var err error
+
+	if srv.TLSConfig != nil {
+		err = srv.ServeTLS(listener, "", "")
+	} else {
+		err = srv.Serve(listener)
+	}
+
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatalln("Web server failed, exiting:", err)
+	}
+}
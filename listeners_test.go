@@ -0,0 +1,34 @@
+package mulery
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParsePathsUnique(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name  string
+		paths map[string]string
+		token string
+		fail  bool
+	}{
+		{name: "defaults"},
+		{name: "moved", paths: map[string]string{"stats": "/s", "state": "/stats"}},
+		{name: "same", paths: map[string]string{"state": "/metrics/"}, fail: true},
+		{name: "history", paths: map[string]string{"health": "/stats/history"}, fail: true},
+		{name: "request", paths: map[string]string{"request": "/api", "version": "/api/"}, fail: true},
+		{name: "disabled", paths: map[string]string{"state": "-", "metrics": "-"}},
+		{name: "no peers", paths: map[string]string{"peer": "/state"}},
+		{name: "peer", paths: map[string]string{"peer": "/state"}, token: "secret", fail: true},
+		{name: "peer request", paths: map[string]string{"peer": "/p", "request": "/p/request"}, token: "x", fail: true},
+	}
+
+	for _, test := range tests {
+		config := &Config{Paths: test.paths, PeerToken: test.token}
+		if err := config.parsePaths(); errors.Is(err, ErrInvalidPath) != test.fail {
+			t.Errorf("%s: got error %v, want error: %v", test.name, err, test.fail)
+		}
+	}
+}
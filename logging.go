@@ -5,6 +5,7 @@ import (
 	"os"
 	"strings"
 
+	"golift.io/mulery/mulch"
 	"golift.io/rotatorr"
 	"golift.io/rotatorr/timerotator"
 )
@@ -15,6 +16,7 @@ import (
 //nolint:gomnd
 func (c *Config) SetupLogs() {
 	c.httpLog = log.New(os.Stdout, "", 0)
+	c.setupAuditLog()
 
 	if c.HTTPLog != "" && c.HTTPLogMB > 0 {
 		c.httpLog.SetOutput(rotatorr.NewMust(&rotatorr.Config{
@@ -25,6 +27,8 @@ func (c *Config) SetupLogs() {
 		}))
 	}
 
+	defer c.setupStructuredLogs()
+
 	if c.LogFile == "" {
 		c.log = log.New(os.Stderr, "", log.LstdFlags)
 		return
@@ -53,34 +57,131 @@ func (c *Config) SetupLogs() {
 	}
 }
 
+// setupStructuredLogs points the json logger at the configured app log output.
+func (c *Config) setupStructuredLogs() {
+	if strings.EqualFold(c.LogFormat, "json") {
+		c.slog = mulch.NewJSONLogger(c.log.Writer(), c.Debug)
+	}
+}
+
 // Debugf writes log lines... to stdout and/or a file.
 func (c *Config) Debugf(msg string, v ...interface{}) {
+	if !c.Debug {
+		return
+	}
+
+	if c.slog != nil {
+		c.slog.Debugf(msg, v...)
+		return
+	}
+
 	c.log.Printf("[DEBUG] "+msg, v...)
 }
 
 // Printf writes log lines... to stdout and/or a file.
 func (c *Config) Printf(msg string, v ...interface{}) {
+	if c.slog != nil {
+		c.slog.Printf(msg, v...)
+		return
+	}
+
 	c.log.Printf("[INFO] "+msg, v...)
 }
 
 // Errorf writes log lines... to stdout and/or a file.
 func (c *Config) Errorf(msg string, v ...interface{}) {
+	if c.slog != nil {
+		c.slog.Errorf(msg, v...)
+		return
+	}
+
 	c.log.Printf("[ERROR] "+msg, v...)
 }
 
+// Warnf writes log lines... to stdout and/or a file.
+func (c *Config) Warnf(msg string, v ...interface{}) {
+	if c.slog != nil {
+		c.slog.Warnf(msg, v...)
+		return
+	}
+
+	c.log.Printf("[WARN] "+msg, v...)
+}
+
+// IsDebug returns true if debug logs are enabled.
+func (c *Config) IsDebug() bool {
+	return c.Debug
+}
+
+// With attaches structured fields to log lines when LogFormat is json.
+// Satisfies the mulch.FieldLogger interface.
+func (c *Config) With(args ...any) mulch.Logger {
+	if c.slog != nil {
+		return c.slog.With(args...)
+	}
+
+	return c
+}
+
 // PrintConfig logs the current configuration information.
 func (c *Config) PrintConfig() {
-	c.Printf("=> Mulery Starting, pid: %d", os.Getpid())
+	c.Printf("=> Mulery Starting, pid: %d, version: %s", os.Getpid(), mulch.ReadBuildInfo())
 	c.Printf("=> Listen Address: %s", c.ListenAddr)
-	c.Printf("=> Dispatch Threads: %d", c.Dispatchers)
+
+	for _, listener := range c.Listeners {
+		c.Printf("=> Listener: %s, tls: %v, handlers: %s", listener.Addr, listener.TLS, strings.Join(listener.Handlers, ", "))
+	}
+
+	for _, set := range handlerSets {
+		if path := c.path(set); path != "/"+set {
+			c.Printf("=> Path for %s: %s", set, path)
+		}
+	}
+
+	c.Printf("=> Dispatch Threads: %d, copy buffer size: %d, max header size: %d",
+		c.Dispatchers, c.CopyBufferSize, c.MaxHeaderSize)
+	c.Printf("=> Websocket Buffers: read %d, write %d, compression: %v (level %d)",
+		c.ReadBufferSize, c.WriteBufferSize, !c.DisableCompression, mulch.CompressionLevel(c.CompressionLevel))
+	c.Printf("=> Stats History: %d", c.StatsHistory)
 	c.Printf("=> Auth URL/Header: %s / %s", c.AuthURL, c.AuthHeader)
+	c.Printf("=> Auth Failover URLs: %s (timeout: %v, backoff: %v)",
+		strings.Join(c.AuthURLs, ", "), c.AuthTimeout, c.AuthBackoff)
+	c.Printf("=> Key Cache TTL: %v, negative: %v, redis: %s",
+		c.KeyCacheTTL, c.KeyCacheNegativeTTL, c.Redacted().KeyCacheRedis)
 	c.Printf("=> Allowed Requesters: %s", c.allow.String())
+	c.Printf("=> Peers: %s (token: %v, interval: %v)", strings.Join(c.Peers, ", "), c.PeerToken != "", c.PeerInterval)
+	if c.Discovery != nil {
+		c.Printf("=> Discovery: %s at %s, service: %s, id: %s, address: %s, interval: %v",
+			c.Discovery.Provider, c.Discovery.URL, c.Discovery.Name, c.Discovery.ID, c.Discovery.Address, c.Discovery.Interval)
+	}
+
+	c.Printf("=> Trusted Proxies: %s", c.trusted.String())
+	c.Printf("=> Monitor Tokens: %d", len(c.MonitorTokens))
+	c.Printf("=> CORS Origins: %s (methods: %s)", strings.Join(c.CORSOrigins, ", "), strings.Join(c.CORSMethods, ", "))
 	c.Printf("=> CacheDir: %s", c.CacheDir)
 	c.Printf("=> Email / Token: %s / %v", c.Email, len(c.CFToken) > 0)
-	c.Printf("=> SSL Names: %s", strings.Join(c.SSLNames, ", "))
+	c.Printf("=> SSL Names: %s (acme challenge: %s)", strings.Join(c.SSLNames, ", "), c.ACMEChallenge)
+	c.Printf("=> SSL Cert/Key Files: %s / %s", c.SSLCertFile, c.SSLKeyFile)
+	c.Printf("=> TLS Min Version: %s, ciphers: %d, alpn: %s",
+		c.TLSMinVersion, len(c.TLSCiphers), strings.Join(c.TLSALPN, ", "))
+	c.Printf("=> HTTP Redirect Address: %s", c.RedirectHTTP)
+	c.Printf("=> Vhosts: %d, wildcard: %s, labels: %d", len(c.Vhosts), c.VhostWildcard, len(c.VhostLabels))
+	c.Printf("=> Path Label Rules: %d", len(c.PathLabels))
+
+	if c.RateLimit != nil {
+		c.Printf("=> Rate Limits: upstream: %g/s, client: %g/s, burst: %d",
+			c.RateLimit.Upstream, c.RateLimit.Client, c.RateLimit.Burst)
+	}
+
 	c.Printf("=> Log File: %s (count: %d, size: %dMB)", c.LogFile, c.LogFiles, c.LogFileMB)
 	c.Printf("=> HTTP Log: %s (count: %d, size: %dMB)", c.HTTPLog, c.HTTPLogs, c.HTTPLogMB)
+	c.Printf("=> Audit Log: %s (count: %d, size: %dMB)", c.AuditLog, c.AuditLogs, c.AuditLogMB)
 	c.Printf("=> Log Format: %s", c.ApacheLogFormat())
+	c.Printf("=> App Log Format: %s, debug: %v", c.LogFormat, c.Debug)
+
+	for _, warning := range c.lintApp() {
+		c.Warnf("Config: %s", warning)
+	}
 }
 
 //nolint:wsl
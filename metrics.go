@@ -0,0 +1,37 @@
+package mulery
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// metrics are the app's own metrics. The server package exports the tunnel metrics.
+type metrics struct {
+	limited  *prometheus.CounterVec
+	keyCache *prometheus.CounterVec
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		limited: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: "mulery_rate_limited_total",
+			Help: "Requests rejected by a rate limit",
+		}, []string{"limit"}),
+		keyCache: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: "mulery_key_cache_lookups_total",
+			Help: "Key validation cache lookups by result",
+		}, []string{"result"}),
+	}
+}
+
+func (m *metrics) addLimited(limit string) {
+	if m != nil {
+		m.limited.WithLabelValues(limit).Inc()
+	}
+}
+
+func (m *metrics) addKeyCache(result string) {
+	if m != nil {
+		m.keyCache.WithLabelValues(result).Inc()
+	}
+}
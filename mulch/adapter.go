@@ -0,0 +1,46 @@
+package mulch
+
+// Infofer is satisfied by zap's SugaredLogger, logrus' Logger and Entry,
+// and most other leveled loggers with printf-style methods.
+type Infofer interface {
+	Debugf(format string, v ...interface{})
+	Infof(format string, v ...interface{})
+	Warnf(format string, v ...interface{})
+	Errorf(format string, v ...interface{})
+}
+
+// Adapter turns an Infofer (like a zap or logrus logger) into a Logger.
+type Adapter struct {
+	Infofer
+	// Debug controls the IsDebug return value.
+	// Set this to match the level of the wrapped logger.
+	Debug bool
+}
+
+// NewAdapter wraps a zap, logrus or other leveled logger.
+//
+//	client.Config.Logger = mulch.NewAdapter(zapLogger.Sugar(), debug)
+//	server.Config.Logger = mulch.NewAdapter(logrus.StandardLogger(), debug)
+func NewAdapter(logger Infofer, debug bool) *Adapter {
+	return &Adapter{Infofer: logger, Debug: debug}
+}
+
+// Debugf writes a debug message if debug is enabled.
+func (a *Adapter) Debugf(format string, v ...interface{}) {
+	if a.Debug {
+		a.Infofer.Debugf(format, v...)
+	}
+}
+
+// Printf writes an info message.
+func (a *Adapter) Printf(format string, v ...interface{}) {
+	a.Infofer.Infof(format, v...)
+}
+
+// IsDebug returns true if debug messages are written.
+func (a *Adapter) IsDebug() bool {
+	return a.Debug
+}
+
+// Validate Adapter struct satisfies the Logger interface.
+var _ = Logger(&Adapter{})
@@ -0,0 +1,75 @@
+package mulch
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+// started is when this process started, close enough.
+//
+//nolint:gochecknoglobals
+var started = time.Now()
+
+// BuildInfo describes the running binary, so operators can tell what is deployed.
+type BuildInfo struct {
+	Version    string    `json:"version"`
+	Commit     string    `json:"commit,omitempty"`
+	CommitTime string    `json:"commitTime,omitempty"`
+	Modified   bool      `json:"modified,omitempty"` // The commit had uncommitted changes.
+	GoVersion  string    `json:"goVersion"`
+	Platform   string    `json:"platform"`
+	Started    time.Time `json:"started"`
+}
+
+// ReadBuildInfo returns the binary's build info. Version is (devel) unless the binary was built
+// with go install module@version; the commit is only known when it was built from a git checkout.
+func ReadBuildInfo() *BuildInfo {
+	info := &BuildInfo{
+		Version:   "(devel)",
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+		Started:   started,
+	}
+
+	build, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+
+	if build.Main.Version != "" {
+		info.Version = build.Main.Version
+	}
+
+	for _, setting := range build.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			info.Commit = setting.Value
+		case "vcs.time":
+			info.CommitTime = setting.Value
+		case "vcs.modified":
+			info.Modified = setting.Value == "true"
+		}
+	}
+
+	return info
+}
+
+// String returns the build info on one line, like: v1.2.3 (abc1234, go1.22.1 linux/amd64).
+func (b *BuildInfo) String() string {
+	commit := b.Commit
+	if len(commit) > 7 { //nolint:gomnd // short hash.
+		commit = commit[:7]
+	}
+
+	if b.Modified {
+		commit += "-dirty"
+	}
+
+	if commit == "" {
+		return fmt.Sprintf("%s (%s %s)", b.Version, b.GoVersion, b.Platform)
+	}
+
+	return fmt.Sprintf("%s (%s, %s %s)", b.Version, commit, b.GoVersion, b.Platform)
+}
@@ -0,0 +1,161 @@
+package mulch
+
+import (
+	"errors"
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
+)
+
+// CloseReason describes why a websocket connection was closed.
+// Both sides send these in close frames, and use them as metric labels.
+type CloseReason string
+
+// Close reasons shared by the client and server.
+const (
+	CloseIdle            CloseReason = "idle"             // Server closed an idle connection it did not need.
+	CloseShutdown        CloseReason = "shutdown"         // The closing side is shutting down.
+	CloseCapacity        CloseReason = "capacity"         // The idle buffer pool is full.
+	CloseAuthExpired     CloseReason = "auth-expired"     // The key used to register the connection expired.
+	CloseAuthRevoked     CloseReason = "auth-revoked"     // The key used to register the connection was revoked.
+	CloseProtocolError   CloseReason = "protocol-error"   // The peer sent something unexpected.
+	CloseUpgradeRequired CloseReason = "upgrade-required" // The peer's protocol version is not supported.
+	CloseDrain           CloseReason = "drain"            // Server is draining: connect somewhere else for now.
+	CloseBanned          CloseReason = "banned"           // Server refuses this client: do not reconnect.
+	CloseProxyError      CloseReason = "proxy-error"      // A tunneled request failed, so the connection was thrown away.
+	CloseHangUp          CloseReason = "hangup"           // The peer went away. Never sent, only used for metrics.
+	CloseUnknown         CloseReason = "unknown"          // The peer sent a code we do not recognize.
+)
+
+// Websocket close codes sent with each close reason. 4000-4999 are for private use; the
+// rest are standard codes. Do not renumber these: clients and servers of different versions
+// must agree on them. Add new codes to the end of the private range.
+const (
+	CodeIdleTimeout     = 4000                             // CloseIdle
+	CodeShutdown        = 4001                             // CloseShutdown
+	CodeOverCapacity    = 4002                             // CloseCapacity
+	CodeAuthExpired     = 4003                             // CloseAuthExpired
+	CodeUpgradeRequired = 4004                             // CloseUpgradeRequired
+	CodeDrain           = 4005                             // CloseDrain
+	CodeBanned          = 4006                             // CloseBanned
+	CodeAuthRevoked     = 4007                             // CloseAuthRevoked
+	CodeProtocolError   = websocket.CloseProtocolError     // CloseProtocolError
+	CodeProxyError      = websocket.CloseInternalServerErr // CloseProxyError
+)
+
+// closeCodes maps reasons to websocket close codes.
+//
+//nolint:gochecknoglobals
+var closeCodes = map[CloseReason]int{
+	CloseIdle:            CodeIdleTimeout,
+	CloseShutdown:        CodeShutdown,
+	CloseCapacity:        CodeOverCapacity,
+	CloseAuthExpired:     CodeAuthExpired,
+	CloseAuthRevoked:     CodeAuthRevoked,
+	CloseUpgradeRequired: CodeUpgradeRequired,
+	CloseDrain:           CodeDrain,
+	CloseBanned:          CodeBanned,
+	CloseProtocolError:   CodeProtocolError,
+	CloseProxyError:      CodeProxyError,
+	CloseHangUp:          websocket.CloseNormalClosure,
+}
+
+// Reconnect says what a client does after a server closes a connection.
+type Reconnect int
+
+// Reconnect behaviors. See CloseReason.Reconnect for the reason each one belongs to.
+const (
+	ReconnectNow       Reconnect = iota // Replace the connection right away, like one that dropped.
+	ReconnectLater                      // Wait a backoff before replacing the connection.
+	ReconnectElsewhere                  // Move to another server if there is one, otherwise wait a backoff.
+	ReconnectNever                      // Stop connecting to this server.
+)
+
+// closeTimeout is how long we wait to write a close frame before closing the socket anyway.
+const closeTimeout = time.Second
+
+// maxCloseText is the longest text allowed in a close frame (125 bytes minus a 2 byte code).
+const maxCloseText = 123
+
+// Code returns the websocket close code for a reason.
+func (r CloseReason) Code() int {
+	if code, ok := closeCodes[r]; ok {
+		return code
+	}
+
+	return websocket.CloseNormalClosure
+}
+
+// Reconnect returns what a client does after a server closes a connection with this reason:
+//   - shutdown and drain: the server is going away, so connect elsewhere.
+//   - capacity and protocol-error: reconnecting right away would get the same answer, so wait.
+//   - banned, auth-revoked and upgrade-required: this client will never be accepted, so stop.
+//
+// Every other reason, like idle and auth-expired, reconnects like a dropped connection.
+func (r CloseReason) Reconnect() Reconnect {
+	switch r {
+	case CloseShutdown, CloseDrain:
+		return ReconnectElsewhere
+	case CloseCapacity, CloseProtocolError:
+		return ReconnectLater
+	case CloseBanned, CloseAuthRevoked, CloseUpgradeRequired:
+		return ReconnectNever
+	default:
+		return ReconnectNow
+	}
+}
+
+// ReasonFromCode returns the reason for a websocket close code.
+func ReasonFromCode(code int) CloseReason {
+	for reason, reasonCode := range closeCodes {
+		if code == reasonCode && reason != CloseHangUp {
+			return reason
+		}
+	}
+
+	switch code {
+	case websocket.CloseNormalClosure, websocket.CloseGoingAway, websocket.CloseAbnormalClosure:
+		return CloseHangUp
+	default:
+		return CloseUnknown
+	}
+}
+
+// ReasonFromError returns the close reason and text if err is a websocket close error.
+func ReasonFromError(err error) (CloseReason, string, bool) {
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) {
+		return "", "", false
+	}
+
+	return ReasonFromCode(closeErr.Code), closeErr.Text, true
+}
+
+// CloseMessage formats a close frame payload for a reason.
+// Long text is truncated at a rune boundary, so the payload stays valid UTF-8.
+func CloseMessage(reason CloseReason, text string) []byte {
+	if len(text) > maxCloseText {
+		cut := maxCloseText
+		for cut > 0 && !utf8.RuneStart(text[cut]) {
+			cut--
+		}
+
+		text = text[:cut]
+	}
+
+	return websocket.FormatCloseMessage(reason.Code(), text)
+}
+
+// CloseWithCode sends a close frame with the reason's code to the peer, and closes the socket.
+// The close frame is best effort; the socket is closed even if it cannot be written.
+func CloseWithCode(sock *websocket.Conn, reason CloseReason, text string) error {
+	_ = sock.WriteControl(websocket.CloseMessage, CloseMessage(reason, text), time.Now().Add(closeTimeout))
+
+	if err := sock.Close(); err != nil {
+		return fmt.Errorf("closing websocket: %w", err)
+	}
+
+	return nil
+}
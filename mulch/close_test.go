@@ -0,0 +1,31 @@
+package mulch
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestCloseMessage(t *testing.T) {
+	t.Parallel()
+
+	for _, text := range []string{
+		"short",
+		strings.Repeat("a", 200),
+		strings.Repeat("a", maxCloseText-1) + "é",  // 2 byte rune across the limit.
+		strings.Repeat("a", maxCloseText-2) + "世界", // 3 byte runes across the limit.
+	} {
+		payload := CloseMessage(CloseDrain, text)
+		if len(payload) > maxCloseText+2 {
+			t.Errorf("%q: payload is %d bytes", text, len(payload))
+		}
+
+		if got := string(payload[2:]); !utf8.ValidString(got) || !strings.HasPrefix(text, got) {
+			t.Errorf("%q: got invalid text %q", text, got)
+		}
+
+		if code := int(payload[0])<<8 | int(payload[1]); code != CodeDrain {
+			t.Errorf("%q: got code %d, want %d", text, code, CodeDrain)
+		}
+	}
+}
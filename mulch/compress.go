@@ -0,0 +1,71 @@
+package mulch
+
+import (
+	"compress/flate"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+// DefaultCompressionLevel is used when compression is enabled without a level.
+const DefaultCompressionLevel = flate.BestSpeed
+
+// CompressionLevel returns a valid websocket compression level.
+// Zero, and anything out of range, returns DefaultCompressionLevel.
+func CompressionLevel(level int) int {
+	if level == 0 || level < flate.HuffmanOnly || level > flate.BestCompression {
+		return DefaultCompressionLevel
+	}
+
+	return level
+}
+
+// incompressibleTypes are media types that are already compressed. Compressing them again wastes CPU.
+//
+//nolint:gochecknoglobals
+var incompressibleTypes = map[string]bool{
+	"application/gzip":             true,
+	"application/octet-stream":     true,
+	"application/pdf":              true,
+	"application/vnd.rar":          true,
+	"application/x-rar-compressed": true,
+	"application/x-7z-compressed":  true,
+	"application/x-bzip2":          true,
+	"application/x-gzip":           true,
+	"application/x-xz":             true,
+	"application/zip":              true,
+	"application/zstd":             true,
+	"font/woff":                    true,
+	"font/woff2":                   true,
+}
+
+// CompressibleBody returns false if a body with these headers is probably already compressed.
+// Bodies with a Content-Encoding, images, audio, video and archives are not worth compressing.
+func CompressibleBody(header http.Header) bool {
+	if encoding := header.Get("Content-Encoding"); encoding != "" && !strings.EqualFold(encoding, "identity") {
+		return false
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	if mediaType == "" {
+		return true
+	}
+
+	if major, _, _ := strings.Cut(mediaType, "/"); major == "image" || major == "audio" || major == "video" {
+		return mediaType == "image/svg+xml" || mediaType == "image/bmp"
+	}
+
+	return !incompressibleTypes[mediaType]
+}
+
+// NextBodyWriter returns a writer for a binary body frame. The frame is compressed only
+// if compress is true and the headers describe a compressible body. Later frames use compress.
+func NextBodyWriter(sock *websocket.Conn, compress bool, header http.Header) (io.WriteCloser, error) {
+	sock.EnableWriteCompression(compress && CompressibleBody(header))
+	defer sock.EnableWriteCompression(compress)
+
+	return sock.NextWriter(websocket.BinaryMessage) //nolint:wrapcheck
+}
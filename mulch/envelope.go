@@ -0,0 +1,141 @@
+package mulch
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gorilla/websocket"
+)
+
+// FrameType says what a Frame carries.
+type FrameType uint8
+
+// Frame types. A request is a FrameRequest followed by one FrameBody. The reply is a FrameResponse followed
+// by one FrameBody, or by FrameBody messages ending with an empty one when the response streams. A client
+// that cannot execute a request replies with one FrameError instead.
+const (
+	FrameRequest  FrameType = iota + 1 // Payload is a JSON HTTPRequest. Sent by the server.
+	FrameResponse                      // Payload is a JSON HTTPResponse. Sent by the client.
+	FrameBody                          // Payload is (part of) a request or response body.
+	FrameError                         // Payload is a JSON ErrorFrame explaining why the request failed. Sent by the client.
+	FrameControl                       // Reserved for pings and cancellation; ignored for now.
+)
+
+// FrameHeaderSize is the size of the type and stream ID that start every framed message.
+const FrameHeaderSize = 5
+
+// ErrUnexpectedFrame is returned when a frame arrives with the wrong type or stream ID.
+var ErrUnexpectedFrame = errors.New("unexpected frame")
+
+// Frame is the envelope for every tunnel message when the client's Handshake has Frames.
+// Each frame is one binary websocket message: a one byte type, a four byte (big endian)
+// stream ID, and the payload. The stream ID ties a reply to its request, so neither side
+// has to infer what a message is from the order it arrived in.
+//
+// Bodies are not buffered into Payload: use NextFrameWriter and ReadFrameHeader to stream them.
+type Frame struct {
+	Type     FrameType
+	StreamID uint32
+	Payload  []byte
+}
+
+// String returns the name of a frame type.
+func (t FrameType) String() string {
+	switch t {
+	case FrameRequest:
+		return "request"
+	case FrameResponse:
+		return "response"
+	case FrameBody:
+		return "body"
+	case FrameError:
+		return "error"
+	case FrameControl:
+		return "control"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(t))
+	}
+}
+
+// MarshalBinary encodes a frame as a websocket message payload.
+func (f *Frame) MarshalBinary() ([]byte, error) {
+	data := make([]byte, FrameHeaderSize, FrameHeaderSize+len(f.Payload))
+	data[0] = byte(f.Type)
+	binary.BigEndian.PutUint32(data[1:], f.StreamID)
+
+	return append(data, f.Payload...), nil
+}
+
+// UnmarshalBinary decodes a websocket message payload into a frame.
+func (f *Frame) UnmarshalBinary(data []byte) error {
+	if len(data) < FrameHeaderSize {
+		return fmt.Errorf("%w: %d bytes is too short", ErrUnexpectedFrame, len(data))
+	}
+
+	f.Type = FrameType(data[0])
+	f.StreamID = binary.BigEndian.Uint32(data[1:])
+	f.Payload = data[FrameHeaderSize:]
+
+	return nil
+}
+
+// WriteFrame sends a frame as one binary message.
+func WriteFrame(sock *websocket.Conn, frame *Frame) error {
+	data, _ := frame.MarshalBinary()
+
+	if err := sock.WriteMessage(websocket.BinaryMessage, data); err != nil {
+		return fmt.Errorf("writing %s frame: %w", frame.Type, err)
+	}
+
+	return nil
+}
+
+// NextFrameWriter returns a writer for a frame's payload, after writing its type and stream ID.
+// Body frames are compressed like NextBodyWriter compresses them.
+func NextFrameWriter(
+	sock *websocket.Conn, compress bool, header http.Header, typ FrameType, streamID uint32,
+) (io.WriteCloser, error) {
+	writer, err := NextBodyWriter(sock, compress, header)
+	if err != nil {
+		return nil, err
+	}
+
+	frame := &Frame{Type: typ, StreamID: streamID}
+	data, _ := frame.MarshalBinary()
+
+	if _, err := writer.Write(data); err != nil {
+		writer.Close()
+		return nil, fmt.Errorf("writing %s frame header: %w", typ, err)
+	}
+
+	return writer, nil
+}
+
+// ReadFrameHeader reads the type and stream ID from the start of a framed message.
+// The rest of the reader is the payload.
+func ReadFrameHeader(reader io.Reader) (FrameType, uint32, error) {
+	var header [FrameHeaderSize]byte
+
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		return 0, 0, fmt.Errorf("%w: reading header: %w", ErrUnexpectedFrame, err)
+	}
+
+	return FrameType(header[0]), binary.BigEndian.Uint32(header[1:]), nil
+}
+
+// ExpectFrame reads a frame header, and returns an error if it is not the wanted type and stream ID.
+func ExpectFrame(reader io.Reader, want FrameType, streamID uint32) error {
+	typ, id, err := ReadFrameHeader(reader)
+	if err != nil {
+		return err
+	}
+
+	if typ != want || id != streamID {
+		return fmt.Errorf("%w: got %s for stream %d, expected %s for stream %d", ErrUnexpectedFrame, typ, id, want, streamID)
+	}
+
+	return nil
+}
@@ -0,0 +1,126 @@
+package mulch
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+)
+
+// ErrorKind says why a client could not execute a request. Servers use it as a metrics label.
+type ErrorKind string
+
+// Error kinds sent in an ErrorFrame.
+const (
+	ErrorDNS      ErrorKind = "dns"      // The backend host name did not resolve.
+	ErrorRefused  ErrorKind = "refused"  // The backend refused the connection.
+	ErrorReset    ErrorKind = "reset"    // The backend closed the connection before it answered.
+	ErrorTimeout  ErrorKind = "timeout"  // The backend did not answer in time.
+	ErrorTLS      ErrorKind = "tls"      // The TLS handshake with the backend failed.
+	ErrorProtocol ErrorKind = "protocol" // The server sent a request the client could not read.
+	ErrorOther    ErrorKind = "other"    // Anything else.
+)
+
+// ErrorKindHeader is set on responses the server writes for an ErrorFrame, so callers can tell
+// a failed backend apart from a backend that answered with the same status code.
+const ErrorKindHeader = "X-Mulery-Error-Kind"
+
+// ErrorFrame is the payload of a FrameError. The client sends it when it cannot execute a request.
+type ErrorFrame struct {
+	// Code is the status code the server should answer with. Zero uses the Kind's Status.
+	Code    int       `json:"code,omitempty"`
+	Kind    ErrorKind `json:"kind"`
+	Message string    `json:"message"`
+}
+
+// NewErrorFrame returns an error frame with the status code for its kind.
+func NewErrorFrame(kind ErrorKind, message string) *ErrorFrame {
+	return &ErrorFrame{Code: kind.Status(), Kind: kind, Message: message}
+}
+
+// ErrorKindOf classifies an error from an http.Client.
+func ErrorKindOf(err error) ErrorKind {
+	var (
+		dnsErr  *net.DNSError
+		netErr  net.Error
+		certErr *tls.CertificateVerificationError
+		hostErr x509.HostnameError
+		authErr x509.UnknownAuthorityError
+		recErr  tls.RecordHeaderError
+	)
+
+	switch {
+	case err == nil:
+		return ErrorOther
+	case errors.As(err, &dnsErr):
+		return ErrorDNS
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return ErrorRefused
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, os.ErrDeadlineExceeded),
+		errors.As(err, &netErr) && netErr.Timeout():
+		return ErrorTimeout
+	case errors.As(err, &certErr), errors.As(err, &hostErr), errors.As(err, &authErr), errors.As(err, &recErr):
+		return ErrorTLS
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE):
+		return ErrorReset
+	default:
+		return ErrorOther
+	}
+}
+
+// Status returns the status code a server answers with for an error kind.
+func (k ErrorKind) Status() int {
+	switch k {
+	case ErrorDNS, ErrorRefused, ErrorReset, ErrorTLS:
+		return http.StatusBadGateway
+	case ErrorTimeout:
+		return http.StatusGatewayTimeout
+	default:
+		return ClientErrorCode
+	}
+}
+
+// Marshal returns the frame's JSON payload.
+func (e *ErrorFrame) Marshal() []byte {
+	data, _ := json.Marshal(e) //nolint:errchkjson // it won't error.
+	return data
+}
+
+// ParseErrorFrame decodes a FrameError payload. A payload that is not JSON is used as the message.
+// The client picks the kind and code, so unknown kinds become ErrorOther, and codes that are not
+// a final HTTP status (200-599) are replaced with the kind's Status.
+func ParseErrorFrame(payload []byte) *ErrorFrame {
+	frame := &ErrorFrame{}
+	if err := json.Unmarshal(payload, frame); err != nil || frame.Kind == "" {
+		return &ErrorFrame{Code: ClientErrorCode, Kind: ErrorOther, Message: string(payload)}
+	}
+
+	if !frame.Kind.known() {
+		frame.Kind = ErrorOther
+	}
+
+	if frame.Code < http.StatusOK || frame.Code > 599 { //nolint:gomnd // the last 5xx status code.
+		frame.Code = frame.Kind.Status()
+	}
+
+	return frame
+}
+
+// known returns true for the error kinds in this package.
+func (k ErrorKind) known() bool {
+	switch k {
+	case ErrorDNS, ErrorRefused, ErrorReset, ErrorTimeout, ErrorTLS, ErrorProtocol, ErrorOther:
+		return true
+	default:
+		return false
+	}
+}
+
+func (e *ErrorFrame) Error() string {
+	return string(e.Kind) + ": " + e.Message
+}
@@ -0,0 +1,32 @@
+package mulch
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestParseErrorFrame(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		payload string
+		code    int
+		kind    ErrorKind
+	}{
+		{payload: `{"code":504,"kind":"timeout","message":"m"}`, code: http.StatusGatewayTimeout, kind: ErrorTimeout},
+		{payload: `{"code":503,"kind":"refused","message":"m"}`, code: http.StatusServiceUnavailable, kind: ErrorRefused},
+		{payload: `{"kind":"dns","message":"m"}`, code: http.StatusBadGateway, kind: ErrorDNS},
+		{payload: `{"code":101,"kind":"reset","message":"m"}`, code: http.StatusBadGateway, kind: ErrorReset},
+		{payload: `{"code":999,"kind":"timeout","message":"m"}`, code: http.StatusGatewayTimeout, kind: ErrorTimeout},
+		{payload: `{"code":502,"kind":"made-up-123","message":"m"}`, code: http.StatusBadGateway, kind: ErrorOther},
+		{payload: `{"kind":"made-up-456","message":"m"}`, code: ClientErrorCode, kind: ErrorOther},
+		{payload: `not json`, code: ClientErrorCode, kind: ErrorOther},
+	}
+
+	for _, test := range tests {
+		frame := ParseErrorFrame([]byte(test.payload))
+		if frame.Code != test.code || frame.Kind != test.kind {
+			t.Errorf("%s: got %d %s, want %d %s", test.payload, frame.Code, frame.Kind, test.code, test.kind)
+		}
+	}
+}
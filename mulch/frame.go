@@ -0,0 +1,50 @@
+package mulch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// DefaultMaxFrameSize is the largest serialized request or response header frame we decode.
+// Headers are small; anything bigger than this is broken or hostile.
+const DefaultMaxFrameSize = 1024 * 1024
+
+// ErrFrameTooLarge is returned when a header frame is larger than the maximum size.
+var ErrFrameTooLarge = errors.New("header frame too large")
+
+// DecodeFrame decodes a JSON header frame from reader into v, without reading it all into memory first.
+// Returns ErrFrameTooLarge if the frame is larger than maxSize. A zero maxSize uses DefaultMaxFrameSize.
+func DecodeFrame(reader io.Reader, maxSize int64, v any) error {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxFrameSize
+	}
+
+	if err := json.NewDecoder(&frameReader{Reader: reader, left: maxSize}).Decode(v); err != nil {
+		return fmt.Errorf("decoding frame: %w", err)
+	}
+
+	return nil
+}
+
+// frameReader works like io.LimitedReader, but returns an error when the limit is reached.
+type frameReader struct {
+	io.Reader
+	left int64
+}
+
+func (f *frameReader) Read(p []byte) (int, error) {
+	if f.left <= 0 {
+		return 0, ErrFrameTooLarge
+	}
+
+	if int64(len(p)) > f.left {
+		p = p[:f.left]
+	}
+
+	n, err := f.Reader.Read(p)
+	f.left -= int64(n)
+
+	return n, err //nolint:wrapcheck
+}
@@ -0,0 +1,23 @@
+package mulch
+
+// Health payloads. Clients send these in websocket ping frames to report the health of their backend.
+// A ping without one of these payloads (a plain keep-alive) does not change the health.
+const (
+	HealthOK       = "health:ok"
+	HealthDegraded = "health:degraded"
+)
+
+// HealthPayload returns the ping payload for a health state.
+func HealthPayload(healthy bool) string {
+	if healthy {
+		return HealthOK
+	}
+
+	return HealthDegraded
+}
+
+// DrainPayload is the ping payload a client sends to retire an idle connection. The server closes the
+// connection with CloseDrain if it is idle. If the server already sent a request on it, it answers with a
+// pong carrying this payload, and the client serves the request and keeps the connection. Older servers
+// answer every ping with a pong, so their connections are kept too.
+const DrainPayload = "drain"
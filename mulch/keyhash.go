@@ -0,0 +1,67 @@
+package mulch
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+)
+
+// KeyHasher creates a pool ID from the secret a key validator returned and a client ID.
+// Provide one to the client and server to keep pool IDs compatible with other systems.
+// The client and server must use the same hasher, or the IDs they log will not match.
+type KeyHasher interface {
+	HashKeyID(secret string, clientID string) string
+}
+
+// KeyHasherFunc adapts a function to a KeyHasher.
+type KeyHasherFunc func(secret string, clientID string) string
+
+// HashKeyID calls the function.
+func (f KeyHasherFunc) HashKeyID(secret string, clientID string) string {
+	return f(secret, clientID)
+}
+
+// SHA256Hasher is the default KeyHasher: the hex sha256 of the secret followed by the client ID.
+type SHA256Hasher struct{}
+
+// HashKeyID returns the hex sha256 of the secret and client ID.
+func (SHA256Hasher) HashKeyID(secret string, clientID string) string {
+	hash := sha256.New()
+	hash.Write([]byte(secret + clientID))
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// HMACHasher is a KeyHasher that returns the hex HMAC of the client ID, keyed with the secret.
+type HMACHasher struct {
+	// Hash creates the hash used in the HMAC. Defaults to sha256.New.
+	Hash func() hash.Hash
+}
+
+// HashKeyID returns the hex HMAC of the client ID, keyed with the secret.
+func (h HMACHasher) HashKeyID(secret string, clientID string) string {
+	newHash := h.Hash
+	if newHash == nil {
+		newHash = sha256.New
+	}
+
+	mac := hmac.New(newHash, []byte(secret))
+	mac.Write([]byte(clientID))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// HashKeyIDWith creates a pool ID with a custom hasher. A nil hasher works like HashKeyID.
+// The client ID is returned unchanged when the secret is empty, whatever the hasher.
+func HashKeyIDWith(hasher KeyHasher, secret string, clientID string) string {
+	if secret == "" {
+		return clientID
+	}
+
+	if hasher == nil {
+		hasher = SHA256Hasher{}
+	}
+
+	return hasher.HashKeyID(secret, clientID)
+}
@@ -2,9 +2,21 @@ package mulch
 
 import "log"
 
+// Level controls which messages the DefaultLogger writes.
+type Level int
+
+// Log levels, from most to least verbose.
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
 // Logger is the log-output input interface for this package.
 // Provide your own log interface, or use the DefaultLogger to simply wrap the log package.
 // Leaving the interface nil disables all log output.
+// See NewAdapter to use a zap or logrus logger, and SlogLogger to use a log/slog logger.
 type Logger interface {
 	// Debugf is used sparingly.
 	Debugf(format string, v ...interface{})
@@ -12,17 +24,24 @@ type Logger interface {
 	Errorf(format string, v ...interface{})
 	// Printf is only used when a custom Handler is not provided.
 	Printf(format string, v ...interface{})
+	// Warnf is used for unexpected conditions that are not errors.
+	Warnf(format string, v ...interface{})
+	// IsDebug returns true if Debugf writes output.
+	// Hot paths check this to avoid formatting debug messages that are discarded.
+	IsDebug() bool
 }
 
 // DefaultLogger is a simple wrapper around the provided Logger interface.
 // Use this if you only need simple log output.
 type DefaultLogger struct {
 	Silent bool
+	// Level is the minimum level of messages to write. Default is LevelDebug.
+	Level Level
 }
 
 // Debugf prints a message with DEBUG prefixed.
 func (l *DefaultLogger) Debugf(format string, v ...interface{}) {
-	if !l.Silent {
+	if l.IsDebug() {
 		log.Printf("[DEBUG] "+format, v...)
 	}
 }
@@ -36,10 +55,22 @@ func (l *DefaultLogger) Errorf(format string, v ...interface{}) {
 
 // Printf prints a message with INFO prefixed.
 func (l *DefaultLogger) Printf(format string, v ...interface{}) {
-	if !l.Silent {
+	if !l.Silent && l.Level <= LevelInfo {
 		log.Printf("[INFO] "+format, v...)
 	}
 }
 
+// Warnf prints a message with WARN prefixed.
+func (l *DefaultLogger) Warnf(format string, v ...interface{}) {
+	if !l.Silent && l.Level <= LevelWarn {
+		log.Printf("[WARN] "+format, v...)
+	}
+}
+
+// IsDebug returns true if debug messages are written.
+func (l *DefaultLogger) IsDebug() bool {
+	return !l.Silent && l.Level <= LevelDebug
+}
+
 // Validate DefaultLogger struct satisfies the Logger interface.
 var _ = Logger(&DefaultLogger{})
@@ -3,13 +3,15 @@
 package mulch
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
 	"time"
 )
 
 const SecretKeyHeader = "x-secret-key"
 
+// TimeoutHeader may be set by an upstream caller to limit how long a tunneled request may take.
+// The value is a Go duration (like 30s) or a number of seconds.
+const TimeoutHeader = "x-mulery-timeout"
+
 type Handshake struct {
 	Size     int    `json:"size"`     // idle connections.
 	MaxSize  int    `json:"max"`      // buffer pool size.
@@ -18,6 +20,18 @@ type Handshake struct {
 	Compress string `json:"compress"` // gzip, bzip, etc, not used yet.
 	// ClientIDs is for you to identify your clients with your own ID(s).
 	ClientIDs []interface{} `json:"clientIds"`
+	// Metadata tags the client with your own labels, like a site name, customer ID or capability.
+	// The server saves these with the client's pool, and shows them in stats.
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// Frames is true if the client reads and writes Frame envelopes. Servers that support them send
+	// framed requests on this connection; older servers ignore it, and the client answers them unframed.
+	Frames bool `json:"frames,omitempty"`
+	// Version is the client's build, and GoVersion, OS and Arch describe its platform.
+	// The client fills these in. They are shown in stats and logs only.
+	Version   string `json:"version,omitempty"`
+	GoVersion string `json:"goVersion,omitempty"`
+	OS        string `json:"os,omitempty"`
+	Arch      string `json:"arch,omitempty"`
 }
 
 const HandshakeTimeout = 15 * time.Second
@@ -27,13 +41,7 @@ const HandshakeTimeout = 15 * time.Second
 // hash that with the client id to create a new client id.
 // This is custom logic you probably don't need, and you can
 // avoid it by returning an empty string from the custom key validator.
+// Use HashKeyIDWith to create the hash another way.
 func HashKeyID(secret string, clientID string) string {
-	if secret == "" {
-		return clientID
-	}
-
-	hash := sha256.New()
-	hash.Write([]byte(secret + clientID))
-
-	return hex.EncodeToString(hash.Sum(nil))
+	return HashKeyIDWith(nil, secret, clientID)
 }
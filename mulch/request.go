@@ -1,8 +1,11 @@
 package mulch
 
 import (
+	"context"
 	"net/http"
 	"net/url"
+	"strconv"
+	"time"
 )
 
 // HTTPRequest is a serializable version of http.Request (with only useful fields).
@@ -15,6 +18,17 @@ type HTTPRequest struct {
 	Host          string              `json:"host"`
 	Proto         string              `json:"proto"`
 	RequestURI    string              `json:"requestUri"`
+	// Trace contains trace propagation fields (like traceparent) from the server.
+	Trace map[string]string `json:"trace,omitempty"`
+	// Timeout is how much time was left before the server gives up on this request.
+	// The client applies it to the backend request. It is a duration, and not a deadline, to avoid clock skew.
+	Timeout time.Duration `json:"timeout,omitempty"`
+	// AcceptStream is true if the server reads streamed response bodies. See HTTPResponse.Stream.
+	AcceptStream bool `json:"acceptStream,omitempty"`
+	// RequestID identifies the request in server and client logs. It is also in the RequestIDHeader.
+	RequestID string `json:"requestId,omitempty"`
+	// TLS describes the upstream request's TLS connection, if the server terminated it. See RequestTLS.
+	TLS *TLSInfo `json:"tls,omitempty"`
 }
 
 // SerializeHTTPRequest create a new HTTPRequest from a http.Request.
@@ -28,14 +42,61 @@ func SerializeHTTPRequest(req *http.Request) *HTTPRequest {
 		Host:          req.Host,
 		Proto:         req.Proto,
 		RequestURI:    req.RequestURI,
+		Timeout:       timeLeft(req.Context()),
+		RequestID:     req.Header.Get(RequestIDHeader),
+		TLS:           NewTLSInfo(req.TLS),
 	}
 }
 
+// timeLeft returns the time left before the context deadline, or 0 if there is no deadline.
+func timeLeft(ctx context.Context) time.Duration {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return 0
+	}
+
+	if left := time.Until(deadline); left > 0 {
+		return left
+	}
+
+	return time.Nanosecond // already expired, but 0 means no timeout.
+}
+
+// ParseTimeout parses the value of a TimeoutHeader. Returns 0 if the value is empty or invalid.
+func ParseTimeout(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds > 0 {
+		return time.Duration(seconds * float64(time.Second))
+	}
+
+	if timeout, err := time.ParseDuration(value); err == nil && timeout > 0 {
+		return timeout
+	}
+
+	return 0
+}
+
+// WithTimeout returns a request with a context that expires after timeout.
+// The returned cancel func must be called. If timeout is 0 the request is returned unchanged.
+func WithTimeout(req *http.Request, timeout time.Duration) (*http.Request, context.CancelFunc) {
+	if timeout <= 0 {
+		return req, func() {}
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), timeout)
+
+	return req.WithContext(ctx), cancel
+}
+
 // UnserializeHTTPRequest create a new http.Request from a HTTPRequest.
+// The request's Timeout is not applied here; use WithTimeout. Its TLS details are in RequestTLS.
 func UnserializeHTTPRequest(req *HTTPRequest) *http.Request {
 	url, _ := url.Parse(req.URL)
 
-	return &http.Request{
+	return (&http.Request{
 		Method:        req.Method,
 		Header:        req.Header,
 		ContentLength: req.ContentLength,
@@ -44,5 +105,5 @@ func UnserializeHTTPRequest(req *HTTPRequest) *http.Request {
 		Host:          req.Host,
 		Proto:         req.Proto,
 		RequestURI:    req.RequestURI,
-	}
+	}).WithContext(withTLSInfo(context.Background(), req.TLS))
 }
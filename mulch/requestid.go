@@ -0,0 +1,46 @@
+package mulch
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+// RequestIDHeader carries a request's ID. The server honors one sent by the upstream caller, sends it to
+// the backend, and echoes it in the response, so a request can be found in every log it passed through.
+const RequestIDHeader = "X-Request-Id"
+
+// maxRequestID is the longest request ID accepted from an upstream caller.
+const maxRequestID = 128
+
+// RequestID returns the request ID in a header, or a new one if it is missing or invalid.
+func RequestID(header http.Header) string {
+	if id := header.Get(RequestIDHeader); validRequestID(id) {
+		return id
+	}
+
+	return NewRequestID()
+}
+
+// NewRequestID returns a random request ID.
+func NewRequestID() string {
+	id := make([]byte, 12) //nolint:gomnd
+	_, _ = rand.Read(id)
+
+	return hex.EncodeToString(id)
+}
+
+// validRequestID returns true if an ID is safe to log and send back in a header.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestID {
+		return false
+	}
+
+	for _, char := range []byte(id) {
+		if char <= ' ' || char >= 0x7f { //nolint:gomnd // printable ascii.
+			return false
+		}
+	}
+
+	return true
+}
@@ -3,6 +3,7 @@ package mulch
 import (
 	"encoding/json"
 	"net/http"
+	"strconv"
 )
 
 // HTTPResponse is a serializable version of http.Response (with only useful fields).
@@ -10,6 +11,20 @@ type HTTPResponse struct {
 	StatusCode    int         `json:"statusCode"`
 	Header        http.Header `json:"header"`
 	ContentLength int64       `json:"contentLength"`
+	// Stream is true if the body is sent in one or more binary messages, and ends with an empty message.
+	// The server flushes the response to the http client after every message, so handlers may stream
+	// (server-sent events, progress output). Otherwise, the body is exactly one binary message.
+	// Clients only stream when the request has AcceptStream, so older servers are not confused.
+	Stream bool `json:"stream,omitempty"`
+	// Status is the backend's status line, like "200 OK", and Proto is its protocol, like HTTP/1.1.
+	// net/http servers always write their own reason phrase, so these are for Go callers and logs.
+	Status string `json:"status,omitempty"`
+	Proto  string `json:"proto,omitempty"`
+	// TransferEncoding is the backend's transfer encoding, like chunked. Empty is identity.
+	TransferEncoding []string `json:"transferEncoding,omitempty"`
+	// KnownLength is true if ContentLength is the exact body size, so the server sends a Content-Length
+	// instead of a chunked body. Older clients do not set this, and send 0 when the size is unknown.
+	KnownLength bool `json:"knownLength,omitempty"`
 }
 
 // Custom HTTP error codes shared by client and server.
@@ -19,22 +34,56 @@ const (
 )
 
 // SerializeHTTPResponse create a new HTTPResponse json blob from a http.Response.
+// Set ContentLength to -1 if the body size is not known yet.
 func SerializeHTTPResponse(resp *http.Response) []byte {
-	jsonResponse, _ := json.Marshal(&HTTPResponse{ //nolint:errchkjson // it won't error.
-		StatusCode:    resp.StatusCode,
-		Header:        resp.Header,
-		ContentLength: resp.ContentLength,
-	})
+	jsonResponse, _ := json.Marshal(newHTTPResponse(resp)) //nolint:errchkjson // it won't error.
+	return jsonResponse
+}
+
+// SerializeStreamResponse is SerializeHTTPResponse for a response with a streamed body.
+func SerializeStreamResponse(resp *http.Response) []byte {
+	httpResponse := newHTTPResponse(resp)
+	httpResponse.Stream = true
+	httpResponse.KnownLength = false // Streamed bodies are flushed in chunks.
+
+	jsonResponse, _ := json.Marshal(httpResponse) //nolint:errchkjson // it won't error.
 
 	return jsonResponse
 }
 
+func newHTTPResponse(resp *http.Response) *HTTPResponse {
+	return &HTTPResponse{
+		StatusCode:       resp.StatusCode,
+		Header:           resp.Header,
+		ContentLength:    resp.ContentLength,
+		Status:           resp.Status,
+		Proto:            resp.Proto,
+		TransferEncoding: resp.TransferEncoding,
+		KnownLength:      resp.ContentLength >= 0 && len(resp.TransferEncoding) == 0,
+	}
+}
+
+// StatusLine returns the status line, like "404 Not Found". Responses from older clients do not have Status.
+func (r *HTTPResponse) StatusLine() string {
+	if r.Status != "" {
+		return r.Status
+	}
+
+	return strconv.Itoa(r.StatusCode) + " " + http.StatusText(r.StatusCode)
+}
+
+// BodyAllowed returns false for status codes that never have a body.
+func (r *HTTPResponse) BodyAllowed() bool {
+	return r.StatusCode >= http.StatusOK && r.StatusCode != http.StatusNoContent && r.StatusCode != http.StatusNotModified
+}
+
 // NewHTTPResponse creates a new HTTPResponse.
 func NewHTTPResponse(code int, size int64) []byte {
 	jsonResponse, _ := json.Marshal(&HTTPResponse{ //nolint:errchkjson // it won't error.
 		Header:        make(http.Header),
 		StatusCode:    code,
 		ContentLength: size,
+		KnownLength:   size >= 0,
 	})
 
 	return jsonResponse
@@ -0,0 +1,76 @@
+package mulch
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+)
+
+// FieldLogger is an optional interface for loggers that support structured fields.
+// The server attaches pool and client identifiers to loggers that implement it.
+type FieldLogger interface {
+	Logger
+	// With returns a logger that includes the provided key/value pairs in every message.
+	With(args ...any) Logger
+}
+
+// With attaches key/value pairs to a logger if it is a FieldLogger.
+// Other loggers are returned unchanged.
+func With(logger Logger, args ...any) Logger {
+	if fields, ok := logger.(FieldLogger); ok {
+		return fields.With(args...)
+	}
+
+	return logger
+}
+
+// SlogLogger wraps a structured logger from the log/slog package.
+// Use this to emit logs as JSON, or in any other format slog supports.
+type SlogLogger struct {
+	*slog.Logger
+}
+
+// NewJSONLogger returns a logger that writes JSON lines to output.
+// Debug messages are only written if debug is true.
+func NewJSONLogger(output io.Writer, debug bool) *SlogLogger {
+	level := slog.LevelInfo
+	if debug {
+		level = slog.LevelDebug
+	}
+
+	return &SlogLogger{Logger: slog.New(slog.NewJSONHandler(output, &slog.HandlerOptions{Level: level}))}
+}
+
+// Debugf writes a message at the DEBUG level.
+func (l *SlogLogger) Debugf(format string, v ...interface{}) {
+	l.Logger.Debug(fmt.Sprintf(format, v...))
+}
+
+// Errorf writes a message at the ERROR level.
+func (l *SlogLogger) Errorf(format string, v ...interface{}) {
+	l.Logger.Error(fmt.Sprintf(format, v...))
+}
+
+// Printf writes a message at the INFO level.
+func (l *SlogLogger) Printf(format string, v ...interface{}) {
+	l.Logger.Info(fmt.Sprintf(format, v...))
+}
+
+// Warnf writes a message at the WARN level.
+func (l *SlogLogger) Warnf(format string, v ...interface{}) {
+	l.Logger.Warn(fmt.Sprintf(format, v...))
+}
+
+// IsDebug returns true if the handler writes messages at the DEBUG level.
+func (l *SlogLogger) IsDebug() bool {
+	return l.Logger.Enabled(context.Background(), slog.LevelDebug)
+}
+
+// With returns a logger that includes the provided key/value pairs in every message.
+func (l *SlogLogger) With(args ...any) Logger {
+	return &SlogLogger{Logger: l.Logger.With(args...)}
+}
+
+// Validate SlogLogger struct satisfies the FieldLogger interface.
+var _ = FieldLogger(&SlogLogger{})
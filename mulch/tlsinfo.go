@@ -0,0 +1,110 @@
+package mulch
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"net/http"
+	"time"
+)
+
+// TLSInfo describes the TLS connection an upstream request arrived on, when the server terminated it.
+// Handlers on the client get it from RequestTLS, and may use the caller's certificate for authorization.
+type TLSInfo struct {
+	Version            string `json:"version"`
+	CipherSuite        string `json:"cipherSuite"`
+	ServerName         string `json:"serverName,omitempty"`
+	NegotiatedProtocol string `json:"negotiatedProtocol,omitempty"`
+	// PeerCertificates is the caller's certificate chain, leaf first. Empty without mutual TLS.
+	// The server verified the chain if its tls.Config requires it; the client cannot check it again.
+	PeerCertificates []*CertInfo `json:"peerCertificates,omitempty"`
+}
+
+// CertInfo describes a certificate presented by an upstream caller.
+type CertInfo struct {
+	Subject        string    `json:"subject"`
+	Issuer         string    `json:"issuer"`
+	SerialNumber   string    `json:"serialNumber"`
+	NotBefore      time.Time `json:"notBefore"`
+	NotAfter       time.Time `json:"notAfter"`
+	DNSNames       []string  `json:"dnsNames,omitempty"`
+	EmailAddresses []string  `json:"emailAddresses,omitempty"`
+	IPAddresses    []string  `json:"ipAddresses,omitempty"`
+	URIs           []string  `json:"uris,omitempty"`
+	// SHA256 is the hex fingerprint of the whole certificate.
+	SHA256 string `json:"sha256"`
+}
+
+// tlsInfoKey is the context key for a request's TLSInfo.
+type tlsInfoKey struct{}
+
+// NewTLSInfo returns the details of a TLS connection, or nil if there is no connection state.
+func NewTLSInfo(state *tls.ConnectionState) *TLSInfo {
+	if state == nil {
+		return nil
+	}
+
+	info := &TLSInfo{
+		Version:            tls.VersionName(state.Version),
+		CipherSuite:        tls.CipherSuiteName(state.CipherSuite),
+		ServerName:         state.ServerName,
+		NegotiatedProtocol: state.NegotiatedProtocol,
+	}
+
+	for _, cert := range state.PeerCertificates {
+		info.PeerCertificates = append(info.PeerCertificates, newCertInfo(cert))
+	}
+
+	return info
+}
+
+func newCertInfo(cert *x509.Certificate) *CertInfo {
+	sum := sha256.Sum256(cert.Raw)
+	info := &CertInfo{
+		Subject:        cert.Subject.String(),
+		Issuer:         cert.Issuer.String(),
+		SerialNumber:   cert.SerialNumber.String(),
+		NotBefore:      cert.NotBefore,
+		NotAfter:       cert.NotAfter,
+		DNSNames:       cert.DNSNames,
+		EmailAddresses: cert.EmailAddresses,
+		SHA256:         hex.EncodeToString(sum[:]),
+	}
+
+	for _, ip := range cert.IPAddresses {
+		info.IPAddresses = append(info.IPAddresses, ip.String())
+	}
+
+	for _, uri := range cert.URIs {
+		info.URIs = append(info.URIs, uri.String())
+	}
+
+	return info
+}
+
+// Leaf returns the caller's certificate, or nil if the caller did not present one.
+func (t *TLSInfo) Leaf() *CertInfo {
+	if t == nil || len(t.PeerCertificates) == 0 {
+		return nil
+	}
+
+	return t.PeerCertificates[0]
+}
+
+// RequestTLS returns the TLS details of the upstream request a tunneled request came from.
+// Returns nil if the request did not arrive over TLS, or the server did not terminate it.
+func RequestTLS(req *http.Request) *TLSInfo {
+	info, _ := req.Context().Value(tlsInfoKey{}).(*TLSInfo)
+	return info
+}
+
+// withTLSInfo attaches TLS details to a request's context, for RequestTLS.
+func withTLSInfo(ctx context.Context, info *TLSInfo) context.Context {
+	if info == nil {
+		return ctx
+	}
+
+	return context.WithValue(ctx, tlsInfoKey{}, info)
+}
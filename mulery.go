@@ -8,15 +8,14 @@ import (
 	"crypto/tls"
 	"errors"
 	"fmt"
-	"io"
 	"log"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
-	"github.com/caddyserver/certmagic"
 	apachelog "github.com/lestrrat-go/apache-logformat/v2"
-	"github.com/libdns/cloudflare"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"golift.io/cnfgfile"
 	"golift.io/mulery/mulch"
 	"golift.io/mulery/server"
@@ -24,19 +23,62 @@ import (
 
 // Config is the input data to run this app. Read from a config file.
 type Config struct {
+	// ListenAddr serves every handler, with TLS if it is configured. May be empty if Listeners are provided.
 	ListenAddr string `json:"listenAddr" toml:"listen_addr" yaml:"listenAddr" xml:"listen_addr"`
-	AuthURL    string `json:"authUrl" toml:"auth_url" yaml:"authUrl" xml:"auth_url"`
-	AuthHeader string `json:"authHeader" toml:"auth_header" yaml:"authHeader" xml:"auth_header"`
+	// Listeners are additional addresses to listen on, each with its own handlers.
+	Listeners []*Listener `json:"listeners" toml:"listener" yaml:"listeners" xml:"listener"`
+	// Paths moves handler sets to other paths, like request = "/tunnel". Use "-" to disable a handler set.
+	// The keys are handler sets: register, request, stats, state, metrics, health, peer, version.
+	Paths      map[string]string `json:"paths" toml:"paths" yaml:"paths" xml:"paths"`
+	AuthURL    string            `json:"authUrl" toml:"auth_url" yaml:"authUrl" xml:"auth_url"`
+	AuthHeader string            `json:"authHeader" toml:"auth_header" yaml:"authHeader" xml:"auth_header"`
+	// AuthURLs are more auth proxies, tried in order when AuthURL (and the ones before them) fail.
+	AuthURLs StringSlice `json:"authUrls" toml:"auth_urls" yaml:"authUrls" xml:"auth_urls"`
+	// AuthTimeout is how long to wait for each auth proxy. Default is 5 seconds.
+	AuthTimeout time.Duration `json:"authTimeout" toml:"auth_timeout" yaml:"authTimeout" xml:"auth_timeout"`
+	// AuthBackoff is how long a failed auth proxy is skipped. It doubles with each failure, up to 5 minutes.
+	AuthBackoff time.Duration `json:"authBackoff" toml:"auth_backoff" yaml:"authBackoff" xml:"auth_backoff"`
+	// AuthExpiresHeader is an optional auth proxy response header that contains the key's
+	// expiration as unix seconds or RFC3339. Connections are closed after their key expires.
+	AuthExpiresHeader string `json:"authExpiresHeader" toml:"auth_expires_header" yaml:"authExpiresHeader" xml:"auth_expires_header"`
+	// KeyCacheTTL caches valid keys, so reconnecting clients do not hit the auth proxy every time. 0 disables it.
+	KeyCacheTTL time.Duration `json:"keyCacheTtl" toml:"key_cache_ttl" yaml:"keyCacheTtl" xml:"key_cache_ttl"`
+	// KeyCacheNegativeTTL caches keys the auth proxy rejected. 0 disables it.
+	KeyCacheNegativeTTL time.Duration `json:"keyCacheNegativeTtl" toml:"key_cache_negative_ttl" yaml:"keyCacheNegativeTtl" xml:"key_cache_negative_ttl"`
+	// KeyCacheRedis is a URL like redis://:password@host:6379/0 to share the key cache between mulery servers.
+	// Connection pool options may be added to the query string, like ?pool_size=20&min_idle_conns=2.
+	KeyCacheRedis string `json:"keyCacheRedis" toml:"key_cache_redis" yaml:"keyCacheRedis" xml:"key_cache_redis"`
 	// Providing a header=>name map here will put these request headers into the apache log output.
 	LogHeaders map[string]string `json:"logHeaders" toml:"log_headers" yaml:"logHeaders" xml:"log_headers"`
 	// List of IPs or CIDRs that are allowed to make requests to clients.
 	Upstreams []string `json:"upstreams" toml:"upstreams" yaml:"upstreams" xml:"upstreams"`
+	// MonitorTokens are bearer tokens that allow access to /stats, /state, /metrics and /health checks from any IP.
+	MonitorTokens StringSlice `json:"monitorTokens" toml:"monitor_tokens" yaml:"monitorTokens" xml:"monitor_tokens"`
+	// CORSOrigins are browser origins allowed to read /stats, /state, /metrics and /health. Use * for any origin.
+	CORSOrigins StringSlice `json:"corsOrigins" toml:"cors_origins" yaml:"corsOrigins" xml:"cors_origins"`
+	// CORSMethods are the methods allowed in CORS preflight responses. Default is GET and OPTIONS.
+	CORSMethods StringSlice `json:"corsMethods" toml:"cors_methods" yaml:"corsMethods" xml:"cors_methods"`
+	// TrustedProxies are IPs, CIDRs or hostnames of load balancers in front of mulery.
+	// Requests from these use the client IP in X-Forwarded-For or X-Real-IP for allow lists and logs.
+	TrustedProxies []string `json:"trustedProxies" toml:"trusted_proxies" yaml:"trustedProxies" xml:"trusted_proxies"`
 	// Optional directory where SSL certificates are stored.
 	CacheDir string `json:"cacheDir" toml:"cache_dir" yaml:"cacheDir" xml:"cache_dir"`
-	// CFToken is used to create DNS entries to validate SSL certs for acme.
+	// ACMEChallenge selects how acme validates SSL certs: dns (default), http or tls-alpn.
+	ACMEChallenge string `json:"acmeChallenge" toml:"acme_challenge" yaml:"acmeChallenge" xml:"acme_challenge"`
+	// CFToken is used to create DNS entries to validate SSL certs for acme with dns challenges.
 	CFToken string `json:"cfToken" toml:"cf_token"  yaml:"cfToken" xml:"cf_token"`
 	// Email is used for acme certificate registration.
 	Email string `json:"email" toml:"email" yaml:"email" xml:"email"`
+	// SSLCertFile and SSLKeyFile are a static certificate to use instead of acme.
+	// They are reloaded when they change, and on SIGHUP.
+	SSLCertFile string `json:"sslCertFile" toml:"ssl_cert_file" yaml:"sslCertFile" xml:"ssl_cert_file"`
+	SSLKeyFile  string `json:"sslKeyFile" toml:"ssl_key_file" yaml:"sslKeyFile" xml:"ssl_key_file"`
+	// TLSMinVersion is the minimum TLS version for the TLS listeners: 1.0, 1.1, 1.2 or 1.3. Default is 1.2.
+	TLSMinVersion string `json:"tlsMinVersion" toml:"tls_min_version" yaml:"tlsMinVersion" xml:"tls_min_version"`
+	// TLSCiphers limits the TLS 1.2 (and older) cipher suites, by Go name, like TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256.
+	TLSCiphers StringSlice `json:"tlsCiphers" toml:"tls_ciphers" yaml:"tlsCiphers" xml:"tls_ciphers"`
+	// TLSALPN sets the ALPN protocols the TLS listeners offer, like h2 and http/1.1.
+	TLSALPN StringSlice `json:"tlsAlpn" toml:"tls_alpn" yaml:"tlsAlpn" xml:"tls_alpn"`
 	// DNS Names that we are allowed to create SSL certificates for.
 	SSLNames StringSlice `json:"sslNames" toml:"ssl_names" yaml:"sslNames" xml:"ssl_names"`
 	// Path to app log file.
@@ -45,21 +87,77 @@ type Config struct {
 	LogFiles int `json:"logFiles" toml:"log_files" yaml:"logFiles" xml:"log_files"`
 	// Rotate the log file when it reaches this many megabytes.
 	LogFileMB int64 `json:"logFileMb" toml:"log_file_mb" yaml:"logFileMb" xml:"log_file_mb"`
+	// Debug enables debug log lines. These are very noisy, and written for every request.
+	Debug bool `json:"debug" toml:"debug" yaml:"debug" xml:"debug"`
+	// LogFormat may be set to "json" to write structured app logs. Default is "text".
+	LogFormat string `json:"logFormat" toml:"log_format" yaml:"logFormat" xml:"log_format"`
 	// Path for http log.
 	HTTPLog string `json:"httpLog" toml:"http_log" yaml:"httpLog" xml:"http_log"`
 	// Number of http log files to keep when rotating.
 	HTTPLogs int `json:"httpLogs" toml:"http_logs" yaml:"httpLogs" xml:"http_logs"`
 	// Rotate the http log file when it reaches this many megabytes.
 	HTTPLogMB int64 `json:"httpLogMb" toml:"http_log_mb" yaml:"httpLogMb" xml:"http_log_mb"`
+	// Path to the append-only audit log. Records registrations, disconnects, key failures and admin actions.
+	AuditLog string `json:"auditLog" toml:"audit_log" yaml:"auditLog" xml:"audit_log"`
+	// Number of audit log files to keep when rotating.
+	AuditLogs int `json:"auditLogs" toml:"audit_logs" yaml:"auditLogs" xml:"audit_logs"`
+	// Rotate the audit log file when it reaches this many megabytes.
+	AuditLogMB int64 `json:"auditLogMb" toml:"audit_log_mb" yaml:"auditLogMb" xml:"audit_log_mb"`
 	// RedirectURL is where to send a request to any unknown path. Unauthorized is returned otherwise.
 	RedirectURL string `json:"redirectUrl" toml:"redirect_url" yaml:"redirectUrl" xml:"redirect_url"`
+	// RedirectHTTP is an address (like :80) to listen on for plain HTTP when SSL is configured.
+	// It answers ACME HTTP-01 challenges (with acme), and redirects everything else to the TLS listener.
+	RedirectHTTP string `json:"redirectHttp" toml:"redirect_http" yaml:"redirectHttp" xml:"redirect_http"`
+	// Vhosts maps hostnames to client IDs. Requests with a matching Host header are sent to that client.
+	// This requires id_header, and these requests skip the upstreams allow list.
+	Vhosts map[string]string `json:"vhosts" toml:"vhosts" yaml:"vhosts" xml:"vhosts"`
+	// VhostWildcard is a pattern like *.tunnel.example.com. The label in place of the * is looked up in
+	// VhostLabels to find the client ID. Pool IDs are 64 character hashes of the client's key, and a DNS
+	// label holds 63 characters, so labels are never used as client IDs directly.
+	VhostWildcard string `json:"vhostWildcard" toml:"vhost_wildcard" yaml:"vhostWildcard" xml:"vhost_wildcard"`
+	// VhostLabels maps the labels in VhostWildcard hostnames to client IDs. Unlisted labels are not routed.
+	VhostLabels map[string]string `json:"vhostLabels" toml:"vhost_labels" yaml:"vhostLabels" xml:"vhost_labels"`
+	// PathLabels control how request paths are bucketed into handler labels in request metrics.
+	// If none are provided, a set of rules built for notifiarr is used.
+	PathLabels []*PathLabel `json:"pathLabels" toml:"path_label" yaml:"pathLabels" xml:"path_label"`
+	// Peers are base URLs of other mulery servers, like https://mulery2.example.com:5555.
+	// Requests for clients that are not connected here are sent to the peer they are connected to.
+	// Every peer must use the same peer_token, and the same path for the peer handler.
+	Peers StringSlice `json:"peers" toml:"peers" yaml:"peers" xml:"peers"`
+	// PeerToken authenticates peers to each other. Peer handlers are disabled without it.
+	PeerToken string `json:"peerToken" toml:"peer_token" yaml:"peerToken" xml:"peer_token"`
+	// PeerInterval is how often peers are asked for their client lists. Default is 10 seconds.
+	PeerInterval time.Duration `json:"peerInterval" toml:"peer_interval" yaml:"peerInterval" xml:"peer_interval"`
+	// Discovery registers this server in Consul or etcd.
+	Discovery *Discovery `json:"discovery" toml:"discovery" yaml:"discovery" xml:"discovery"`
+	// RateLimit limits tunneled requests per upstream IP and per target client.
+	RateLimit *RateLimit `json:"rateLimit" toml:"rate_limit" yaml:"rateLimit" xml:"rate_limit"`
 	*server.Config
 	dispatch *server.Server
 	client   *http.Client
-	server   *http.Server
+	servers  []*http.Server
+	redirect *http.Server  // only used with RedirectHTTP.
+	certs    *certReloader // only used with SSLCertFile.
+	tls      *tls.Config
+	auth     *authCheck // cached auth proxy health check.
 	allow    *AllowedIPs
-	log      *log.Logger
-	httpLog  *log.Logger
+	trusted  *AllowedIPs
+	metrics  *metrics
+	keys     keyCache      // nil when key caching is disabled.
+	peers    *peers        // nil without Peers.
+	watchdog chan struct{} // nil without a systemd watchdog.
+	// authProxies are AuthURL and AuthURLs, in order.
+	authProxies []*authEndpoint
+	// Rate limiters are nil when their limit is disabled.
+	upstreamLimit *rateLimiter
+	clientLimit   *rateLimiter
+	vhosts        map[string]string // normalized Vhosts.
+	wildcard      string            // normalized VhostWildcard, without the *.
+	labels        map[string]string // normalized VhostLabels.
+	log           *log.Logger
+	slog          *mulch.SlogLogger // only used when LogFormat is json.
+	httpLog       *log.Logger
+	auditLog      *log.Logger
 }
 
 type StringSlice []string
@@ -80,21 +178,66 @@ const keyLen = 36
 
 // LoadConfigFile does what its name implies.
 func LoadConfigFile(path string) (*Config, error) {
+	config := newConfig()
+
+	if err := cnfgfile.Unmarshal(config, path); err != nil {
+		return nil, fmt.Errorf("failed to parse configuration: %w", err)
+	}
+
+	if err := config.setup(); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// newConfig returns a config with the defaults that a config file may override.
+func newConfig() *Config {
 	config := &Config{
 		Config: server.NewConfig(),
 		client: &http.Client{},
+		auth:   &authCheck{},
 	}
-	config.Config.KeyValidator = config.KeyValidator
+	config.Config.ExpiringKeyValidator = config.ExpiringKeyValidator
 	config.Config.Logger = config
+	config.Config.Auditor = config.Audit
 
-	if err := cnfgfile.Unmarshal(config, path); err != nil {
-		return nil, fmt.Errorf("failed to parse configuration: %w", err)
-	}
+	return config
+}
 
+// setup parses the config file values, and fills in the rest of the defaults.
+func (c *Config) setup() error {
 	// We put this here, so we can print the parsed IPs on startup.
-	config.allow = MakeIPs(config.Upstreams)
+	c.allow = MakeIPs(c.Upstreams)
+	c.trusted = MakeIPs(c.TrustedProxies)
+	c.parseVhosts()
+	c.setupAuthProxies()
 
-	return config, nil
+	if c.ACMEChallenge == "" {
+		c.ACMEChallenge = ChallengeDNS
+	}
+
+	if err := c.parsePaths(); err != nil {
+		return err
+	}
+
+	if err := c.parsePathLabels(); err != nil {
+		return err
+	}
+
+	if err := c.parseTLSPolicy(); err != nil {
+		return err
+	}
+
+	if err := c.setupKeyCache(); err != nil {
+		return err
+	}
+
+	if err := c.setupPeers(); err != nil {
+		return err
+	}
+
+	return c.setupDiscovery()
 }
 
 // Start HTTP server.
@@ -104,50 +247,69 @@ func (c *Config) Start() {
 	}
 
 	c.dispatch = server.NewServer(c.Config)
-	smx := http.NewServeMux()
+	c.metrics = newMetrics()
+	c.setupRateLimits()
 	apache, _ := apachelog.New(c.ApacheLogFormat())
+	routes := c.routes(apache)
+
+	tlsConfig := c.applyTLSPolicy(c.setupTLS())
+	c.tls = tlsConfig
+
+	if c.ListenAddr != "" {
+		c.servers = append(c.servers, &http.Server{
+			ErrorLog:    c.log,
+			Addr:        c.ListenAddr,
+			Handler:     c.newHandler(apache, routes, nil),
+			ReadTimeout: c.Config.Timeout,
+			TLSConfig:   tlsConfig,
+		})
+	}
 
-	smx.Handle("/metrics", apache.Wrap(c.ValidateUpstream(promhttp.Handler()), c.httpLog.Writer()))
-	smx.Handle("/register", c.dispatch.HandleRegister()) // apache log can't do websockets.
-	smx.Handle("/stats", apache.Wrap(c.ValidateUpstream(http.HandlerFunc(c.dispatch.HandleStats)), c.httpLog.Writer()))
-	smx.Handle("/request", apache.Wrap(http.HandlerFunc(c.HandleAll), c.httpLog.Writer())) // handleAll
-	smx.Handle("/request/", apache.Wrap(http.StripPrefix("/request",
-		c.ValidateUpstream(c.parsePath())), c.httpLog.Writer()))
-	smx.Handle("/health", apache.Wrap(http.HandlerFunc(c.HandleOK), c.httpLog.Writer()))
-	smx.Handle("/", apache.Wrap(http.HandlerFunc(c.HandleAll), c.httpLog.Writer()))
-
-	var tlsConfig *tls.Config
-
-	// Create TLS certificates if a Cache dir, CF Token and SSL Names are provided.
-	if c.CacheDir != "" && len(c.SSLNames) > 0 && c.CFToken != "" {
-		certmagic.DefaultACME.Email = c.Email
-		certmagic.DefaultACME.Agreed = true
-		certmagic.Default.Storage = &certmagic.FileStorage{Path: c.CacheDir}
-		certmagic.DefaultACME.DNS01Solver = &certmagic.DNS01Solver{
-			DNSProvider: &cloudflare.Provider{APIToken: c.CFToken},
+	for _, listener := range c.Listeners {
+		srv := &http.Server{
+			ErrorLog:    c.log,
+			Addr:        listener.Addr,
+			Handler:     c.newHandler(apache, routes, listener.Handlers),
+			ReadTimeout: c.Config.Timeout,
 		}
 
-		var err error
-		if tlsConfig, err = certmagic.TLS(c.SSLNames); err != nil {
-			log.Fatalln("CertMagic TLS config failed:", err)
+		if listener.TLS {
+			srv.TLSConfig = tlsConfig
 		}
+
+		c.servers = append(c.servers, srv)
 	}
 
-	c.server = &http.Server{
-		ErrorLog:    c.log,
-		Addr:        c.ListenAddr,
-		Handler:     smx,
-		ReadTimeout: c.Config.Timeout,
-		TLSConfig:   tlsConfig,
+	// Bind every address before serving any of them, so READY=1 (below) means they all accept connections.
+	listeners := make([]net.Listener, len(c.servers))
+	for idx, srv := range c.servers {
+		listeners[idx] = c.listen(srv)
 	}
 
 	// Dispatch connection from available pools to client requests.
 	go c.dispatch.StartDispatcher()
-	// In a separate thread from the server thread.
-	go c.runWebServer()
+
+	// In separate threads from the server thread.
+	for idx, srv := range c.servers {
+		go c.runWebServer(srv, listeners[idx])
+	}
+
+	if c.peers != nil {
+		go c.pollPeers()
+	}
+
+	if c.Discovery != nil {
+		c.Discovery.stop = make(chan struct{})
+		c.Discovery.done = make(chan struct{})
+
+		go c.runDiscovery()
+	}
+
+	c.startWatchdog()
+	c.notify(sdReady)
 }
 
-// parsePath is an assumption built for notifiarr.
+// parsePath is an assumption built for notifiarr. It is used when no PathLabels are configured.
 // This uses a portion of the path as a label so
 // we can see response times of our requests per api endpoint.
 // We only use this tunnel to talk to 1 app, so the api paths we hit are bounded.
@@ -175,50 +337,66 @@ func (c *Config) parsePath() http.HandlerFunc { //nolint:cyclop
 	}
 }
 
-func (c *Config) runWebServer() {
-	var err error
+func (c *Config) Shutdown() {
+	c.notify(sdStopping)
 
-	if c.server.TLSConfig != nil {
-		err = c.server.ListenAndServeTLS("", "")
-	} else {
-		err = c.server.ListenAndServe()
+	if c.watchdog != nil {
+		close(c.watchdog)
 	}
 
-	if err != nil && !errors.Is(err, http.ErrServerClosed) {
-		log.Fatalln("Web server failed, exiting:", err)
+	if c.peers != nil {
+		close(c.peers.stop)
+	}
+
+	if c.Discovery != nil {
+		close(c.Discovery.stop)
+		<-c.Discovery.done // wait for deregistration.
 	}
-}
 
-func (c *Config) Shutdown() {
 	c.dispatch.Shutdown()
 }
 
 // KeyValidator validates client secret keys against an nginx auth proxy.
 // The actual auth proxy is: http://github.com/Notifiarr/mysql-auth-proxy
 func (c *Config) KeyValidator(ctx context.Context, header http.Header) (string, error) {
+	key, _, err := c.ExpiringKeyValidator(ctx, header)
+	return key, err
+}
+
+// ExpiringKeyValidator validates client secret keys against an nginx auth proxy,
+// and returns the key's expiration if AuthExpiresHeader is configured.
+func (c *Config) ExpiringKeyValidator(ctx context.Context, header http.Header) (string, time.Time, error) {
 	key := header.Get(mulch.SecretKeyHeader)
 	if key == "" || len(key) != keyLen {
-		return "", fmt.Errorf("%w: keyLen: %d!=%d", ErrInvalidKey, len(key), keyLen)
+		return "", time.Time{}, fmt.Errorf("%w: keyLen: %d!=%d", ErrInvalidKey, len(key), keyLen)
 	}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.AuthURL, nil)
-	if err != nil {
-		return "", fmt.Errorf("creating auth proxy request: %w", err)
+	if expires, ok, err := c.cachedKey(ctx, key); ok {
+		return key, expires, err
 	}
 
-	req.Header.Add(c.AuthHeader, key)
+	expires, err := c.authorize(ctx, key)
+	c.cacheKey(ctx, key, expires, err)
 
-	resp, err := c.client.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("connecting to auth proxy: %w", err)
+		return "", time.Time{}, err
 	}
-	defer resp.Body.Close()
 
-	_, _ = io.Copy(io.Discard, resp.Body) // avoid memory leak
+	return key, expires, nil
+}
+
+// parseExpires turns unix seconds or an RFC3339 date into a time.
+// Returns a zero time (never expires) if the value is empty or invalid.
+func parseExpires(value string) time.Time {
+	if value == "" {
+		return time.Time{}
+	}
 
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("%w: status: %s", ErrInvalidKey, resp.Status)
+	if secs, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(secs, 0)
 	}
 
-	return key, nil
+	expires, _ := time.Parse(time.RFC3339, value)
+
+	return expires
 }
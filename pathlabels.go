@@ -0,0 +1,100 @@
+package mulery
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// otherPathLabel is used for requests that match no path label rule.
+const otherPathLabel = "/other"
+
+// PathLabel is a rule that buckets request paths into handler labels for request metrics.
+// Labels must be bounded, so never use a rule that keeps random path parts (like IDs).
+// Rules are checked in order, and the first match wins.
+type PathLabel struct {
+	// Prefix matches paths beginning with this value. Ignored if Regexp is set.
+	Prefix string `json:"prefix" toml:"prefix" yaml:"prefix" xml:"prefix"`
+	// Regexp matches paths with a regular expression.
+	// If Label is empty, the matched text (not the whole path) becomes the label.
+	Regexp string `json:"regexp" toml:"regexp" yaml:"regexp" xml:"regexp"`
+	// Depth is the number of path segments to keep for a Prefix match. 0 uses the prefix as the label.
+	Depth int `json:"depth" toml:"depth" yaml:"depth" xml:"depth"`
+	// Label is a fixed label for matching paths. Optional.
+	Label  string `json:"label" toml:"label" yaml:"label" xml:"label"`
+	regexp *regexp.Regexp
+}
+
+// parsePathLabels compiles the regular expressions in the path label rules.
+func (c *Config) parsePathLabels() error {
+	for idx, rule := range c.PathLabels {
+		if rule.Regexp == "" {
+			continue
+		}
+
+		var err error
+		if rule.regexp, err = regexp.Compile(rule.Regexp); err != nil {
+			return fmt.Errorf("path label %d: %w", idx+1, err)
+		}
+	}
+
+	return nil
+}
+
+// label returns the handler label for a path, and true if the rule matches.
+func (p *PathLabel) label(path string) (string, bool) {
+	if p.regexp != nil {
+		match := p.regexp.FindString(path)
+		if match == "" {
+			return "", false
+		}
+
+		if p.Label != "" {
+			return p.Label, true
+		}
+
+		return match, true
+	}
+
+	if !strings.HasPrefix(path, p.Prefix) {
+		return "", false
+	}
+
+	switch {
+	case p.Label != "":
+		return p.Label, true
+	case p.Depth <= 0:
+		return p.Prefix, true
+	}
+
+	// The path begins with a slash, so the first element is always empty.
+	if parts := strings.SplitN(path, "/", p.Depth+2); len(parts) > p.Depth+1 { //nolint:gomnd
+		return strings.Join(parts[:p.Depth+1], "/"), true
+	}
+
+	return path, true
+}
+
+// pathLabel returns the first matching rule's label for a path.
+func (c *Config) pathLabel(path string) string {
+	for _, rule := range c.PathLabels {
+		if label, ok := rule.label(path); ok {
+			return label
+		}
+	}
+
+	return otherPathLabel
+}
+
+// labelPath routes requests to the dispatcher with a handler label from the configured rules.
+// Without rules, the notifiarr-specific parsePath is used.
+func (c *Config) labelPath() http.Handler {
+	if len(c.PathLabels) == 0 {
+		return c.parsePath()
+	}
+
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		c.dispatch.HandleRequest(c.pathLabel(req.URL.Path)).ServeHTTP(resp, req)
+	})
+}
@@ -0,0 +1,209 @@
+package mulery
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golift.io/mulery/server"
+)
+
+// Peer federation settings.
+const (
+	// PeerTokenHeader carries the shared peer token between mulery servers.
+	PeerTokenHeader = "X-Mulery-Peer-Token"
+	// DefaultPeerInterval is how often peers are asked for their client lists.
+	DefaultPeerInterval = 10 * time.Second
+	// peerRequestPath is appended to the peer handler path to tunnel requests through a peer.
+	peerRequestPath = "/request"
+)
+
+// ErrPeer is returned when a peer does not return its client list.
+var ErrPeer = errors.New("peer request failed")
+
+// peerContextKey marks requests that came from a peer, so they are never sent to another peer.
+type peerContextKey struct{}
+
+// peers tracks which clients are connected to each peer.
+type peers struct {
+	mu      sync.RWMutex
+	clients map[string]string // client ID -> peer URL.
+	targets map[string]string // peer URL -> peer request endpoint.
+	stop    chan struct{}
+}
+
+// setupPeers builds the request endpoint for each peer. Runs once on startup.
+func (c *Config) setupPeers() error {
+	if len(c.Peers) == 0 {
+		return nil
+	}
+
+	if c.PeerInterval <= 0 {
+		c.PeerInterval = DefaultPeerInterval
+	}
+
+	c.peers = &peers{
+		clients: make(map[string]string),
+		targets: make(map[string]string),
+		stop:    make(chan struct{}),
+	}
+
+	for _, peer := range c.Peers {
+		target, err := url.Parse(strings.TrimSuffix(peer, "/") + c.path(HandlersPeer) + peerRequestPath)
+		if err != nil {
+			return fmt.Errorf("parsing peer url: %w", err)
+		}
+
+		c.peers.targets[peer] = target.String()
+	}
+
+	c.Config.Fallback = c.peerFallback
+
+	return nil
+}
+
+// pollPeers asks every peer for its client list, until Shutdown.
+func (c *Config) pollPeers() {
+	ticker := time.NewTicker(c.PeerInterval)
+	defer ticker.Stop()
+
+	for {
+		c.updatePeers()
+
+		select {
+		case <-ticker.C:
+		case <-c.peers.stop:
+			return
+		}
+	}
+}
+
+// updatePeers replaces the client list with fresh lists from every peer.
+// Peers that do not answer are left out, so their clients are not routed to them.
+func (c *Config) updatePeers() {
+	clients := make(map[string]string)
+
+	for idx := len(c.Peers) - 1; idx >= 0; idx-- { // first peer wins if a client is on more than one.
+		ids, err := c.peerClients(c.Peers[idx])
+		if err != nil {
+			c.Errorf("Getting client list from peer %s: %v", c.Peers[idx], err)
+			continue
+		}
+
+		for _, id := range ids {
+			clients[id] = c.Peers[idx]
+		}
+	}
+
+	c.peers.mu.Lock()
+	defer c.peers.mu.Unlock()
+
+	c.peers.clients = clients
+}
+
+// peerClients returns the client IDs connected to a peer.
+func (c *Config) peerClients(peer string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.PeerInterval)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(peer, "/")+c.path(HandlersPeer), nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+
+	req.Header.Set(PeerTokenHeader, c.PeerToken)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("making request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %s", ErrPeer, resp.Status)
+	}
+
+	var ids []string
+	if err := json.NewDecoder(resp.Body).Decode(&ids); err != nil {
+		return nil, fmt.Errorf("decoding client list: %w", err)
+	}
+
+	return ids, nil
+}
+
+// peerFallback sends requests for clients that are not connected here to the peer they are connected to.
+func (c *Config) peerFallback(resp http.ResponseWriter, req *http.Request, clientID string) bool {
+	if req.Context().Value(peerContextKey{}) != nil {
+		return false // it came from a peer, do not send it back out.
+	}
+
+	c.peers.mu.RLock()
+	peer := c.peers.clients[clientID]
+	c.peers.mu.RUnlock()
+
+	if peer == "" {
+		return false
+	}
+
+	c.Debugf("Sending request for client %s to peer %s", clientID, peer)
+	req.Header.Set(PeerTokenHeader, c.PeerToken)
+
+	forwarded, err := c.dispatch.ForwardRequest(c.peers.targets[peer], clientID, req)
+	if err != nil {
+		c.dispatch.ProxyError(resp, req, err, "")
+		return true
+	}
+
+	if _, err := server.WriteForwarded(resp, forwarded); err != nil {
+		c.Errorf("Sending response from peer %s: %v", peer, err)
+	}
+
+	return true
+}
+
+// validPeer returns true if the request has the peer token.
+func (c *Config) validPeer(req *http.Request) bool {
+	token := req.Header.Get(PeerTokenHeader)
+	return c.PeerToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(c.PeerToken)) == 1
+}
+
+// HandlePeers returns the IDs of the clients connected to this server, for peers.
+func (c *Config) HandlePeers(resp http.ResponseWriter, req *http.Request) {
+	if !c.validPeer(req) {
+		c.HandleAll(resp, req)
+		return
+	}
+
+	clients := c.dispatch.Clients()
+	ids := make([]string, len(clients))
+
+	for idx, client := range clients {
+		ids[idx] = client.ID
+	}
+
+	resp.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(resp).Encode(ids); err != nil {
+		c.Errorf("Encoding peer client list: %v", err)
+	}
+}
+
+// ValidatePeer only allows requests with the peer token, and marks them so they are not sent to another peer.
+func (c *Config) ValidatePeer(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if !c.validPeer(req) {
+			c.HandleAll(resp, req)
+			return
+		}
+
+		req.Header.Del(PeerTokenHeader)
+		next.ServeHTTP(resp, req.WithContext(context.WithValue(req.Context(), peerContextKey{}, true)))
+	})
+}
@@ -0,0 +1,154 @@
+package mulery
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Rate limit labels used in the rate limited metric.
+const (
+	limitUpstream = "upstream"
+	limitClient   = "client"
+)
+
+// pruneInterval is how often full (unused) rate limit buckets are thrown away.
+const pruneInterval = time.Minute
+
+// RateLimit limits how many requests are tunneled per second.
+// Requests over the limit get a 429 before they are dispatched to a client.
+type RateLimit struct {
+	// Upstream is the requests per second allowed from each upstream IP. 0 disables this limit.
+	Upstream float64 `json:"upstream" toml:"upstream" yaml:"upstream" xml:"upstream"`
+	// Client is the requests per second allowed to each target client ID. 0 disables this limit.
+	Client float64 `json:"client" toml:"client" yaml:"client" xml:"client"`
+	// Burst is how many requests may be made at once, above the rate. Default is the rate (1 second of requests).
+	Burst int `json:"burst" toml:"burst" yaml:"burst" xml:"burst"`
+}
+
+// rateLimiter is a set of token buckets, one per key.
+type rateLimiter struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   float64
+	buckets map[string]*bucket
+	pruned  time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+
+	if burst <= 0 {
+		burst = int(math.Ceil(rate))
+	}
+
+	return &rateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[string]*bucket),
+		pruned:  time.Now(),
+	}
+}
+
+// allow takes a token from the key's bucket. If the bucket is empty, it returns
+// false and how long until a token is available. A nil limiter allows everything.
+func (r *rateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	if r == nil {
+		return true, 0
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.prune(now)
+
+	buck := r.buckets[key]
+	if buck == nil {
+		buck = &bucket{tokens: r.burst, last: now}
+		r.buckets[key] = buck
+	}
+
+	buck.tokens = math.Min(r.burst, buck.tokens+now.Sub(buck.last).Seconds()*r.rate)
+	buck.last = now
+
+	if buck.tokens < 1 {
+		return false, time.Duration((1 - buck.tokens) / r.rate * float64(time.Second))
+	}
+
+	buck.tokens--
+
+	return true, 0
+}
+
+// prune removes buckets that have refilled, so the map does not grow forever. Not thread safe.
+func (r *rateLimiter) prune(now time.Time) {
+	if now.Sub(r.pruned) < pruneInterval {
+		return
+	}
+
+	r.pruned = now
+
+	for key, buck := range r.buckets {
+		if buck.tokens+now.Sub(buck.last).Seconds()*r.rate >= r.burst {
+			delete(r.buckets, key)
+		}
+	}
+}
+
+// setupRateLimits creates the rate limiters from the config. Runs once on startup.
+func (c *Config) setupRateLimits() {
+	if c.RateLimit == nil {
+		return
+	}
+
+	c.upstreamLimit = newRateLimiter(c.RateLimit.Upstream, c.RateLimit.Burst)
+	c.clientLimit = newRateLimiter(c.RateLimit.Client, c.RateLimit.Burst)
+}
+
+// Limit returns 429 Too Many Requests to requests over the configured rate limits.
+// This must run after the vhost router sets the client ID header.
+func (c *Config) Limit(next http.Handler) http.Handler {
+	if c.upstreamLimit == nil && c.clientLimit == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		now := time.Now()
+
+		host, _, err := net.SplitHostPort(req.RemoteAddr)
+		if err != nil {
+			host = req.RemoteAddr
+		}
+
+		if ok, wait := c.upstreamLimit.allow(host, now); !ok {
+			c.limited(resp, limitUpstream, wait)
+			return
+		}
+
+		if c.IDHeader != "" {
+			if ok, wait := c.clientLimit.allow(req.Header.Get(c.IDHeader), now); !ok {
+				c.limited(resp, limitClient, wait)
+				return
+			}
+		}
+
+		next.ServeHTTP(resp, req)
+	})
+}
+
+// limited responds to a request that went over a rate limit.
+func (c *Config) limited(resp http.ResponseWriter, limit string, wait time.Duration) {
+	c.metrics.addLimited(limit)
+	resp.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	http.Error(resp, "rate limit exceeded: "+limit, http.StatusTooManyRequests)
+}
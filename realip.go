@@ -0,0 +1,52 @@
+package mulery
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// RealIP replaces the request's remote address with the client IP from the X-Forwarded-For
+// or X-Real-IP header, but only when the request comes from a trusted proxy.
+// The allow list, apache log and audit log all use the replaced address.
+func (c *Config) RealIP(next http.Handler) http.Handler {
+	if len(c.TrustedProxies) == 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if ip := c.forwardedFor(req); ip != "" {
+			_, port, _ := net.SplitHostPort(req.RemoteAddr)
+			req.RemoteAddr = net.JoinHostPort(ip, port)
+		}
+
+		next.ServeHTTP(resp, req)
+	})
+}
+
+// forwardedFor returns the client IP a trusted proxy forwarded the request for, or an empty string.
+// X-Forwarded-For is read right to left, and trusted proxies are skipped, so a requester cannot
+// spoof their address by sending their own header through the proxy.
+func (c *Config) forwardedFor(req *http.Request) string {
+	if !c.trusted.Contains(req.RemoteAddr) {
+		return ""
+	}
+
+	forwarded := strings.Split(strings.Join(req.Header.Values("X-Forwarded-For"), ","), ",")
+	for idx := len(forwarded) - 1; idx >= 0; idx-- {
+		ip := net.ParseIP(strings.TrimSpace(forwarded[idx]))
+		if ip == nil {
+			break // garbage, so nothing left of here can be trusted either.
+		}
+
+		if !c.trusted.Contains(net.JoinHostPort(ip.String(), "0")) {
+			return ip.String()
+		}
+	}
+
+	if ip := net.ParseIP(strings.TrimSpace(req.Header.Get("X-Real-IP"))); ip != nil {
+		return ip.String()
+	}
+
+	return ""
+}
@@ -0,0 +1,170 @@
+package mulery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// testLog collects the messages a cache logs.
+type testLog struct {
+	mu   sync.Mutex
+	msgs []string
+}
+
+func (l *testLog) logf(format string, v ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.msgs = append(l.msgs, fmt.Sprintf(format, v...))
+}
+
+func (l *testLog) len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return len(l.msgs)
+}
+
+func TestRedisCache(t *testing.T) {
+	t.Parallel()
+
+	server := miniredis.RunT(t)
+	server.RequireUserAuth("user", "secret")
+
+	logs := &testLog{}
+
+	cache, err := newRedisCache("redis://user:secret@"+server.Addr()+"/2", logs.logf)
+	if err != nil {
+		t.Fatalf("creating cache: %v", err)
+	}
+
+	ctx := context.Background()
+
+	if _, ok := cache.get(ctx, "missing"); ok {
+		t.Errorf("missing key: got a cache hit")
+	}
+
+	expires := time.Now().Add(time.Hour).Round(time.Second)
+	cache.set(ctx, "valid", &keyResult{Valid: true, Expires: expires}, time.Minute)
+	cache.set(ctx, "invalid", &keyResult{Valid: false}, time.Second)
+
+	if result, ok := cache.get(ctx, "valid"); !ok || !result.Valid || !result.Expires.Equal(expires) {
+		t.Errorf("valid key: got %v %v", result, ok)
+	}
+
+	if result, ok := cache.get(ctx, "invalid"); !ok || result.Valid {
+		t.Errorf("invalid key: got %v %v", result, ok)
+	}
+
+	server.Select(2) //nolint:gomnd // the database in the URL.
+
+	if !server.Exists(redisKeyPrefix + "valid") {
+		t.Errorf("key was not stored in the database from the URL")
+	}
+
+	server.FastForward(2 * time.Second)
+
+	if _, ok := cache.get(ctx, "invalid"); ok {
+		t.Errorf("expired key: got a cache hit")
+	}
+
+	if logs.len() != 0 {
+		t.Errorf("unexpected log messages: %v", logs.msgs)
+	}
+}
+
+// TestRedisCacheConcurrent makes sure lookups do not wait on each other; they share a pool of connections.
+func TestRedisCacheConcurrent(t *testing.T) {
+	t.Parallel()
+
+	server := miniredis.RunT(t)
+	logs := &testLog{}
+
+	cache, err := newRedisCache("redis://"+server.Addr()+"/0?pool_size=8", logs.logf)
+	if err != nil {
+		t.Fatalf("creating cache: %v", err)
+	}
+
+	ctx := context.Background()
+	cache.set(ctx, "key", &keyResult{Valid: true}, time.Minute)
+
+	var wg sync.WaitGroup
+
+	for idx := 0; idx < 50; idx++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			if _, ok := cache.get(ctx, "key"); !ok {
+				t.Error("concurrent lookup missed")
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if stats := cache.client.PoolStats(); stats.TotalConns < 2 || stats.TotalConns > 8 {
+		t.Errorf("pool has %d connections, want 2-8", stats.TotalConns)
+	}
+}
+
+// TestRedisCacheDown makes sure a redis outage is a cache miss, and the cache recovers when redis returns.
+func TestRedisCacheDown(t *testing.T) {
+	t.Parallel()
+
+	server := miniredis.RunT(t)
+	logs := &testLog{}
+
+	cache, err := newRedisCache("redis://"+server.Addr(), logs.logf)
+	if err != nil {
+		t.Fatalf("creating cache: %v", err)
+	}
+
+	ctx := context.Background()
+	cache.set(ctx, "key", &keyResult{Valid: true}, time.Minute)
+	server.Close()
+
+	if _, ok := cache.get(ctx, "key"); ok {
+		t.Errorf("redis is down: got a cache hit")
+	}
+
+	if logs.len() == 0 {
+		t.Errorf("redis is down: nothing was logged")
+	}
+
+	if err := server.Restart(); err != nil {
+		t.Fatalf("restarting redis: %v", err)
+	}
+
+	cache.set(ctx, "key", &keyResult{Valid: true}, time.Minute)
+
+	if _, ok := cache.get(ctx, "key"); !ok {
+		t.Errorf("redis is back: got a cache miss")
+	}
+}
+
+func TestNewRedisCacheURL(t *testing.T) {
+	t.Parallel()
+
+	for _, input := range []string{"http://localhost:6379", "redis://localhost:6379/db", "::"} {
+		if _, err := newRedisCache(input, (&testLog{}).logf); !errors.Is(err, ErrRedis) {
+			t.Errorf("%s: got error %v, want %v", input, err, ErrRedis)
+		}
+	}
+
+	cache, err := newRedisCache("rediss://localhost", (&testLog{}).logf)
+	if err != nil {
+		t.Fatalf("rediss url: %v", err)
+	}
+
+	if options := cache.client.Options(); options.Addr != "localhost:6379" || options.TLSConfig == nil {
+		t.Errorf("rediss url: got address %s, tls: %v", options.Addr, options.TLSConfig != nil)
+	}
+}
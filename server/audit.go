@@ -0,0 +1,39 @@
+package server
+
+import (
+	"time"
+)
+
+// Audit actions passed to the Auditor in AuditEvent.Action.
+const (
+	AuditRegister   = "register"
+	AuditDisconnect = "disconnect"
+	AuditKeyFailed  = "keyFailed"
+	AuditKeyExpired = "keyExpired"
+)
+
+// AuditEvent is a security relevant event passed to Config.Auditor.
+type AuditEvent struct {
+	Time     time.Time `json:"time"`
+	Action   string    `json:"action"`
+	ClientID string    `json:"clientId,omitempty"`
+	Name     string    `json:"name,omitempty"`
+	Remote   string    `json:"remote,omitempty"`
+	Message  string    `json:"message,omitempty"`
+}
+
+// audit sends an event to the configured auditor, if there is one.
+func audit(auditor func(*AuditEvent), action, cID, name, remote, message string) {
+	if auditor == nil {
+		return
+	}
+
+	auditor(&AuditEvent{
+		Time:     time.Now(),
+		Action:   action,
+		ClientID: cID,
+		Name:     name,
+		Remote:   remote,
+		Message:  message,
+	})
+}
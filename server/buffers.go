@@ -0,0 +1,35 @@
+package server
+
+import (
+	"io"
+	"sync"
+)
+
+// DefaultCopyBufferSize is the size of the buffers used to copy request and response bodies.
+const DefaultCopyBufferSize = 32 * 1024
+
+// copyBuffers is a pool of buffers used to copy bodies between upstream requests and websockets.
+// io.Copy allocates a new buffer for every copy when the writer does not implement io.ReaderFrom,
+// and most of the response writer wrappers (logging, metrics) do not.
+type copyBuffers struct {
+	pool sync.Pool
+}
+
+func newCopyBuffers(size int) *copyBuffers {
+	if size <= 0 {
+		size = DefaultCopyBufferSize
+	}
+
+	return &copyBuffers{pool: sync.Pool{New: func() any {
+		buf := make([]byte, size)
+		return &buf
+	}}}
+}
+
+// copy works like io.Copy, with a buffer from the pool.
+func (b *copyBuffers) copy(dst io.Writer, src io.Reader) (int64, error) {
+	buf, _ := b.pool.Get().(*[]byte)
+	defer b.pool.Put(buf)
+
+	return io.CopyBuffer(dst, src, *buf) //nolint:wrapcheck // the callers wrap it.
+}
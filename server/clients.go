@@ -0,0 +1,92 @@
+package server
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// ClientInfo describes a connected client (pool).
+type ClientInfo struct {
+	// ID is the pool's client ID. Use this value in the IDHeader to route requests to the client.
+	ID string `json:"id"`
+	// ClientID is the ID the client provided in its handshake.
+	ClientID string `json:"clientId"`
+	// Name is the client's name from its handshake.
+	Name string `json:"name"`
+	// ClientIDs are the custom identifiers the client provided in its handshake.
+	ClientIDs []interface{} `json:"clientIds"`
+	// Metadata are the labels the client provided in its handshake.
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// Connected is when the client's first connection registered.
+	Connected time.Time `json:"connected"`
+	Total     int       `json:"total"`
+	Idle      int       `json:"idle"`
+	Busy      int       `json:"busy"`
+	// Degraded is true if the client reported that its backend is unhealthy.
+	Degraded bool `json:"degraded"`
+	// Version, GoVersion, OS and Arch are the client's build and platform from its handshake.
+	// Older clients do not send these.
+	Version   string `json:"version,omitempty"`
+	GoVersion string `json:"goVersion,omitempty"`
+	OS        string `json:"os,omitempty"`
+	Arch      string `json:"arch,omitempty"`
+}
+
+// Clients returns a snapshot of every connected client, sorted by ID.
+// Do not call this before StartDispatcher, or after Shutdown.
+func (s *Server) Clients() []*ClientInfo {
+	clients, _ := s.ClientsContext(context.Background())
+	return clients
+}
+
+// ClientsContext is Clients, but it gives up and returns the context's error if the
+// dispatcher does not answer before the context ends. Use this in health checks.
+func (s *Server) ClientsContext(ctx context.Context) ([]*ClientInfo, error) {
+	reply := make(chan []*ClientInfo, 1) // the dispatcher never blocks on a caller that gave up.
+
+	select {
+	case s.getClients <- reply:
+	case <-ctx.Done():
+		return nil, ctx.Err() //nolint:wrapcheck // it is descriptive.
+	}
+
+	select {
+	case clients := <-reply:
+		return clients, nil
+	case <-ctx.Done():
+		return nil, ctx.Err() //nolint:wrapcheck
+	}
+}
+
+// clients runs in the main dispatcher loop.
+func (s *Server) clients() []*ClientInfo {
+	now := time.Now()
+	pools := s.pools.snapshot()
+	clients := make([]*ClientInfo, 0, len(pools))
+
+	for cID, pool := range pools {
+		handshake := pool.Handshake()
+		size := pool.Size(now)
+		clients = append(clients, &ClientInfo{
+			ID:        string(cID),
+			ClientID:  handshake.ID,
+			Name:      handshake.Name,
+			ClientIDs: handshake.ClientIDs,
+			Metadata:  handshake.Metadata,
+			Connected: pool.connected,
+			Total:     size.Total,
+			Idle:      size.Idle,
+			Busy:      size.Busy,
+			Degraded:  pool.Degraded(),
+			Version:   handshake.Version,
+			GoVersion: handshake.GoVersion,
+			OS:        handshake.OS,
+			Arch:      handshake.Arch,
+		})
+	}
+
+	sort.Slice(clients, func(i, j int) bool { return clients[i].ID < clients[j].ID })
+
+	return clients
+}
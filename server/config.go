@@ -3,9 +3,14 @@ package server
 import (
 	"context"
 	"net/http"
+	"sync"
+	"sync/atomic"
+	"text/template"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 	"golift.io/mulery/mulch"
 )
 
@@ -15,10 +20,34 @@ type Config struct {
 	Timeout     time.Duration `json:"timeout" toml:"timeout" yaml:"timeout" xml:"timeout"`
 	IdleTimeout time.Duration `json:"idleTimeout" toml:"idle_timeout" yaml:"idleTimeout" xml:"idle_timeout"`
 	SecretKey   string        `json:"secretKey" toml:"secret_key" yaml:"secretKey" xml:"secret_key"`
+	// CopyBufferSize is the size of the pooled buffers used to copy request and response bodies.
+	// Defaults to 32KB.
+	CopyBufferSize int `json:"copyBufferSize" toml:"copy_buffer_size" yaml:"copyBufferSize" xml:"copy_buffer_size"`
+	// MaxHeaderSize is the largest serialized response header frame accepted from a client.
+	// Defaults to 1MB.
+	MaxHeaderSize int64 `json:"maxHeaderSize" toml:"max_header_size" yaml:"maxHeaderSize" xml:"max_header_size"`
+	// ReadBufferSize and WriteBufferSize are the websocket I/O buffer sizes.
+	// Zero uses the gorilla/websocket default of 4KB.
+	ReadBufferSize  int `json:"readBufferSize" toml:"read_buffer_size" yaml:"readBufferSize" xml:"read_buffer_size"`
+	WriteBufferSize int `json:"writeBufferSize" toml:"write_buffer_size" yaml:"writeBufferSize" xml:"write_buffer_size"`
+	// DisableCompression stops negotiating per-message compression with clients. It is on by default.
+	DisableCompression bool `json:"disableCompression" toml:"disable_compression" yaml:"disableCompression" xml:"disable_compression"`
+	// CompressionLevel is the flate level (-2 to 9) used when compression is enabled. Zero uses 1 (best speed).
+	// Request bodies that are already compressed, like images and gzip, are never compressed again.
+	CompressionLevel int `json:"compressionLevel" toml:"compression_level" yaml:"compressionLevel" xml:"compression_level"`
+	// StatsHistory is the number of connection total snapshots to keep for /stats/history.
+	// One is saved every 5 seconds. Defaults to 720 (1 hour), set to -1 to disable.
+	StatsHistory int `json:"statsHistory" toml:"stats_history" yaml:"statsHistory" xml:"stats_history"`
 	// IDHeader sets the upstream header to parse for a remote client.
 	// Default behavior is to send requests to clients randomly.
 	// If this value is set, requests can only be directed to clients by providing the client ID in this header.
 	IDHeader string `json:"idHeader" toml:"id_header" yaml:"idHeader" xml:"id_header"`
+	// NoPools customizes the response sent when no clients are connected.
+	// If nil, requesters receive a proxy error.
+	NoPools *NoPoolsResponse `json:"noPools" toml:"no_pools" yaml:"noPools" xml:"no_pools"`
+	// ErrorPages are sent to requesters when a tunneled request fails.
+	// The key is a client ID, or * for every client without its own page.
+	ErrorPages map[string]*ErrorPage `json:"errorPages" toml:"error_pages" yaml:"errorPages" xml:"error_pages"`
 	// If a KeyValidator method is provided, then Secretkey is ignored.
 	// If the validator returns a string then all pool IDs become a
 	// sha256 of that string and the client's generated or provided id.
@@ -26,9 +55,41 @@ type Config struct {
 	// This allows you to let clients provide their own ID, but a secure
 	// access-ID is created with your provided seed to prevent hash collisions.
 	KeyValidator func(context.Context, http.Header) (string, error) `json:"-" toml:"-" yaml:"-" xml:"-"`
+	// ExpiringKeyValidator works like KeyValidator, but also returns the time the key expires.
+	// When the key expires, its connections are closed with mulch.CloseAuthExpired. Idle connections
+	// close right away, and busy connections close when their request finishes.
+	// A zero time means the key never expires. If provided, KeyValidator is ignored.
+	ExpiringKeyValidator func(context.Context, http.Header) (string, time.Time, error) `json:"-" toml:"-" yaml:"-" xml:"-"`
+	// KeyHasher creates pool IDs from the validator's string and the client ID, instead of sha256.
+	// Use mulch.HMACHasher, or your own, to keep pool IDs compatible with other systems. Optional.
+	KeyHasher mulch.KeyHasher `json:"-" toml:"-" yaml:"-" xml:"-"`
+	// Fallback is called for requests to clients that are not connected to this server.
+	// Return true if the request was handled (like by sending it to another server), or
+	// false to send the usual no proxy target error. Optional.
+	Fallback func(resp http.ResponseWriter, req *http.Request, clientID string) bool `json:"-" toml:"-" yaml:"-" xml:"-"`
+	// CheckOrigin validates the Origin header on websocket upgrade requests.
+	// If nil, requests with an Origin header that does not match the Host header are refused.
+	CheckOrigin func(req *http.Request) bool `json:"-" toml:"-" yaml:"-" xml:"-"`
+	// RequireFrames refuses clients that do not read and write Frame envelopes (mulch.Handshake.Frames).
+	// They are closed with mulch.CloseUpgradeRequired, and stop reconnecting to this server.
+	RequireFrames bool `json:"requireFrames" toml:"require_frames" yaml:"requireFrames" xml:"require_frames"`
+	// OnKeyExpire is called once with the pool name when a client's key expires, as its connections are closed.
+	OnKeyExpire func(poolID string, expired time.Time) `json:"-" toml:"-" yaml:"-" xml:"-"`
 	// Logger allows routing logs from this package to somewhere special.
 	// If left nil logs are written to stdout.
 	Logger mulch.Logger `json:"-" toml:"-" yaml:"-" xml:"-"`
+	// Auditor receives security relevant events: registrations, disconnects and key failures.
+	// This is called synchronously, so do not block.
+	Auditor func(*AuditEvent) `json:"-" toml:"-" yaml:"-" xml:"-"`
+	// RequestObserver receives callbacks for every proxied request. Optional.
+	RequestObserver RequestObserver `json:"-" toml:"-" yaml:"-" xml:"-"`
+	// TracerProvider enables OpenTelemetry tracing of proxied requests.
+	// A span is started for every request, and the trace is propagated to the client.
+	// Leave this nil to disable tracing.
+	TracerProvider trace.TracerProvider `json:"-" toml:"-" yaml:"-" xml:"-"`
+	// Propagator is used to continue upstream traces and propagate them to clients.
+	// Defaults to W3C Trace Context if TracerProvider is not nil.
+	Propagator propagation.TextMapPropagator `json:"-" toml:"-" yaml:"-" xml:"-"`
 }
 
 // Server is a Reverse HTTP Proxy over WebSocket.
@@ -38,52 +99,69 @@ type Server struct {
 	Config   *Config
 	upgrader websocket.Upgrader
 	// In pools, keep connections with WebSocket peers.
-	pools   map[clientID]*Pool
+	pools   *poolMap
 	newPool chan *PoolConfig
 	// Through dispatcher channel it communicates between "http server" thread and "dispatcher" thread.
 	// "server" thread sends the value to this channel when accepting requests in the endpoint /requests,
 	// and "dispatcher" thread reads this channel.
 	dispatcher  chan *dispatchRequest
 	metrics     *Metrics
-	closed      int // pools that have been closed.
-	threadCount map[uint]uint64
-	getPool     chan *getPoolRequest
-	repPool     chan *Pool
-	getStats    chan clientID
+	closed      int                     // pools that have been closed.
+	threadCount map[uint]*atomic.Uint64 // requests per dispatcher, filled in by StartDispatcher.
+	dispatchers sync.WaitGroup
+	getStats    chan *statsQuery
 	repStats    chan *Stats
+	getState    chan struct{}
+	repState    chan *State
+	getClients  chan chan []*ClientInfo
+	getHistory  chan struct{}
+	repHistory  chan []*HistoryPoint
+	history     *history
+	dispatching atomic.Int64 // requests waiting on the dispatcher.
+	disconnect  chan *disconnect
+	draining    atomic.Bool // set by Drain, refuses new registrations.
+	tracer      *tracer
+	noPools     *template.Template
+	errorPages  map[string]*errorPage
+	forward     *http.Client // used by ForwardRequest.
+	buffers     *copyBuffers
 }
 
 type Stats struct {
 	Pools   map[clientID]any `json:"pools"`
 	Threads map[uint]uint64  `json:"threads"`
+	// Matched is the count of pools matching the filters, before pagination.
+	Matched int `json:"matched"`
+	// Summary contains totals for the matched pools, only when requested.
+	Summary *PoolSize `json:"summary,omitempty"`
 }
 
 // PoolConfig is a struct for transitting a new pool's data through a channel.
 type PoolConfig struct {
 	*mulch.Handshake
-	Sock   *websocket.Conn
-	secret string
+	Sock    *websocket.Conn
+	secret  string
+	expires time.Time
 }
 
 // dispatchRequest is used to request a proxy connection from the dispatcher.
 // By sending it through a channel.
 type dispatchRequest struct {
+	ctx        context.Context //nolint:containedctx // the dispatcher gives up when the requester does.
 	connection chan *Connection
 	client     clientID
-}
-
-type getPoolRequest struct {
-	threadID uint
-	clientID clientID
+	created    time.Time
+	degraded   bool // set by the dispatcher before closing connection.
 }
 
 // NewConfig creates a new ProxyConfig.
 func NewConfig() *Config {
 	return &Config{
-		Dispatchers: 1,
-		Timeout:     time.Second,
-		IdleTimeout: time.Minute + time.Second,
-		Logger:      &mulch.DefaultLogger{},
+		Dispatchers:  1,
+		StatsHistory: DefaultStatsHistory,
+		Timeout:      time.Second,
+		IdleTimeout:  time.Minute + time.Second,
+		Logger:       &mulch.DefaultLogger{},
 	}
 }
 
@@ -99,20 +177,44 @@ func NewServer(config *Config) *Server {
 		config.Dispatchers = 1
 	}
 
+	if config.StatsHistory == 0 {
+		config.StatsHistory = DefaultStatsHistory
+	}
+
+	for _, warning := range config.Lint() {
+		config.Logger.Warnf("Config: %s", warning)
+	}
+
+	noPools, _ := config.NoPools.parse()                // Lint() warns about errors.
+	errorPages, _ := parseErrorPages(config.ErrorPages) // Lint() warns about errors.
+
 	return &Server{
 		Config: config,
 		upgrader: websocket.Upgrader{
-			EnableCompression: true,
+			ReadBufferSize:    max(config.ReadBufferSize, 0),
+			WriteBufferSize:   max(config.WriteBufferSize, 0),
+			EnableCompression: !config.DisableCompression,
+			CheckOrigin:       config.CheckOrigin,
 			HandshakeTimeout:  mulch.HandshakeTimeout,
 		},
 		newPool:     make(chan *PoolConfig, defaultPoolBuffer),
 		dispatcher:  make(chan *dispatchRequest),
-		pools:       make(map[clientID]*Pool),
-		threadCount: make(map[uint]uint64),
+		pools:       newPoolMap(),
+		threadCount: make(map[uint]*atomic.Uint64),
 		metrics:     getMetrics(),
-		getPool:     make(chan *getPoolRequest),
-		repPool:     make(chan *Pool),
-		getStats:    make(chan clientID),
+		getStats:    make(chan *statsQuery),
 		repStats:    make(chan *Stats),
+		getState:    make(chan struct{}),
+		repState:    make(chan *State),
+		getClients:  make(chan chan []*ClientInfo),
+		getHistory:  make(chan struct{}),
+		repHistory:  make(chan []*HistoryPoint),
+		disconnect:  make(chan *disconnect),
+		history:     newHistory(config.StatsHistory),
+		tracer:      newTracer(config),
+		noPools:     noPools,
+		errorPages:  errorPages,
+		forward:     forwardClient(),
+		buffers:     newCopyBuffers(config.CopyBufferSize),
 	}
 }
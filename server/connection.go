@@ -1,13 +1,17 @@
 package server
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"runtime/debug"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"golift.io/mulery/mulch"
 )
 
 // ConnectionStatus is an enumeration that represents the status of WebSocket connection.
@@ -29,22 +33,27 @@ type Connection struct {
 	sock      *websocket.Conn
 	status    ConnectionStatus
 	idleSince time.Time
+	expires   time.Time // when the secret key used to register this connection expires.
+	framed    bool      // the client reads and writes mulch.Frame envelopes.
+	streamID  uint32    // the current request's frame stream ID; only used by the request go routine.
 	lock      sync.RWMutex
 	requests  int
-	// nextResponse is the channel to wait for an HTTP response.
+	// Request body bytes sent to, and response body bytes received from, the peer.
+	bytesSent atomic.Int64
+	bytesRecv atomic.Int64
+	// nextReader is how the read() go routine hands a websocket message reader to the "server" thread.
 	//
 	// The `read` function waits to receive the HTTP response as a separate thread reader.
 	// (See https://github.com/hgsgtk/wsp/blob/29cc73bbd67de18f1df295809166a7a5ef52e9fa/server/connection.go#L56 )
 	//
 	// When a "server" thread proxies, it sends the HTTP request to the peer over the WebSocket,
-	// and sends the channel of the io.Reader interface (chan io.Reader) that can read the HTTP
-	// response to the field `nextResponse`, then waits until the value is written in the channel
-	// (chan io.Reader) by another thread "reader".
-	//
-	// After the thread "reader" detects that the HTTP response from the peer of the WebSocket connection has been written,
-	// it sends the value to the channel (chan io.Reader),
-	// and the "server" thread can proceed to process the rest of its procedures.
-	nextResponse chan chan io.Reader
+	// and waits to receive a reader for the response from `nextReader`. When it is done with
+	// the reader, it sends to `doneReader`, and read() takes back control of the websocket.
+	// These channels are reused for every message, so a request does not allocate any channels.
+	nextReader chan io.Reader
+	doneReader chan struct{}
+	// closed is closed when the connection is closed. This unblocks both sides of the hand off.
+	closed chan struct{}
 }
 
 func (c ConnectionStatus) String() string {
@@ -63,14 +72,26 @@ func (c ConnectionStatus) String() string {
 // NewConnection returns a new Connection.
 // Each connection gets a go routine to read (wait for) messages.
 func NewConnection(pool *Pool, sock *websocket.Conn) *Connection {
+	return newConnection(pool, sock, time.Time{}, false)
+}
+
+// newConnection sets every field before the connection is idle, because a dispatcher may take it right away.
+func newConnection(pool *Pool, sock *websocket.Conn, expires time.Time, framed bool) *Connection {
 	// Initialize a new Connection.
 	conn := &Connection{
-		connected:    time.Now(),
-		status:       Idle,
-		pool:         pool,
-		sock:         sock,
-		nextResponse: make(chan chan io.Reader),
+		connected:  time.Now(),
+		status:     Idle,
+		pool:       pool,
+		sock:       sock,
+		expires:    expires,
+		framed:     framed,
+		nextReader: make(chan io.Reader),
+		doneReader: make(chan struct{}),
+		closed:     make(chan struct{}),
 	}
+	// Clients report backend health in ping frames.
+	sock.SetPingHandler(conn.ping)
+	sock.SetCloseHandler(conn.peerClose)
 	// Mark connection as ready for use.
 	conn.Give()
 	// Start listening for incoming messages over the WebSocket connection.
@@ -87,13 +108,12 @@ func (c *Connection) read() {
 			c.pool.Errorf("Websocket crash recovered: %s\n%s", r, string(debug.Stack()))
 		}
 
-		c.Close("remote hang up")
+		c.Close(mulch.CloseHangUp, "remote hang up")
 	}()
 
 	var (
 		err    error
 		reader io.Reader
-		resp   chan io.Reader
 	)
 
 	for {
@@ -121,20 +141,67 @@ func (c *Connection) read() {
 
 		// When it gets here, it is expected that either a HttpResponse or a HttpResponseBody has been returned.
 		//
-		// Next, it waits to receive the value from the Connection.proxyRequest function.
+		// Next, it sends the reader to the Connection.proxyRequest function.
 		// that is invoked in the "server" thread.
 		// https://github.com/hgsgtk/wsp/blob/29cc73bbd67de18f1df295809166a7a5ef52e9fa/server/connection.go#L157
-		if resp = <-c.nextResponse; resp == nil {
+		select {
+		case c.nextReader <- reader:
+		case <-c.closed:
 			return // We have been unlocked by Close().
 		}
 
-		// Send the reader back to Connection.proxyRequest.
-		resp <- reader
+		// Wait for proxyRequest to finish with the reader.
+		select {
+		case <-c.doneReader: // Start the loop over, and take back control of the ws reader.
+		case <-c.closed:
+			return
+		}
+	}
+}
+
+// ping saves the client's health report, and replies with a pong like the default ping handler.
+// A drain request closes the connection instead, unless a request was already sent on it.
+func (c *Connection) ping(payload string) error {
+	if payload == mulch.DrainPayload && c.drain() {
+		return nil
+	}
+
+	c.pool.setHealth(payload)
 
-		// Wait for proxyRequest to close the channel.
-		// This notifies that it is done with the reader.
-		<-resp // Start the loop over, and take back control of the ws reader.
+	err := c.sock.WriteControl(websocket.PongMessage, []byte(payload), time.Now().Add(time.Second))
+	if errors.Is(err, websocket.ErrCloseSent) {
+		return nil
 	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return nil
+	}
+
+	return err //nolint:wrapcheck // this is returned to the websocket library.
+}
+
+// drain closes an idle connection with mulch.CloseDrain when the client asks to retire it, and returns true.
+// The connection is marked closed under the lock, so a dispatcher cannot take it after that. A busy
+// connection was given a request before the client asked, so it is kept, and the client reads the pong.
+func (c *Connection) drain() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.status != Idle {
+		return false
+	}
+
+	c.close(mulch.CloseDrain, "client drained connection")
+
+	return true
+}
+
+// peerClose is called by the websocket library when the client sends a close frame.
+// The connection is marked closed first, so a dispatcher cannot take it from the idle buffer after that.
+func (c *Connection) peerClose(int, string) error {
+	c.Close(mulch.CloseHangUp, "remote hang up")
+	return nil
 }
 
 func (c *Connection) Status() ConnectionStatus {
@@ -154,14 +221,17 @@ func (c *Connection) Take() *Connection {
 	c.requests++
 
 	if c.status == Idle {
-		c.pool.Debugf("Taking connection from idle buffer pool %s [%s]", c.pool.id, c.sock.RemoteAddr())
+		if c.pool.IsDebug() {
+			c.pool.Debugf("Taking connection from idle buffer pool %s [%s]", c.pool.id, c.sock.RemoteAddr())
+		}
+
 		c.status = Busy
 
 		return c
 	}
 
 	// This happens once in a while, and is not a real error condition.
-	c.pool.Errorf("Tried to Take() invalid connection (%s) from idle buffer pool %s", c.status, c.pool.id)
+	c.pool.Warnf("Tried to Take() invalid connection (%s) from idle buffer pool %s", c.status, c.pool.id)
 
 	return nil
 }
@@ -179,41 +249,60 @@ func (c *Connection) Give() {
 		return
 	}
 
-	c.pool.Debugf("Giving connection to idle buffer pool %s [%s]", c.pool.id, c.sock.RemoteAddr())
-
-	c.idleSince = time.Now()
-	c.status = Idle
+	if closing := c.pool.closing.Load(); closing != nil {
+		c.close(closing.reason, closing.text)
+		return
+	}
 
-	// Avoid blocking on the channel write below, or the server deadlocks.
-	j := cap(c.pool.idle)
-	if i := len(c.pool.idle); i >= j {
-		c.close(fmt.Sprintf("idle buffer pool %d at capacity %d, too many connections", i, j))
+	if c.keyExpired(time.Now()) {
+		c.close(mulch.CloseAuthExpired, "key expired")
 		return
 	}
 
+	if c.pool.IsDebug() {
+		c.pool.Debugf("Giving connection to idle buffer pool %s [%s]", c.pool.id, c.sock.RemoteAddr())
+	}
+
+	c.idleSince = time.Now()
+	c.status = Idle
+
 	// Stick this connection into the idle buffer pool.
-	c.pool.idle <- c
+	// Avoid blocking on the channel write, or the server deadlocks.
+	if !c.pool.putIdle(c) {
+		c.close(mulch.CloseCapacity,
+			fmt.Sprintf("idle buffer pool at capacity %d, too many connections", cap(c.pool.idleChan())))
+	}
 }
 
 // Close the connection.
-func (c *Connection) Close(reason string) {
+// The reason is sent to the peer in a close frame, and counted in metrics.
+func (c *Connection) Close(reason mulch.CloseReason, detail string) {
 	c.lock.Lock()
 	defer c.lock.Unlock()
-	c.close(reason)
+	c.close(reason, detail)
 }
 
 // Close the connection (without lock).
-func (c *Connection) close(reason string) {
+func (c *Connection) close(reason mulch.CloseReason, detail string) {
 	if c.status == Closed {
 		return
 	}
 
-	c.pool.Printf("Closing connection from %s [%s], connected: %s, requests: %d, reason: %s",
-		c.pool.id, c.sock.RemoteAddr(), time.Since(c.connected).Round(time.Second), c.requests, reason)
-	// Unlock a possible wild read() message.
-	close(c.nextResponse)
+	c.pool.Printf("Closing connection from %s [%s], connected: %s, requests: %d, reason: %s (%s)",
+		c.pool.id, c.sock.RemoteAddr(), time.Since(c.connected).Round(time.Second), c.requests, reason, detail)
+	audit(c.pool.auditor, AuditDisconnect, string(c.pool.cid), c.pool.Handshake().Name,
+		c.sock.RemoteAddr().String(), string(reason)+": "+detail)
+	c.pool.metrics.addClose(reason)
+	// Unlock a possible wild read() message, or a waiting proxyRequest.
+	close(c.closed)
+
 	// Close the underlying TCP connection.
-	c.sock.Close()
+	if reason == mulch.CloseHangUp {
+		c.sock.Close() // nobody to tell.
+	} else {
+		_ = mulch.CloseWithCode(c.sock, reason, detail)
+	}
+
 	// This must be executed *before* lock.Unlock().
 	c.status = Closed
 }
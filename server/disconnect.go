@@ -0,0 +1,84 @@
+package server
+
+import (
+	"golift.io/mulery/mulch"
+)
+
+// poolClose is the reason every connection in a pool is closed with, once it is idle.
+type poolClose struct {
+	reason mulch.CloseReason
+	text   string
+}
+
+// disconnect asks the dispatcher to close one pool, or every pool if client is empty.
+type disconnect struct {
+	client clientID
+	close  *poolClose
+	reply  chan int
+}
+
+// Disconnect closes every connection from a client with a reason the client understands. Use
+// mulch.CloseBanned to ban a client, or mulch.CloseAuthRevoked after revoking its key; clients
+// stop reconnecting to this server when they receive either. Idle connections close now, and
+// busy connections close when their request finishes. New connections from the client are closed
+// the same way until the pool is empty and removed. Returns false if the client is not connected.
+func (s *Server) Disconnect(poolID string, reason mulch.CloseReason, text string) bool {
+	if poolID == "" {
+		return false
+	}
+
+	reply := make(chan int)
+	s.disconnect <- &disconnect{client: clientID(poolID), close: &poolClose{reason, text}, reply: reply}
+
+	return <-reply > 0
+}
+
+// Drain closes every client connection with mulch.CloseDrain, so clients connect to another server.
+// Idle connections close now, and busy connections close when their request finishes.
+// New registrations are refused with the same reason until Shutdown. Returns the number of pools drained.
+func (s *Server) Drain(text string) int {
+	s.draining.Store(true)
+
+	reply := make(chan int)
+	s.disconnect <- &disconnect{close: &poolClose{mulch.CloseDrain, text}, reply: reply}
+
+	return <-reply
+}
+
+// disconnectPools runs in the dispatcher go routine, so pools are not removed while it closes them.
+func (s *Server) disconnectPools(request *disconnect) {
+	if request.client != "" {
+		if pool := s.pools.get(request.client); pool != nil {
+			pool.closeAll <- request.close
+			request.reply <- 1
+		} else {
+			request.reply <- 0
+		}
+
+		return
+	}
+
+	pools := s.pools.snapshot()
+	for _, pool := range pools {
+		pool.closeAll <- request.close
+	}
+
+	request.reply <- len(pools)
+}
+
+// disconnectAll closes every idle connection in the pool. Busy connections are closed by Give.
+// This runs in the pool's go routine.
+func (pool *Pool) disconnectAll(closing *poolClose) {
+	pool.closing.Store(closing)
+	pool.Printf("Closing all connections from %s, reason: %s (%s)", pool.id, closing.reason, closing.text)
+
+	for _, connection := range pool.connections {
+		connection.lock.Lock()
+		if connection.status == Idle {
+			connection.close(closing.reason, closing.text)
+		}
+		connection.lock.Unlock()
+	}
+
+	pool.clean()
+}
@@ -0,0 +1,182 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	"golift.io/mulery/mulch"
+)
+
+// Tunnel failure reasons passed to error page templates in ErrorPageData.Reason.
+const (
+	ErrorOffline  = "offline"  // The client is not connected.
+	ErrorTimeout  = "timeout"  // The client did not respond in time.
+	ErrorRefused  = "refused"  // The client's backend refused the connection.
+	ErrorFailed   = "failed"   // The request failed inside the tunnel.
+	ErrorDegraded = "degraded" // The client reported its backend is unhealthy.
+)
+
+// ErrorPage is a branded response for tunnel failures.
+// HTML is rendered with html/template, and JSON with text/template; both get ErrorPageData.
+// The string fields given to JSON templates are escaped to go inside quotes, like "{{.Error}}".
+// JSON is sent to requesters that accept application/json, and HTML to everyone else.
+// If only one template is provided, it is always used.
+type ErrorPage struct {
+	HTML string `json:"html" toml:"html" yaml:"html" xml:"html"`
+	JSON string `json:"json" toml:"json" yaml:"json" xml:"json"`
+}
+
+// ErrorPageData is the data passed into the ErrorPage templates.
+type ErrorPageData struct {
+	Status   int
+	Reason   string // offline, degraded, timeout, refused or failed.
+	Error    string
+	ClientID string
+	Method   string
+	URL      string
+	Time     time.Time
+}
+
+// executer is satisfied by text/template and html/template.
+type executer interface {
+	Execute(w io.Writer, data any) error
+}
+
+// errorPage contains the parsed templates for an ErrorPage.
+type errorPage struct {
+	html executer
+	json executer
+}
+
+// parseErrorPages parses every error page template. The key is a client ID, or * for all clients.
+// Pages that fail to parse are left out, and the first error is returned.
+func parseErrorPages(pages map[string]*ErrorPage) (map[string]*errorPage, error) {
+	var (
+		parsed   = make(map[string]*errorPage, len(pages))
+		firstErr error
+	)
+
+	for cID, page := range pages {
+		if page == nil || (page.HTML == "" && page.JSON == "") {
+			continue
+		}
+
+		tmpl, err := page.parse(cID)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		} else if err == nil {
+			parsed[cID] = tmpl
+		}
+	}
+
+	return parsed, firstErr
+}
+
+func (p *ErrorPage) parse(cID string) (*errorPage, error) {
+	page := &errorPage{}
+
+	if p.HTML != "" {
+		tmpl, err := htmltemplate.New(cID).Parse(p.HTML)
+		if err != nil {
+			return nil, fmt.Errorf("parsing error page html template for '%s': %w", cID, err)
+		}
+
+		page.html = tmpl
+	}
+
+	if p.JSON != "" {
+		tmpl, err := template.New(cID).Parse(p.JSON)
+		if err != nil {
+			return nil, fmt.Errorf("parsing error page json template for '%s': %w", cID, err)
+		}
+
+		page.json = tmpl
+	}
+
+	return page, nil
+}
+
+// jsonEscaped returns a copy of the data with every string escaped for use inside a JSON string.
+func (d ErrorPageData) jsonEscaped() *ErrorPageData {
+	for _, field := range []*string{&d.Reason, &d.Error, &d.ClientID, &d.Method, &d.URL} {
+		escaped, _ := json.Marshal(*field)
+		*field = string(escaped[1 : len(escaped)-1])
+	}
+
+	return &d
+}
+
+// errorReason turns a proxy error into an error page reason.
+func errorReason(err error) string {
+	var clientErr *mulch.ErrorFrame
+
+	switch {
+	case errors.As(err, &clientErr) && clientErr.Kind == mulch.ErrorRefused:
+		return ErrorRefused
+	case errors.As(err, &clientErr) && clientErr.Kind == mulch.ErrorTimeout:
+		return ErrorTimeout
+	case errors.Is(err, ErrNoProxyTarget):
+		return ErrorOffline
+	case errors.Is(err, ErrDegraded):
+		return ErrorDegraded
+	case errors.Is(err, context.DeadlineExceeded):
+		return ErrorTimeout
+	default:
+		return ErrorFailed
+	}
+}
+
+// writeError sends a tunnel failure to the requester, using the client's error page if one is configured.
+func (s *Server) writeError(resp http.ResponseWriter, req *http.Request, err error, status int) {
+	if !s.writeErrorPage(resp, req, err, status) {
+		http.Error(resp, err.Error(), status)
+	}
+}
+
+// writeErrorPage sends a tunnel failure to the requester with the client's error page.
+// Returns false, and writes nothing, if there is no error page for the client.
+func (s *Server) writeErrorPage(resp http.ResponseWriter, req *http.Request, err error, status int) bool {
+	cID := req.Header.Get(s.Config.IDHeader)
+
+	page := s.errorPages[cID]
+	if page == nil {
+		page = s.errorPages["*"]
+	}
+
+	if page == nil {
+		return false
+	}
+
+	data := &ErrorPageData{
+		Status:   status,
+		Reason:   errorReason(err),
+		Error:    err.Error(),
+		ClientID: cID,
+		Method:   req.Method,
+		URL:      req.URL.String(),
+		Time:     time.Now(),
+	}
+
+	tmpl, contentType := page.html, "text/html; charset=utf-8"
+	if page.json != nil && (tmpl == nil || strings.Contains(req.Header.Get("Accept"), "application/json")) {
+		tmpl, contentType, data = page.json, "application/json", data.jsonEscaped()
+	}
+
+	resp.Header().Set("Content-Type", contentType)
+	resp.Header().Set("X-Content-Type-Options", "nosniff")
+	resp.WriteHeader(status)
+
+	if err := tmpl.Execute(resp, data); err != nil {
+		s.Config.Logger.Errorf("Executing error page template for '%s': %v", cID, err)
+	}
+
+	return true
+}
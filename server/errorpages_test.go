@@ -0,0 +1,64 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golift.io/mulery/mulch"
+)
+
+func TestErrorPageJSON(t *testing.T) {
+	t.Parallel()
+
+	pages, err := parseErrorPages(map[string]*ErrorPage{
+		"*": {JSON: `{"error": "{{.Error}}", "reason": "{{.Reason}}", "url": "{{.URL}}"}`},
+	})
+	if err != nil {
+		t.Fatalf("parsing error pages: %v", err)
+	}
+
+	srv := &Server{Config: &Config{IDHeader: "X-Client-Id", Logger: &mulch.DefaultLogger{Silent: true}}, errorPages: pages}
+	message := "backend said \"no\" \\ <b>\n\ttab"
+	req := httptest.NewRequest(http.MethodGet, `/path?q="quoted"`, nil)
+	resp := httptest.NewRecorder()
+
+	if !srv.writeErrorPage(resp, req, mulch.NewErrorFrame(mulch.ErrorRefused, message), http.StatusBadGateway) {
+		t.Fatal("error page was not written")
+	}
+
+	var body map[string]string
+	if err := json.Unmarshal(resp.Body.Bytes(), &body); err != nil {
+		t.Fatalf("error page is not valid JSON: %v: %s", err, resp.Body)
+	}
+
+	if body["error"] != "refused: "+message || body["reason"] != ErrorRefused || body["url"] != req.URL.String() {
+		t.Errorf("got %v", body)
+	}
+}
+
+func TestErrorReason(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		err    error
+		reason string
+	}{
+		{err: fmt.Errorf("wrapped: %w", ErrNoProxyTarget), reason: ErrorOffline},
+		{err: ErrDegraded, reason: ErrorDegraded},
+		{err: context.DeadlineExceeded, reason: ErrorTimeout},
+		{err: mulch.NewErrorFrame(mulch.ErrorRefused, "refused"), reason: ErrorRefused},
+		{err: mulch.NewErrorFrame(mulch.ErrorTimeout, "timeout"), reason: ErrorTimeout},
+		{err: mulch.NewErrorFrame(mulch.ErrorDNS, "no such host"), reason: ErrorFailed},
+		{err: fmt.Errorf("%w: connection reset", ErrInvalidData), reason: ErrorFailed},
+	}
+
+	for _, test := range tests {
+		if got := errorReason(test.err); got != test.reason {
+			t.Errorf("%v: got %s, want %s", test.err, got, test.reason)
+		}
+	}
+}
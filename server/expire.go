@@ -0,0 +1,70 @@
+package server
+
+import (
+	"time"
+
+	"golift.io/mulery/mulch"
+)
+
+// armExpiry sets the pool's key expiry timer to the earliest key expiry of its connections.
+// A zero expires never expires. This runs in the pool's go routine.
+func (pool *Pool) armExpiry(expires time.Time) {
+	if expires.IsZero() || (!pool.expires.IsZero() && !expires.Before(pool.expires)) {
+		return
+	}
+
+	if pool.expiry != nil {
+		pool.expiry.Stop()
+	}
+
+	pool.expires = expires
+	pool.expiry = time.NewTimer(time.Until(expires))
+}
+
+// expired returns the channel the key expiry timer fires on, or nil if no key expires.
+func (pool *Pool) expired() <-chan time.Time {
+	if pool.expiry == nil {
+		return nil
+	}
+
+	return pool.expiry.C
+}
+
+// keyExpired closes every connection registered with the key that expired. Idle connections close now,
+// and busy connections close when their request finishes. OnKeyExpire and the auditor are called once.
+// Connections registered with a newer key stay open, and the timer is armed for their expiry.
+// This runs in the pool's go routine.
+func (pool *Pool) keyExpired() {
+	expired := pool.expires
+	pool.expires, pool.expiry = time.Time{}, nil
+
+	pool.Printf("Key for %s expired at %v, closing its connections", pool.id, expired)
+	audit(pool.auditor, AuditKeyExpired, string(pool.cid), pool.Handshake().Name, "", "expired "+expired.String())
+
+	if pool.onExpire != nil {
+		go pool.onExpire(pool.id, expired)
+	}
+
+	for _, connection := range pool.connections {
+		connection.lock.Lock()
+
+		switch {
+		case connection.status == Closed:
+		case connection.keyExpired(expired):
+			if connection.status == Idle {
+				connection.close(mulch.CloseAuthExpired, "key expired")
+			}
+		default:
+			pool.armExpiry(connection.expires)
+		}
+
+		connection.lock.Unlock()
+	}
+
+	pool.clean()
+}
+
+// keyExpired returns true if the connection was registered with a key that expired at or before now.
+func (c *Connection) keyExpired(now time.Time) bool {
+	return !c.expires.IsZero() && !c.expires.After(now)
+}
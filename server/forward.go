@@ -0,0 +1,123 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"golift.io/mulery/mulch"
+)
+
+// ErrForward is returned when a request cannot be forwarded to another server.
+var ErrForward = errors.New("forwarding request to peer failed")
+
+// hopHeaders are removed from forwarded requests and responses.
+// These apply to one connection, not the whole trip.
+//
+//nolint:gochecknoglobals
+var hopHeaders = []string{
+	"Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization",
+	"Proxy-Connection", "Te", "Trailer", "Transfer-Encoding", "Upgrade",
+}
+
+// ForwardRequest sends an upstream request to another mulery server's request endpoint,
+// like https://mulery2.example.com/request, for the provided client ID.
+// Headers are preserved, the request body is streamed, and the request's deadline is sent
+// along in the timeout header. The caller must close the response body.
+// Use WriteForwarded to send the response to the upstream requester.
+func (s *Server) ForwardRequest(peerURL, clientID string, req *http.Request) (*http.Response, error) {
+	target := strings.TrimSuffix(peerURL, "/") + "/" + strings.TrimPrefix(req.URL.EscapedPath(), "/")
+	if req.URL.RawQuery != "" {
+		target += "?" + req.URL.RawQuery
+	}
+
+	body := req.Body
+	if req.ContentLength == 0 {
+		body = nil // avoid sending a chunked empty body.
+	}
+
+	out, err := http.NewRequestWithContext(req.Context(), req.Method, target, body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: creating request: %w", ErrForward, err)
+	}
+
+	out.ContentLength = req.ContentLength
+	out.Header = req.Header.Clone()
+	removeHopHeaders(out.Header)
+
+	if s.Config.IDHeader != "" {
+		out.Header.Set(s.Config.IDHeader, clientID)
+	}
+
+	if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		if prior := out.Header.Get("X-Forwarded-For"); prior != "" {
+			host = prior + ", " + host
+		}
+
+		out.Header.Set("X-Forwarded-For", host)
+	}
+
+	if deadline, ok := req.Context().Deadline(); ok {
+		out.Header.Set(mulch.TimeoutHeader, time.Until(deadline).String())
+	}
+
+	resp, err := s.forward.Do(out)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrForward, err)
+	}
+
+	return resp, nil
+}
+
+// WriteForwarded copies a response from ForwardRequest to the upstream requester, and closes its body.
+// Returns the number of body bytes copied.
+func WriteForwarded(resp http.ResponseWriter, forwarded *http.Response) (int64, error) {
+	defer forwarded.Body.Close()
+
+	header := resp.Header()
+	for key, values := range forwarded.Header {
+		header[key] = append(header[key], values...)
+	}
+
+	removeHopHeaders(header)
+	resp.WriteHeader(forwarded.StatusCode)
+
+	size, err := io.Copy(resp, forwarded.Body)
+	if err != nil {
+		return size, fmt.Errorf("copying forwarded response body: %w", err)
+	}
+
+	return size, nil
+}
+
+func removeHopHeaders(header http.Header) {
+	// Also remove headers named in the Connection header.
+	for _, value := range header.Values("Connection") {
+		for _, name := range strings.Split(value, ",") {
+			header.Del(strings.TrimSpace(name))
+		}
+	}
+
+	for _, name := range hopHeaders {
+		header.Del(name)
+	}
+}
+
+// forwardClient returns the http client used to forward requests to other servers.
+// There is no client timeout; the forwarded request ends when the upstream request does.
+func forwardClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone() //nolint:forcetypeassert
+	transport.MaxIdleConnsPerHost = maxIdleForwards
+
+	return &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		Transport:     transport,
+	}
+}
+
+// maxIdleForwards is how many idle connections to keep open to each peer server.
+const maxIdleForwards = 32
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/websocket"
+	"golift.io/mulery/mulch"
+)
+
+// writeRequest sends a serialized request. Framed connections get a new stream ID for every request.
+func (c *Connection) writeRequest(jsonReq []byte) error {
+	if !c.framed {
+		return c.sock.WriteMessage(websocket.TextMessage, jsonReq) //nolint:wrapcheck // the caller wraps it.
+	}
+
+	c.streamID++
+
+	return mulch.WriteFrame(c.sock, &mulch.Frame{Type: mulch.FrameRequest, StreamID: c.streamID, Payload: jsonReq})
+}
+
+// nextBodyWriter returns a writer for the request body message.
+func (c *Connection) nextBodyWriter(header http.Header) (io.WriteCloser, error) {
+	if !c.framed {
+		return mulch.NextBodyWriter(c.sock, c.pool.compress, header)
+	}
+
+	return mulch.NextFrameWriter(c.sock, c.pool.compress, header, mulch.FrameBody, c.streamID)
+}
+
+// readResponseFrame reads the frame header from a response message on a framed connection.
+// A *mulch.ErrorFrame is returned if the client sent an error frame.
+func (c *Connection) readResponseFrame(reader io.Reader) error {
+	if !c.framed {
+		return nil
+	}
+
+	typ, streamID, err := mulch.ReadFrameHeader(reader)
+	if err != nil {
+		return err //nolint:wrapcheck // it is already descriptive.
+	}
+
+	switch {
+	case streamID != c.streamID:
+		return fmt.Errorf("%w: %s for stream %d, expected stream %d", mulch.ErrUnexpectedFrame, typ, streamID, c.streamID)
+	case typ == mulch.FrameResponse:
+		return nil
+	case typ != mulch.FrameError:
+		return fmt.Errorf("%w: %s for stream %d, expected a response", mulch.ErrUnexpectedFrame, typ, streamID)
+	}
+
+	limit := c.pool.maxFrame
+	if limit <= 0 {
+		limit = mulch.DefaultMaxFrameSize
+	}
+
+	message, err := io.ReadAll(io.LimitReader(reader, limit))
+	if err != nil {
+		return fmt.Errorf("reading error frame: %w", err)
+	}
+
+	return mulch.ParseErrorFrame(message)
+}
+
+// expectBody reads the frame header from a response body message on a framed connection.
+func (c *Connection) expectBody(reader io.Reader) error {
+	if !c.framed {
+		return nil
+	}
+
+	return mulch.ExpectFrame(reader, mulch.FrameBody, c.streamID) //nolint:wrapcheck // it is already descriptive.
+}
+
+// sendClientError answers the request with the status code for the client's error, and its message,
+// or the client's error page if one is configured. Older clients send a 527 response with the message instead.
+func (c *Connection) sendClientError(resp http.ResponseWriter, req *http.Request, frame *mulch.ErrorFrame) int {
+	c.pool.metrics.addClientError(frame.Kind)
+	resp.Header().Set(mulch.ErrorKindHeader, string(frame.Kind))
+
+	if c.pool.errorPage != nil && c.pool.errorPage(resp, req, frame, frame.Code) {
+		return frame.Code
+	}
+
+	resp.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	resp.Header().Set("Content-Length", strconv.Itoa(len(frame.Message)))
+	resp.WriteHeader(frame.Code)
+	_, _ = io.WriteString(resp, frame.Message)
+
+	return frame.Code
+}
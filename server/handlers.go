@@ -1,37 +1,38 @@
 package server
 
 import (
-	"compress/flate"
 	"context"
-	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/url"
+	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"golift.io/mulery/mulch"
 )
 
 // ProxyError log error and return a HTTP 526 error with the message.
 func (s *Server) ProxyError(resp http.ResponseWriter, req *http.Request, err error, regFail string) {
+	logger := mulch.With(s.Config.Logger, "remote", req.RemoteAddr, "method", req.Method, "url", req.URL.String())
 	if regFail != "" {
-		s.Config.Logger.Errorf("[%s] Registration failed: %v", req.RemoteAddr, err)
+		logger.Errorf("[%s] Registration failed: %v", req.RemoteAddr, err)
+	} else if requestID := req.Header.Get(mulch.RequestIDHeader); requestID != "" {
+		mulch.With(logger, "request_id", requestID).Errorf("[%s] Request %s failed: %v", req.RemoteAddr, requestID, err)
 	} else {
-		s.Config.Logger.Errorf("[%s] Request failed: %v", req.RemoteAddr, err)
+		logger.Errorf("[%s] Request failed: %v", req.RemoteAddr, err)
 	}
 
 	if regFail != "" && s.metrics != nil {
 		s.metrics.Regs.WithLabelValues(regFail).Add(1)
 	}
 
-	if regFail == "" { // cannot send http responses to a hijacked connection.
-		http.Error(resp, err.Error(), mulch.ProxyErrorCode)
+	if s.tracer != nil {
+		fail(trace.SpanFromContext(req.Context()), err)
 	}
-}
 
-func (s *Server) HandleStats(resp http.ResponseWriter, req *http.Request) {
-	s.getStats <- clientID(req.Header.Get(s.Config.IDHeader))          // ask for stats.
-	if err := json.NewEncoder(resp).Encode(<-s.repStats); err != nil { // send stats
-		http.Error(resp, err.Error(), http.StatusInternalServerError) // oops, error.
+	if regFail == "" { // cannot send http responses to a hijacked connection.
+		s.writeError(resp, req, err, mulch.ProxyErrorCode)
 	}
 }
 
@@ -42,56 +43,90 @@ func (s *Server) HandleRequest(name string) http.Handler {
 	}
 
 	return s.metrics.Wrap(func(resp http.ResponseWriter, req *http.Request) {
+		req, span := s.tracer.start(req, name)
+		defer span.End()
+
+		// The upstream caller may limit how long we (and the client) work on this request.
+		req, cancel := mulch.WithTimeout(req, mulch.ParseTimeout(req.Header.Get(mulch.TimeoutHeader)))
+		defer cancel()
+
+		// Honor the upstream caller's request ID, and pass it to the client and backend.
+		requestID := mulch.RequestID(req.Header)
+		req.Header.Set(mulch.RequestIDHeader, requestID)
+		resp.Header().Set(mulch.RequestIDHeader, requestID)
+		span.SetAttributes(attribute.String("mulery.request_id", requestID))
+
+		event := &RequestEvent{Method: req.Method, URL: req.URL.String(), Start: time.Now(), RequestID: requestID}
+		reqError := func(err error) {
+			s.ProxyError(resp, req, err, "")
+			s.observeError(event, err)
+		}
+
 		// Receive requests to be proxied; parse destination URL if it exists (otherwise use the incoming url).
 		if dstURL := req.Header.Get("X-PROXY-DESTINATION"); dstURL != "" {
 			var err error
 			// r.URL is used in proxyRequest().
 			if req.URL, err = url.Parse(dstURL); err != nil {
-				s.ProxyError(resp, req, fmt.Errorf("parsing X-PROXY-DESTINATION header: %w", err), "")
+				reqError(fmt.Errorf("parsing X-PROXY-DESTINATION header: %w", err))
 				return
 			}
+
+			event.URL = req.URL.String()
 		}
 
-		if len(s.pools) == 0 {
-			s.ProxyError(resp, req, fmt.Errorf("%w: no pools registered", ErrNoProxyTarget), "")
+		if s.pools.len() == 0 {
+			switch {
+			case s.fallback(resp, req): // another server handled it.
+			case s.handleNoPools(resp, req):
+				s.observeError(event, fmt.Errorf("%w: no pools registered", ErrNoProxyTarget))
+			default:
+				reqError(fmt.Errorf("%w: no pools registered", ErrNoProxyTarget))
+			}
+
 			return
 		}
 
 		clientID, err := s.getClientID(req)
 		if err != nil {
-			s.ProxyError(resp, req, err, "")
+			reqError(err)
+			return
+		}
+
+		event.ClientID = string(clientID)
+		span.SetAttributes(attribute.String("mulery.client_id", string(clientID)))
+
+		connection, degraded := s.getConnection(req.Context(), clientID)
+		if connection == nil && degraded {
+			// The client told us its backend is down, so we did not send it this request.
+			reqError(fmt.Errorf("%w: %s", ErrDegraded, clientID))
 			return
 		}
 
-		request := &dispatchRequest{
-			connection: make(chan *Connection), // do not close this here.
-			client:     clientID,
+		if connection == nil && !degraded && req.Context().Err() == nil && s.fallback(resp, req) {
+			return // The target has no pool here, and the fallback handled the request.
 		}
 
-		// "Dispatcher" is running in a separate thread from the server by `go s.DispatchConnections()`.
-		// It waits to receive requests to dispatch connections from available pools to http-clients' requests.
-		// https://github.com/hgsgtk/wsp/blob/ea4902a8e11f820268e52a6245092728efeffd7f/server/server.go#L93
-		s.dispatcher <- request
-		// Dispatcher tries to find an available connection pool,
-		// and it returns the connection through Server.connection channel.
-		// https://github.com/hgsgtk/wsp/blob/ea4902a8e11f820268e52a6245092728efeffd7f/server/server.go#L189
-		// Wait briefly for the dispatcher to return a websocket connection.
-		connection := <-request.connection
 		if connection == nil {
 			// Dispatcher is `nil` which means the target has no pool.
-			s.ProxyError(resp, req, fmt.Errorf("%w: %s", ErrNoProxyTarget, request.client), "")
+			reqError(fmt.Errorf("%w: %s", ErrNoProxyTarget, clientID))
 			return
 		}
 
+		s.observeDispatch(event)
+
 		// Send the incoming http request to the peer through the WebSocket connection.
-		if err := connection.proxyRequest(resp, req); err != nil {
+		if err := connection.proxyRequest(resp, req, event); err != nil {
 			// An error occurred throw the connection away.
 			// This most commonly happens when the requester gives up waiting for the request (client-side timeout elapses).
-			connection.Close(fmt.Sprintf("proxy error: %v", err))
+			connection.Close(mulch.CloseProxyError, err.Error())
 			// Try to return an error to the client.
 			// This might fail if response headers have already been sent.
-			s.ProxyError(resp, req, fmt.Errorf("tunneling failure, connection closed: %w", err), "")
+			reqError(fmt.Errorf("tunneling failure, connection closed: %w", err))
+
+			return
 		}
+
+		s.observeResponse(event)
 	}, name)
 }
 
@@ -100,8 +135,9 @@ func (s *Server) HandleRequest(name string) http.Handler {
 func (s *Server) HandleRegister() http.Handler {
 	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
 		// 0. Validate the provided secret key.
-		secret, err := s.validateKey(req.Context(), req.Header)
+		secret, expires, err := s.validateKey(req.Context(), req.Header)
 		if err != nil {
+			audit(s.Config.Auditor, AuditKeyFailed, "", "", req.RemoteAddr, err.Error())
 			s.ProxyError(resp, req, err, "keyFailed")
 			return
 		}
@@ -113,21 +149,37 @@ func (s *Server) HandleRegister() http.Handler {
 			return
 		}
 
-		sock.EnableWriteCompression(true)
-		_ = sock.SetCompressionLevel(flate.BestSpeed)
+		if !s.Config.DisableCompression {
+			sock.EnableWriteCompression(true)
+			_ = sock.SetCompressionLevel(mulch.CompressionLevel(s.Config.CompressionLevel))
+		}
 
 		// 2. Wait for a greeting message from the peer and parse it.
 		// The first message should contain the remote Proxy name and pool size.
 		var greeting mulch.Handshake
 		if err := sock.ReadJSON(&greeting); err != nil {
 			s.ProxyError(resp, req, fmt.Errorf("unable to read greeting message: %w", err), "greetingFailed")
-			sock.Close()
+			_ = mulch.CloseWithCode(sock, mulch.CloseProtocolError, "unable to read greeting message")
+
+			return
+		}
+
+		if s.draining.Load() {
+			s.ProxyError(resp, req, ErrDraining, "draining")
+			_ = mulch.CloseWithCode(sock, mulch.CloseDrain, ErrDraining.Error())
+
+			return
+		}
+
+		if s.Config.RequireFrames && !greeting.Frames {
+			s.ProxyError(resp, req, ErrUpgradeRequired, "upgradeRequired")
+			_ = mulch.CloseWithCode(sock, mulch.CloseUpgradeRequired, ErrUpgradeRequired.Error())
 
 			return
 		}
 
 		// 3. Register the connection into server pools.
-		s.newPool <- &PoolConfig{&greeting, sock, secret}
+		s.newPool <- &PoolConfig{&greeting, sock, secret, expires}
 
 		if s.metrics != nil {
 			s.metrics.Regs.WithLabelValues("success").Add(1)
@@ -135,6 +187,46 @@ func (s *Server) HandleRegister() http.Handler {
 	})
 }
 
+// fallback passes a request for a client that is not connected here to the configured fallback.
+func (s *Server) fallback(resp http.ResponseWriter, req *http.Request) bool {
+	if s.Config.Fallback == nil {
+		return false
+	}
+
+	clientID, err := s.getClientID(req)
+	if err != nil || clientID == "" {
+		return false
+	}
+
+	return s.Config.Fallback(resp, req, string(clientID))
+}
+
+// getConnection asks the dispatcher for a connection to a client.
+// Returns nil if the client has no pool, or no idle connection in time. Degraded is true if the
+// client reported its backend is down, so it was not given the request.
+func (s *Server) getConnection(ctx context.Context, client clientID) (*Connection, bool) {
+	request := &dispatchRequest{
+		ctx:        ctx,
+		connection: make(chan *Connection), // do not close this here.
+		client:     client,
+		created:    time.Now(),
+	}
+
+	// "Dispatcher" is running in a separate thread from the server by `go s.DispatchConnections()`.
+	// It waits to receive requests to dispatch connections from available pools to http-clients' requests.
+	// https://github.com/hgsgtk/wsp/blob/ea4902a8e11f820268e52a6245092728efeffd7f/server/server.go#L93
+	s.dispatching.Add(1)
+	s.dispatcher <- request
+	// Dispatcher tries to find an available connection pool,
+	// and it returns the connection through Server.connection channel.
+	// https://github.com/hgsgtk/wsp/blob/ea4902a8e11f820268e52a6245092728efeffd7f/server/server.go#L189
+	// Wait briefly for the dispatcher to return a websocket connection.
+	connection := <-request.connection
+	s.dispatching.Add(-1)
+
+	return connection, request.degraded
+}
+
 func (s *Server) getClientID(req *http.Request) (clientID, error) {
 	target := clientID("")
 
@@ -149,23 +241,38 @@ func (s *Server) getClientID(req *http.Request) (clientID, error) {
 }
 
 // 0. Validate the provided secret key.
-func (s *Server) validateKey(ctx context.Context, header http.Header) (string, error) {
+// Returns the secret to hash into the client ID and the time the key expires.
+func (s *Server) validateKey(ctx context.Context, header http.Header) (string, time.Time, error) {
+	// If a custom expiring key validator is provided, run that.
+	if s.Config.ExpiringKeyValidator != nil {
+		secret, expires, err := s.Config.ExpiringKeyValidator(ctx, header)
+		if err != nil {
+			return "", time.Time{}, fmt.Errorf("custom key validation failed: %w", err)
+		}
+
+		if !expires.IsZero() && time.Now().After(expires) {
+			return "", time.Time{}, fmt.Errorf("%w: %v", ErrKeyExpired, expires)
+		}
+
+		return secret, expires, nil
+	}
+
 	// If a custom key validator is provided, run that.
 	if s.Config.KeyValidator != nil {
 		secret, err := s.Config.KeyValidator(ctx, header)
 		if err != nil {
-			return "", fmt.Errorf("custom key validation failed: %w", err)
+			return "", time.Time{}, fmt.Errorf("custom key validation failed: %w", err)
 		}
 
-		return secret, nil
+		return secret, time.Time{}, nil
 	}
 
 	// Otherwise run the default validator.
 	secretKey := header.Get(mulch.SecretKeyHeader)
 	if secretKey != s.Config.SecretKey {
-		return "", ErrInvalidKey
+		return "", time.Time{}, ErrInvalidKey
 	}
 
 	// Do not return the "configured" secret key.
-	return "", nil
+	return "", time.Time{}, nil
 }
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// DefaultStatsHistory is one hour of history with the default 5 second clean interval.
+const DefaultStatsHistory = 720
+
+// HistoryPoint is a snapshot of connection totals from every pool, taken each time the pools are cleaned.
+type HistoryPoint struct {
+	Time      time.Time `json:"time"`
+	Pools     int       `json:"pools"`
+	Total     int       `json:"total"`
+	Idle      int       `json:"idle"`
+	Busy      int       `json:"busy"`
+	Closed    int       `json:"closed"`
+	BytesSent int64     `json:"bytesSent"`
+	BytesRecv int64     `json:"bytesRecv"`
+}
+
+// history is a fixed size ring buffer of snapshots. Not thread safe; only used in the main loop.
+type history struct {
+	points []*HistoryPoint
+	next   int
+	full   bool
+}
+
+func newHistory(size int) *history {
+	if size <= 0 {
+		return nil
+	}
+
+	return &history{points: make([]*HistoryPoint, size)}
+}
+
+// add saves a snapshot, overwriting the oldest one if the buffer is full.
+func (h *history) add(point *HistoryPoint) {
+	if h == nil {
+		return
+	}
+
+	h.points[h.next] = point
+	h.next = (h.next + 1) % len(h.points)
+	h.full = h.full || h.next == 0
+}
+
+// list returns a copy of the snapshots, oldest first.
+func (h *history) list() []*HistoryPoint {
+	if h == nil {
+		return []*HistoryPoint{}
+	}
+
+	if !h.full {
+		return append([]*HistoryPoint{}, h.points[:h.next]...)
+	}
+
+	return append(append(make([]*HistoryPoint, 0, len(h.points)), h.points[h.next:]...), h.points[:h.next]...)
+}
+
+// History returns the saved connection total snapshots, oldest first.
+func (s *Server) History() []*HistoryPoint {
+	s.getHistory <- struct{}{}
+	return <-s.repHistory
+}
+
+// HandleStatsHistory returns the saved connection total snapshots as JSON, oldest first.
+func (s *Server) HandleStatsHistory(resp http.ResponseWriter, _ *http.Request) {
+	if err := json.NewEncoder(resp).Encode(s.History()); err != nil {
+		http.Error(resp, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// saveHistory runs in the main loop every time the pools are cleaned.
+func (s *Server) saveHistory(now time.Time, totals *PoolSize) {
+	s.history.add(&HistoryPoint{
+		Time:      now,
+		Pools:     s.pools.len(),
+		Total:     totals.Total,
+		Idle:      totals.Idle,
+		Busy:      totals.Busy,
+		Closed:    totals.Closed,
+		BytesSent: totals.BytesSent,
+		BytesRecv: totals.BytesRecv,
+	})
+}
@@ -3,12 +3,13 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"runtime/debug"
+	"strconv"
 
-	"github.com/gorilla/websocket"
 	"golift.io/mulery/mulch"
 )
 
@@ -26,40 +27,55 @@ func (c *Connection) catchProxyPanic() {
 }
 
 // getNextResponse waits for another upstream response, or for the client to give up.
-func (c *Connection) getNextResponse(ctx context.Context, ioCh chan io.Reader) error {
-	defer c.catchProxyPanic()
+// The returned reader must be released with releaseResponse.
+func (c *Connection) getNextResponse(ctx context.Context) (io.Reader, error) {
+	select {
+	case reader := <-c.nextReader:
+		return reader, nil
+	case <-c.closed:
+		return nil, fmt.Errorf("%w: connection closed while waiting for remote", ErrInvalidData)
+	case <-ctx.Done():
+		return nil, fmt.Errorf("http client gave up waiting for remote: %w", ctx.Err())
+	}
+}
 
-	for {
-		select {
-		case c.nextResponse <- ioCh:
-			return nil
-		case <-ctx.Done():
-			return fmt.Errorf("http client gave up waiting for remote: %w", ctx.Err())
-		}
+// releaseResponse notifies the read() goroutine that we are done with the reader.
+func (c *Connection) releaseResponse() {
+	select {
+	case c.doneReader <- struct{}{}:
+	case <-c.closed:
 	}
 }
 
 // proxyRequest is the entry point.
 // Proxies an HTTP request back through the incoming websocket connection.
-func (c *Connection) proxyRequest(resp http.ResponseWriter, req *http.Request) error {
+// The event is updated with the response status and body sizes.
+func (c *Connection) proxyRequest(resp http.ResponseWriter, req *http.Request, event *RequestEvent) error {
+	var err error
+
 	// Step 1.
-	if err := c.sendProxyRequestBody(req); err != nil {
+	if event.BytesSent, err = c.sendProxyRequestBody(req); err != nil {
 		return err
 	}
 
 	// Step 2.
-	jsonResponse, err := c.getProxyResponse(req)
-	if err != nil {
+	httpResponse, err := c.getProxyResponse(req)
+
+	var clientErr *mulch.ErrorFrame
+	if errors.As(err, &clientErr) {
+		event.Status, event.ErrorKind = c.sendClientError(resp, req, clientErr), string(clientErr.Kind)
+		c.Give()
+
+		return nil
+	} else if err != nil {
 		return err
 	}
 
 	// Step 3.
-	if err := c.sendResponseToClient(resp, jsonResponse); err != nil {
-		return err
-	}
+	event.Status = c.sendResponseToClient(resp, httpResponse)
 
 	// Step 4.
-	if err := c.copyProxyResponseBody(resp, req); err != nil {
+	if event.BytesRecv, err = c.copyProxyResponseBody(resp, req, httpResponse.Stream); err != nil {
 		return err
 	}
 
@@ -70,104 +86,161 @@ func (c *Connection) proxyRequest(resp http.ResponseWriter, req *http.Request) e
 }
 
 // sendProxyRequestBody is step 1.
-func (c *Connection) sendProxyRequestBody(req *http.Request) error {
+func (c *Connection) sendProxyRequestBody(req *http.Request) (int64, error) {
 	defer c.catchProxyPanic()
 
-	jsonReq, err := json.Marshal(mulch.SerializeHTTPRequest(req))
+	httpReq := mulch.SerializeHTTPRequest(req)
+	httpReq.Trace = c.pool.tracer.inject(req.Context())
+	httpReq.AcceptStream = true
+
+	jsonReq, err := json.Marshal(httpReq)
 	if err != nil {
-		return fmt.Errorf("serializing request: %w", err)
+		return 0, fmt.Errorf("serializing request: %w", err)
 	}
 
 	// Send the serialized HTTP request to the peer.
-	if err := c.sock.WriteMessage(websocket.TextMessage, jsonReq); err != nil {
-		return fmt.Errorf("writing request: %w", err)
+	if err := c.writeRequest(jsonReq); err != nil {
+		return 0, fmt.Errorf("writing request: %w", err)
 	}
 
 	// Pipe the HTTP request body to the peer.
-	bodyWriter, err := c.sock.NextWriter(websocket.BinaryMessage)
+	bodyWriter, err := c.nextBodyWriter(req.Header)
 	if err != nil {
-		return fmt.Errorf("request body writer: %w", err)
+		return 0, fmt.Errorf("request body writer: %w", err)
 	}
 
-	if _, err := io.Copy(bodyWriter, req.Body); err != nil {
-		return fmt.Errorf("copying request body: %w", err)
+	size, err := c.pool.buffers.copy(bodyWriter, req.Body)
+	c.bytesSent.Add(size)
+	c.pool.metrics.addBytes(bytesSent, size)
+
+	if err != nil {
+		return size, fmt.Errorf("copying request body: %w", err)
 	}
 
 	if err := bodyWriter.Close(); err != nil {
-		return fmt.Errorf("closing request body: %w", err)
+		return size, fmt.Errorf("closing request body: %w", err)
 	}
 
-	return nil
+	return size, nil
 }
 
 // getProxyResponse is step 2.
-func (c *Connection) getProxyResponse(req *http.Request) ([]byte, error) {
+func (c *Connection) getProxyResponse(req *http.Request) (*mulch.HTTPResponse, error) {
 	defer c.catchProxyPanic()
 
-	responseChannel := make(chan (io.Reader))
-	// Notify the read() goroutine that we are done reading the response.
-	defer close(responseChannel)
-
-	if err := c.getNextResponse(req.Context(), responseChannel); err != nil {
+	responseReader, err := c.getNextResponse(req.Context())
+	if err != nil {
 		return nil, err
 	}
+	// Notify the read() goroutine that we are done reading the response.
+	defer c.releaseResponse()
 
-	responseReader := <-responseChannel
-	if responseReader == nil {
-		return nil, fmt.Errorf("%w: no http response reader", ErrInvalidData)
+	if err := c.readResponseFrame(responseReader); err != nil {
+		return nil, err
 	}
 
-	// Read the HTTP response from the peer.
-	jsonResponse, err := io.ReadAll(responseReader)
-	if err != nil {
-		return nil, fmt.Errorf("reading http response: %w", err)
+	// Deserialize the HTTP Response from the peer.
+	httpResponse := new(mulch.HTTPResponse)
+	if err := mulch.DecodeFrame(responseReader, c.pool.maxFrame, httpResponse); err != nil {
+		return nil, fmt.Errorf("unserializing http response: %w", err)
 	}
 
-	return jsonResponse, nil
+	return httpResponse, nil
 }
 
 // sendResponseToClient is step 3.
-func (c *Connection) sendResponseToClient(resp http.ResponseWriter, jsonResponse []byte) error {
-	// Deserialize the HTTP Response.
-	httpResponse := new(mulch.HTTPResponse)
-	if err := json.Unmarshal(jsonResponse, httpResponse); err != nil {
-		return fmt.Errorf("unserializing http response: %w", err)
-	}
-
+// Bodies with a known size get a Content-Length, like the backend sent, instead of being chunked.
+func (c *Connection) sendResponseToClient(resp http.ResponseWriter, httpResponse *mulch.HTTPResponse) int {
 	// Write response headers back to the client.
-	for header, values := range httpResponse.Header {
-		for _, value := range values {
-			resp.Header().Add(header, value)
+	header := resp.Header()
+	for key, values := range httpResponse.Header {
+		if key = http.CanonicalHeaderKey(key); key == mulch.RequestIDHeader && header.Get(key) != "" {
+			continue // Backends often echo it; send it once.
 		}
+
+		header[key] = append(header[key], values...)
+	}
+
+	if httpResponse.KnownLength && !httpResponse.Stream && httpResponse.BodyAllowed() && header.Get("Content-Length") == "" {
+		header.Set("Content-Length", strconv.FormatInt(httpResponse.ContentLength, 10))
+	}
+
+	if c.pool.IsDebug() && httpResponse.Proto != "" {
+		c.pool.Debugf("Tunneled response from %s: %s %s, request %s", c.pool.id, httpResponse.Proto,
+			httpResponse.StatusLine(), header.Get(mulch.RequestIDHeader))
 	}
 
 	resp.WriteHeader(httpResponse.StatusCode)
 
-	return nil
+	return httpResponse.StatusCode
 }
 
 // copyProxyResponseBody is step 4.
-func (c *Connection) copyProxyResponseBody(resp http.ResponseWriter, req *http.Request) error {
+func (c *Connection) copyProxyResponseBody(resp http.ResponseWriter, req *http.Request, stream bool) (int64, error) {
 	defer c.catchProxyPanic()
 
-	// Get the HTTP Response body from the peer.
-	// Send a new channel to the read() goroutine to get the next message reader.
-	responseBodyChannel := make(chan (io.Reader))
-	defer close(responseBodyChannel)
+	if stream {
+		return c.streamProxyResponseBody(resp, req)
+	}
 
-	if err := c.getNextResponse(req.Context(), responseBodyChannel); err != nil {
-		return err
+	// Get the HTTP Response body from the peer.
+	responseBodyReader, err := c.getNextResponse(req.Context())
+	if err != nil {
+		return 0, err
 	}
+	// Notify the read() goroutine that we are done reading the body.
+	defer c.releaseResponse()
 
-	responseBodyReader := <-responseBodyChannel
-	if responseBodyReader == nil {
-		return fmt.Errorf("%w: no http response body reader", ErrInvalidData)
+	if err := c.expectBody(responseBodyReader); err != nil {
+		return 0, err
 	}
 
 	// Pipe the HTTP response body right from the remote Proxy to the client.
-	if _, err := io.Copy(resp, responseBodyReader); err != nil {
-		return fmt.Errorf("copying response body: %w", err)
+	size, err := c.pool.buffers.copy(resp, responseBodyReader)
+	c.bytesRecv.Add(size)
+	c.pool.metrics.addBytes(bytesRecv, size)
+
+	if err != nil {
+		return size, fmt.Errorf("copying response body: %w", err)
 	}
 
-	return nil
+	return size, nil
+}
+
+// streamProxyResponseBody is step 4 for streamed responses. Every message is flushed to the client
+// as soon as it is copied, and an empty message ends the body.
+func (c *Connection) streamProxyResponseBody(resp http.ResponseWriter, req *http.Request) (int64, error) {
+	flusher := http.NewResponseController(resp)
+	_ = flusher.Flush() // Send the headers now; the first chunk may take a while.
+
+	var total int64
+
+	for {
+		responseBodyReader, err := c.getNextResponse(req.Context())
+		if err != nil {
+			return total, err
+		}
+
+		if err := c.expectBody(responseBodyReader); err != nil {
+			c.releaseResponse()
+			return total, err
+		}
+
+		size, err := c.pool.buffers.copy(resp, responseBodyReader)
+		// Notify the read() goroutine that we are done reading this chunk.
+		c.releaseResponse()
+		c.bytesRecv.Add(size)
+		c.pool.metrics.addBytes(bytesRecv, size)
+		total += size
+
+		if err != nil {
+			return total, fmt.Errorf("copying response body: %w", err)
+		}
+
+		if size == 0 {
+			return total, nil
+		}
+
+		_ = flusher.Flush()
+	}
 }
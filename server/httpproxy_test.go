@@ -0,0 +1,55 @@
+package server_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"testing"
+
+	"golift.io/mulery/server"
+)
+
+// BenchmarkSmallResponse measures the hot path for small JSON API responses:
+// a request through the server's request handler, the tunnel and back.
+func BenchmarkSmallResponse(b *testing.B) {
+	env := startEnv(b)
+	httpClient := &http.Client{}
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for idx := 0; idx < b.N; idx++ {
+		req, _ := http.NewRequestWithContext(context.Background(), http.MethodGet, env.url+"/json", nil)
+		req.Header.Set(testIDHeader, testClientID)
+		benchmarkRequest(b, httpClient, req)
+	}
+}
+
+// BenchmarkSmallResponseTransport is BenchmarkSmallResponse without the HTTP listener,
+// so the tunnel's share of the time and allocations stands out.
+func BenchmarkSmallResponseTransport(b *testing.B) {
+	env := startEnv(b)
+	httpClient := &http.Client{Transport: server.NewTransport(env.srv, testClientID)}
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for idx := 0; idx < b.N; idx++ {
+		req, _ := http.NewRequestWithContext(context.Background(), http.MethodGet, "http://backend/json", nil)
+		benchmarkRequest(b, httpClient, req)
+	}
+}
+
+func benchmarkRequest(b *testing.B, httpClient *http.Client, req *http.Request) {
+	b.Helper()
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		b.Fatalf("request: %v", err)
+	}
+
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		b.Fatalf("request: got status %d", resp.StatusCode)
+	}
+}
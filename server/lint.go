@@ -0,0 +1,45 @@
+package server
+
+import (
+	"fmt"
+
+	"golift.io/mulery/mulch"
+)
+
+// Lint returns warnings about suspicious configuration combinations.
+// NewServer logs these; call it yourself to refuse to start with a questionable config.
+func (c *Config) Lint() []string {
+	var warnings []string
+
+	if c.IdleTimeout > 0 && c.IdleTimeout <= c.Timeout {
+		warnings = append(warnings, fmt.Sprintf("idle_timeout (%v) is not longer than timeout (%v): "+
+			"idle connections are reaped faster than requests time out, increase idle_timeout", c.IdleTimeout, c.Timeout))
+	}
+
+	if c.Timeout <= 0 {
+		warnings = append(warnings, "timeout is not set: slow upstream requests may hold connections forever")
+	}
+
+	if c.KeyValidator == nil && c.ExpiringKeyValidator == nil && c.SecretKey == "" {
+		warnings = append(warnings, "secret_key is empty and no key validator is set: any client may register")
+	}
+
+	if c.ReadBufferSize < 0 || c.WriteBufferSize < 0 {
+		warnings = append(warnings, "read_buffer_size and write_buffer_size may not be negative: the default (4KB) is used")
+	}
+
+	if c.CompressionLevel != 0 && c.CompressionLevel != mulch.CompressionLevel(c.CompressionLevel) {
+		warnings = append(warnings, fmt.Sprintf("compression_level (%d) is not between -2 and 9: level %d is used",
+			c.CompressionLevel, mulch.DefaultCompressionLevel))
+	}
+
+	if _, err := c.NoPools.parse(); err != nil {
+		warnings = append(warnings, err.Error()+": the default no pools response is used")
+	}
+
+	if _, err := parseErrorPages(c.ErrorPages); err != nil {
+		warnings = append(warnings, err.Error()+": the plain text error is used")
+	}
+
+	return warnings
+}
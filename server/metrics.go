@@ -7,6 +7,7 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"golift.io/mulery/mulch"
 )
 
 // Metrics contains the exported application metrics in prometheus format.
@@ -18,8 +19,27 @@ type Metrics struct {
 	PoolConns *prometheus.GaugeVec
 	reqStatus *prometheus.CounterVec
 	reqTime   *prometheus.HistogramVec
+	dispatch  *prometheus.HistogramVec
+	bodyBytes *prometheus.CounterVec
+	resizes   prometheus.Counter
+	closes    *prometheus.CounterVec
+	clientErr *prometheus.CounterVec
 }
 
+// Dispatch outcomes used as labels on the time-to-dispatch histogram.
+const (
+	dispatchOK       = "dispatched"
+	dispatchNoPool   = "no-pool"
+	dispatchTimeout  = "timeout"
+	dispatchDegraded = "degraded"
+)
+
+// Body byte directions used as labels on the bytes counter.
+const (
+	bytesSent = "sent"     // request bodies sent to clients.
+	bytesRecv = "received" // response bodies received from clients.
+)
+
 func getMetrics() *Metrics {
 	start := time.Now()
 
@@ -53,9 +73,69 @@ func getMetrics() *Metrics {
 			Help:    "Duration of ->client HTTP requests",
 			Buckets: []float64{.1, .5, 1, 3, 10, 30, 60, 180, 600},
 		}, []string{"code", "method", "handler"}),
+		dispatch: promauto.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "mulery_dispatch_wait_seconds",
+			Help:    "Time requests wait for the dispatcher to provide a websocket connection",
+			Buckets: []float64{.001, .005, .01, .05, .1, .5, 1, 5, 10, 30},
+		}, []string{"outcome"}),
+		bodyBytes: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: "mulery_body_bytes_total",
+			Help: "Request and response body bytes transferred through client tunnels",
+		}, []string{"direction"}),
+		resizes: promauto.NewCounter(prometheus.CounterOpts{
+			Name: "mulery_pool_resizes_total",
+			Help: "Idle buffers rebuilt because a client re-registered with different pool sizes",
+		}),
+		closes: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: "mulery_connection_closes_total",
+			Help: "Websocket connections closed by the server, by reason",
+		}, []string{"reason"}),
+		clientErr: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: "mulery_client_errors_total",
+			Help: "Requests clients could not execute, by kind of error",
+		}, []string{"kind"}),
+	}
+}
+
+// addClose counts a closed connection.
+func (m *Metrics) addClose(reason mulch.CloseReason) {
+	if m != nil {
+		m.closes.WithLabelValues(string(reason)).Inc()
+	}
+}
+
+// addClientError counts a request a client could not execute.
+func (m *Metrics) addClientError(kind mulch.ErrorKind) {
+	if m != nil {
+		m.clientErr.WithLabelValues(string(kind)).Inc()
 	}
 }
 
+// addResize counts an idle buffer resize.
+func (m *Metrics) addResize() {
+	if m != nil {
+		m.resizes.Inc()
+	}
+}
+
+// addBytes counts body bytes transferred through a tunnel.
+func (m *Metrics) addBytes(direction string, size int64) {
+	if m == nil || size < 1 {
+		return
+	}
+
+	m.bodyBytes.WithLabelValues(direction).Add(float64(size))
+}
+
+// observeDispatch records how long a request waited to get a connection.
+func (m *Metrics) observeDispatch(outcome string, start time.Time) {
+	if m == nil {
+		return
+	}
+
+	m.dispatch.WithLabelValues(outcome).Observe(time.Since(start).Seconds())
+}
+
 func (m *Metrics) Wrap(next http.HandlerFunc, handler string) http.Handler {
 	if m == nil {
 		return next
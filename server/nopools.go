@@ -0,0 +1,86 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"text/template"
+	"time"
+)
+
+// NoPoolsResponse configures the response sent to requesters when no clients are connected.
+// Use this to show end users a maintenance message instead of a proxy error.
+type NoPoolsResponse struct {
+	// StatusCode defaults to 503 (Service Unavailable).
+	StatusCode int `json:"statusCode" toml:"status_code" yaml:"statusCode" xml:"status_code"`
+	// ContentType defaults to text/plain.
+	ContentType string `json:"contentType" toml:"content_type" yaml:"contentType" xml:"content_type"`
+	// Body is a text/template executed with NoPoolsData.
+	Body string `json:"body" toml:"body" yaml:"body" xml:"body"`
+	// RetryAfter is sent in a Retry-After header (as seconds) if it is not zero.
+	RetryAfter time.Duration `json:"retryAfter" toml:"retry_after" yaml:"retryAfter" xml:"retry_after"`
+}
+
+// NoPoolsData is the data passed into the NoPoolsResponse Body template.
+type NoPoolsData struct {
+	Method     string
+	URL        string
+	Host       string
+	RetryAfter int // seconds.
+	Time       time.Time
+}
+
+// parse checks the body template. Returns nil if there is no custom response.
+func (n *NoPoolsResponse) parse() (*template.Template, error) {
+	if n == nil {
+		return nil, nil //nolint:nilnil // there's no template to parse.
+	}
+
+	tmpl, err := template.New("noPools").Parse(n.Body)
+	if err != nil {
+		return nil, fmt.Errorf("parsing no pools body template: %w", err)
+	}
+
+	return tmpl, nil
+}
+
+// handleNoPools writes the custom "no clients connected" response.
+// Returns false if there is no custom response configured.
+func (s *Server) handleNoPools(resp http.ResponseWriter, req *http.Request) bool {
+	if s.noPools == nil {
+		return false
+	}
+
+	config := s.Config.NoPools
+	status := config.StatusCode
+
+	if status == 0 {
+		status = http.StatusServiceUnavailable
+	}
+
+	if config.ContentType != "" {
+		resp.Header().Set("Content-Type", config.ContentType)
+	} else {
+		resp.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+
+	retry := int(config.RetryAfter.Round(time.Second).Seconds())
+	if retry > 0 {
+		resp.Header().Set("Retry-After", strconv.Itoa(retry))
+	}
+
+	resp.WriteHeader(status)
+
+	err := s.noPools.Execute(resp, &NoPoolsData{
+		Method:     req.Method,
+		URL:        req.URL.String(),
+		Host:       req.Host,
+		RetryAfter: retry,
+		Time:       time.Now(),
+	})
+	if err != nil {
+		s.Config.Logger.Errorf("Executing no pools body template: %v", err)
+	}
+
+	return true
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"time"
+)
+
+// RequestObserver receives callbacks for every proxied request.
+// Use this to feed your own analytics pipeline. Callbacks are called
+// synchronously from the request handler, so they must not block.
+type RequestObserver interface {
+	// OnDispatch is called when a request is given a client connection.
+	OnDispatch(event *RequestEvent)
+	// OnResponse is called after a response is copied back to the requester.
+	OnResponse(event *RequestEvent)
+	// OnError is called when a request fails, before or after dispatch.
+	OnError(event *RequestEvent, err error)
+}
+
+// RequestEvent is passed to a RequestObserver.
+// Fields are filled in as the request progresses.
+type RequestEvent struct {
+	ClientID  string        // Empty if the request failed before a client was chosen.
+	RequestID string        // From the upstream caller's mulch.RequestIDHeader, or generated.
+	Method    string        // Upstream request method.
+	URL       string        // Upstream request URL.
+	Status    int           // Response status code from the client, 0 until a response arrives.
+	Start     time.Time     // When the request arrived.
+	Duration  time.Duration // Time since Start when the callback was called.
+	BytesSent int64         // Request body bytes sent to the client.
+	BytesRecv int64         // Response body bytes received from the client.
+	ErrorKind string        // Why the client could not execute the request, like dns or timeout. Usually empty.
+}
+
+func (s *Server) observeDispatch(event *RequestEvent) {
+	if s.Config.RequestObserver != nil {
+		event.Duration = time.Since(event.Start)
+		s.Config.RequestObserver.OnDispatch(event)
+	}
+}
+
+func (s *Server) observeResponse(event *RequestEvent) {
+	if s.Config.RequestObserver != nil {
+		event.Duration = time.Since(event.Start)
+		s.Config.RequestObserver.OnResponse(event)
+	}
+}
+
+func (s *Server) observeError(event *RequestEvent, err error) {
+	if s.Config.RequestObserver != nil {
+		event.Duration = time.Since(event.Start)
+		s.Config.RequestObserver.OnError(event, err)
+	}
+}
@@ -1,6 +1,10 @@
 package server
 
 import (
+	"maps"
+	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -15,15 +19,33 @@ type Pool struct {
 	minSize     int
 	idleTimeout time.Duration
 	id          string
+	cid         clientID
 	connections []*Connection
 	closed      int
+	bytesSent   int64 // from closed connections.
+	bytesRecv   int64 // from closed connections.
 	idle        chan *Connection
+	idleMu      sync.RWMutex // protects idle, handshake and minSize while resizing.
+	degraded    atomic.Bool  // the client reported its backend is unhealthy.
+	resize      chan *mulch.Handshake
 	newConn     chan *Connection
 	askClean    chan struct{}
 	askSize     chan time.Time
 	getSize     chan *PoolSize
+	closeAll    chan *poolClose
+	closing     atomic.Pointer[poolClose] // set by Disconnect and Drain; closes connections as they go idle.
+	expires     time.Time                 // earliest key expiry of the connections; pool go routine only.
+	expiry      *time.Timer               // fires at expires, nil if no key expires.
 	mulch.Logger
-	metrics *Metrics
+	metrics  *Metrics
+	tracer   *tracer
+	buffers  *copyBuffers
+	maxFrame int64
+	compress bool
+	onExpire func(poolID string, expired time.Time)
+	// errorPage writes the client's error page for an error frame. Returns false if it has none.
+	errorPage func(resp http.ResponseWriter, req *http.Request, err error, status int) bool
+	auditor   func(*AuditEvent)
 }
 
 // clientID represents the identifier of the connected WebSocket client.
@@ -41,15 +63,25 @@ func NewPool(server *Server, client *PoolConfig, altID string) *Pool {
 		connected:   time.Now(),
 		handshake:   client.Handshake,
 		id:          altID,
+		cid:         clientID(mulch.HashKeyIDWith(server.Config.KeyHasher, client.secret, client.ID)),
 		minSize:     client.Size + 1, // This 1 allows slightly less thread teardown/bringup.
 		idle:        make(chan *Connection, client.MaxSize+1),
 		idleTimeout: server.Config.IdleTimeout,
 		newConn:     make(chan *Connection),
+		resize:      make(chan *mulch.Handshake),
 		askClean:    make(chan struct{}),
 		askSize:     make(chan time.Time),
 		getSize:     make(chan *PoolSize),
-		Logger:      server.Config.Logger,
+		closeAll:    make(chan *poolClose),
+		Logger:      poolLogger(server.Config.Logger, altID, client.Handshake),
 		metrics:     server.metrics,
+		tracer:      server.tracer,
+		buffers:     server.buffers,
+		maxFrame:    server.Config.MaxHeaderSize,
+		compress:    !server.Config.DisableCompression,
+		onExpire:    server.Config.OnKeyExpire,
+		errorPage:   server.writeErrorPage,
+		auditor:     server.Config.Auditor,
 	}
 
 	go pool.keepRunning() // gofunc:3 (N)
@@ -61,13 +93,22 @@ func (pool *Pool) shutdown() {
 	pool.Debugf("Shutting down pool: %v", pool.id)
 	defer pool.Debugf("Done shutting down pool: %v", pool.id)
 
+	if pool.expiry != nil {
+		pool.expiry.Stop()
+	}
+
 	for _, connection := range pool.connections {
-		connection.Close("shutdown")
+		connection.Close(mulch.CloseShutdown, "pool shutdown")
 	}
 
 	close(pool.askClean)
 	close(pool.askSize)
 	close(pool.getSize)
+	close(pool.resize)
+	close(pool.closeAll)
+
+	pool.idleMu.Lock()
+	defer pool.idleMu.Unlock()
 	close(pool.idle)
 }
 
@@ -81,6 +122,12 @@ func (pool *Pool) keepRunning() {
 			pool.getSize <- &PoolSize{Total: len(pool.connections)} // shoehorn.
 		case now := <-pool.askSize:
 			pool.getSize <- pool.size(now)
+		case handshake := <-pool.resize:
+			pool.resizeIdle(handshake)
+		case closing := <-pool.closeAll:
+			pool.disconnectAll(closing)
+		case <-pool.expired():
+			pool.keyExpired()
 		case conn, ok := <-pool.newConn:
 			if !ok {
 				return
@@ -88,16 +135,161 @@ func (pool *Pool) keepRunning() {
 
 			pool.clean()
 			pool.connections = append(pool.connections, conn)
+			pool.armExpiry(conn.expires)
 			pool.Printf("Registering new connection from %s [%s], tunnels: %d, idle: %d/%d",
 				pool.id, conn.sock.RemoteAddr(), len(pool.connections), len(pool.idle), cap(pool.idle))
 		}
 	}
 }
 
+// idleChan returns the idle connection buffer. It is replaced when the pool is resized.
+func (pool *Pool) idleChan() chan *Connection {
+	pool.idleMu.RLock()
+	defer pool.idleMu.RUnlock()
+
+	return pool.idle
+}
+
+// putIdle puts a connection into the idle buffer without blocking.
+// Returns false if the buffer is full.
+func (pool *Pool) putIdle(conn *Connection) bool {
+	pool.idleMu.RLock()
+	defer pool.idleMu.RUnlock()
+
+	select {
+	case pool.idle <- conn:
+		return true
+	default:
+		return false
+	}
+}
+
+// Degraded returns true if the client reported that its backend is unhealthy.
+// Requests for degraded pools fail fast.
+func (pool *Pool) Degraded() bool {
+	return pool.degraded.Load()
+}
+
+// setHealth saves a health report from the client. Other ping payloads are ignored.
+func (pool *Pool) setHealth(payload string) {
+	var degraded bool
+
+	switch payload {
+	case mulch.HealthOK:
+	case mulch.HealthDegraded:
+		degraded = true
+	default:
+		return
+	}
+
+	if pool.degraded.Swap(degraded) != degraded {
+		pool.Printf("Client %s reported backend health: %s", pool.id, payload)
+	}
+}
+
+// Handshake returns the most recent handshake the client registered with.
+func (pool *Pool) Handshake() *mulch.Handshake {
+	pool.idleMu.RLock()
+	defer pool.idleMu.RUnlock()
+
+	return pool.handshake
+}
+
+// Resize rebuilds the idle connection buffer if the client re-registers with different pool sizes.
+// Idle connections are moved into the new buffer, and any that do not fit are closed.
+// A new client version or metadata replaces the saved handshake, so stats show what connected last.
+func (pool *Pool) Resize(handshake *mulch.Handshake) {
+	current := pool.Handshake()
+	if current.Size != handshake.Size || current.MaxSize != handshake.MaxSize {
+		pool.resize <- handshake
+		return
+	}
+
+	versionChanged := current.Version != handshake.Version || platform(current) != platform(handshake)
+	if versionChanged {
+		pool.Printf("Client %s changed version from %s (%s) to %s (%s)", pool.id,
+			current.Version, platform(current), handshake.Version, platform(handshake))
+	}
+
+	if versionChanged || !maps.Equal(current.Metadata, handshake.Metadata) {
+		pool.idleMu.Lock()
+		pool.handshake = handshake
+		pool.idleMu.Unlock()
+	}
+}
+
+// Metadata returns the labels the client sent in its most recent handshake. Do not modify the map.
+func (pool *Pool) Metadata() map[string]string {
+	return pool.Handshake().Metadata
+}
+
+// poolLogger adds the client's identity and build to every log message from a pool.
+func poolLogger(logger mulch.Logger, altID string, handshake *mulch.Handshake) mulch.Logger {
+	return mulch.With(logger, "pool", altID, "clientId", handshake.ID, "name", handshake.Name, "version", handshake.Version)
+}
+
+// platform formats a client's platform from its handshake, like linux/arm64 go1.22.1.
+// Returns an empty string for older clients that do not send it.
+func platform(handshake *mulch.Handshake) string {
+	if handshake.OS == "" && handshake.GoVersion == "" {
+		return ""
+	}
+
+	return handshake.OS + "/" + handshake.Arch + " " + handshake.GoVersion
+}
+
+// resizeIdle runs in the pool's go routine.
+func (pool *Pool) resizeIdle(handshake *mulch.Handshake) {
+	var overflow []*Connection
+
+	pool.idleMu.Lock()
+	old := pool.idle
+	pool.idle = make(chan *Connection, handshake.MaxSize+1)
+
+	for drained := false; !drained; {
+		select {
+		case conn := <-old:
+			select {
+			case pool.idle <- conn:
+			default:
+				overflow = append(overflow, conn)
+			}
+		default:
+			drained = true
+		}
+	}
+
+	// Dispatchers waiting on the old buffer receive nil, and ask for the pool again.
+	close(old)
+	pool.Printf("Resizing idle buffer pool %s from %d/%d to %d/%d, closing %d connections",
+		pool.id, pool.minSize, cap(old), handshake.Size+1, cap(pool.idle), len(overflow))
+	pool.handshake = handshake
+	pool.minSize = handshake.Size + 1
+	pool.idleMu.Unlock()
+
+	for _, conn := range overflow {
+		conn.Close(mulch.CloseCapacity, "idle buffer resized")
+	}
+
+	pool.metrics.addResize()
+}
+
 // Register creates a new Connection and adds it to the pool.
 func (pool *Pool) Register(ws *websocket.Conn) {
+	pool.register(ws, time.Time{}, false)
+}
+
+// RegisterExpiring creates a new Connection and adds it to the pool.
+// The pool closes the connection when expires passes, after any request it is serving. A zero expires never expires.
+func (pool *Pool) RegisterExpiring(ws *websocket.Conn, expires time.Time) {
+	pool.register(ws, expires, false)
+}
+
+// register adds a connection to the pool. framed is true if the client's handshake has Frames.
+func (pool *Pool) register(ws *websocket.Conn, expires time.Time, framed bool) {
 	pool.cleanIdleChan()
-	pool.newConn <- NewConnection(pool, ws)
+
+	pool.newConn <- newConnection(pool, ws, expires, framed)
 }
 
 // clean removes dead and idle connections from the pool.
@@ -114,6 +306,8 @@ func (pool *Pool) clean() {
 			save = append(save, connection)
 		} else {
 			pool.closed++
+			pool.bytesSent += connection.bytesSent.Load()
+			pool.bytesRecv += connection.bytesRecv.Load()
 		}
 	}
 
@@ -123,9 +317,11 @@ func (pool *Pool) clean() {
 // cleanIdleChan removes all non-idle connections from the idle channel buffer.
 // This should run every time a new connection registers; to clean out old dead connections.
 func (pool *Pool) cleanIdleChan() {
-	for i := len(pool.idle); i > 0; i-- {
-		if conn := <-pool.idle; conn.Status() == Idle {
-			pool.idle <- conn
+	idle := pool.idleChan()
+
+	for i := len(idle); i > 0; i-- {
+		if conn := <-idle; conn != nil && conn.Status() == Idle && !pool.putIdle(conn) {
+			conn.Close(mulch.CloseCapacity, "idle buffer pool at capacity")
 		}
 	}
 }
@@ -142,7 +338,7 @@ func (pool *Pool) cleanConnection(connection *Connection, idle int) (int, bool)
 			// We have enough idle connections in the pool, and this one is old.
 			pool.Printf("Closing idle connection: %s [%s], tunnels: %d , idle: %d/%d",
 				pool.id, connection.sock.RemoteAddr(), len(pool.connections), len(pool.idle), cap(pool.idle))
-			connection.close("idle " + age.String())
+			connection.close(mulch.CloseIdle, "idle "+age.String())
 		}
 	}
 
@@ -163,11 +359,18 @@ func (pool *Pool) Shutdown() {
 
 // PoolSize is the number of connection in each state in the pool.
 type PoolSize struct {
-	Total  int          `json:"total"`
-	Idle   int          `json:"idle"`
-	Busy   int          `json:"busy"`
-	Closed int          `json:"closed"`
-	Conns  []*ConnStats `json:"conns"`
+	Total     int          `json:"total"`
+	Idle      int          `json:"idle"`
+	Busy      int          `json:"busy"`
+	Closed    int          `json:"closed"`
+	BytesSent int64        `json:"bytesSent"`
+	BytesRecv int64        `json:"bytesRecv"`
+	Conns     []*ConnStats `json:"conns"`
+	// Version and Platform (like linux/arm64 go1.22.1) describe the client's build. Not set in summaries.
+	Version  string `json:"version,omitempty"`
+	Platform string `json:"platform,omitempty"`
+	// Metadata are the labels from the client's handshake. Not set in summaries.
+	Metadata map[string]string `json:"metadata,omitempty"`
 }
 
 type ConnStats struct {
@@ -175,6 +378,9 @@ type ConnStats struct {
 	Requests  int       `json:"requests"`
 	Connected time.Time `json:"conneteed"`
 	Idle      string    `json:"idle"`
+	Status    string    `json:"status"`
+	BytesSent int64     `json:"bytesSent"`
+	BytesRecv int64     `json:"bytesRecv"`
 }
 
 // Size return the number of connection in each state in the pool.
@@ -187,9 +393,15 @@ func (pool *Pool) Size(now time.Time) *PoolSize {
 // size return the number of connection in each state in the pool. not thread safe.
 func (pool *Pool) size(now time.Time) *PoolSize {
 	size := PoolSize{
-		Total:  len(pool.connections),
-		Closed: pool.closed,
-		Conns:  make([]*ConnStats, len(pool.connections)),
+		Total:     len(pool.connections),
+		Closed:    pool.closed,
+		BytesSent: pool.bytesSent,
+		BytesRecv: pool.bytesRecv,
+		Conns:     make([]*ConnStats, len(pool.connections)),
+	}
+
+	if handshake := pool.Handshake(); handshake != nil {
+		size.Version, size.Platform, size.Metadata = handshake.Version, platform(handshake), handshake.Metadata
 	}
 
 	for idx, connection := range pool.connections {
@@ -198,7 +410,12 @@ func (pool *Pool) size(now time.Time) *PoolSize {
 			Connected: connection.connected,
 			Requests:  connection.requests,
 			Idle:      now.Sub(connection.idleSince).Round(time.Second).String(),
+			Status:    connection.Status().String(),
+			BytesSent: connection.bytesSent.Load(),
+			BytesRecv: connection.bytesRecv.Load(),
 		}
+		size.BytesSent += size.Conns[idx].BytesSent
+		size.BytesRecv += size.Conns[idx].BytesRecv
 
 		switch connection.status {
 		case Idle:
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+)
+
+// poolShards is the number of locks the pool map is split across.
+// Dispatchers read pools concurrently, and only the main loop writes them.
+const poolShards = 64
+
+// poolMap holds every pool by client ID. It's split into shards, each with its own lock,
+// so pool lookups from many dispatchers do not wait on each other, or on the main loop.
+type poolMap struct {
+	shards [poolShards]poolShard
+	count  atomic.Int64
+}
+
+type poolShard struct {
+	sync.RWMutex
+	pools map[clientID]*Pool
+}
+
+func newPoolMap() *poolMap {
+	pools := &poolMap{}
+	for idx := range pools.shards {
+		pools.shards[idx].pools = make(map[clientID]*Pool)
+	}
+
+	return pools
+}
+
+func (p *poolMap) shard(cID clientID) *poolShard {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(cID))
+
+	return &p.shards[hash.Sum32()%poolShards]
+}
+
+// get returns a pool, or nil if there is no pool for the client ID.
+func (p *poolMap) get(cID clientID) *Pool {
+	shard := p.shard(cID)
+	shard.RLock()
+	defer shard.RUnlock()
+
+	return shard.pools[cID]
+}
+
+// set adds or replaces a pool.
+func (p *poolMap) set(cID clientID, pool *Pool) {
+	shard := p.shard(cID)
+	shard.Lock()
+	defer shard.Unlock()
+
+	if _, ok := shard.pools[cID]; !ok {
+		p.count.Add(1)
+	}
+
+	shard.pools[cID] = pool
+}
+
+// remove deletes a pool.
+func (p *poolMap) remove(cID clientID) {
+	shard := p.shard(cID)
+	shard.Lock()
+	defer shard.Unlock()
+
+	if _, ok := shard.pools[cID]; ok {
+		p.count.Add(-1)
+		delete(shard.pools, cID)
+	}
+}
+
+// len returns the number of pools.
+func (p *poolMap) len() int {
+	return int(p.count.Load())
+}
+
+// snapshot returns a copy of every pool, to range over without holding locks.
+func (p *poolMap) snapshot() map[clientID]*Pool {
+	pools := make(map[clientID]*Pool, p.len())
+
+	for idx := range p.shards {
+		shard := &p.shards[idx]
+		shard.RLock()
+
+		for cID, pool := range shard.pools {
+			pools[cID] = pool
+		}
+
+		shard.RUnlock()
+	}
+
+	return pools
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+// benchPools is the number of pools in the lookup benchmarks.
+const benchPools = 100000
+
+func newBenchPoolMap(b *testing.B) (*poolMap, []clientID) {
+	b.Helper()
+
+	pools := newPoolMap()
+	ids := make([]clientID, benchPools)
+
+	for idx := range ids {
+		ids[idx] = clientID(strconv.Itoa(idx))
+		pools.set(ids[idx], &Pool{id: string(ids[idx])})
+	}
+
+	if pools.len() != benchPools {
+		b.Fatalf("pool map has %d pools, want %d", pools.len(), benchPools)
+	}
+
+	return pools, ids
+}
+
+// BenchmarkPoolMapGet looks up pools in a map of 100k pools from one dispatcher.
+func BenchmarkPoolMapGet(b *testing.B) {
+	pools, ids := newBenchPoolMap(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for idx := 0; idx < b.N; idx++ {
+		if pools.get(ids[idx%benchPools]) == nil {
+			b.Fatal("pool not found")
+		}
+	}
+}
+
+// BenchmarkPoolMapGetParallel looks up pools in a map of 100k pools from many dispatchers at once.
+func BenchmarkPoolMapGetParallel(b *testing.B) {
+	pools, ids := newBenchPoolMap(b)
+	next := atomic.Int64{}
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		idx := int(next.Add(benchPools / 64)) //nolint:gomnd // start each goroutine somewhere else.
+
+		for pb.Next() {
+			if pools.get(ids[idx%benchPools]) == nil {
+				b.Error("pool not found")
+				return
+			}
+
+			idx++
+		}
+	})
+}
+
+// BenchmarkPoolMapGetWithWrites is BenchmarkPoolMapGetParallel while pools are added and removed,
+// like the main loop does when clients connect and disconnect.
+func BenchmarkPoolMapGetWithWrites(b *testing.B) {
+	pools, ids := newBenchPoolMap(b)
+	next := atomic.Int64{}
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		idx := int(next.Add(benchPools / 64)) //nolint:gomnd
+
+		for pb.Next() {
+			if idx%100 == 0 { //nolint:gomnd // one write for every 100 reads.
+				cID := clientID("churn-" + strconv.Itoa(idx))
+				pools.set(cID, &Pool{id: string(cID)})
+				pools.remove(cID)
+			} else {
+				pools.get(ids[idx%benchPools])
+			}
+
+			idx++
+		}
+	})
+}
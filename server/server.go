@@ -3,16 +3,21 @@ package server
 import (
 	"errors"
 	"fmt"
+	"sync/atomic"
 	"time"
 
 	"golift.io/mulery/mulch"
 )
 
 var (
-	ErrInvalidKey    = errors.New("invalid secret key provided")
-	ErrNoClientID    = errors.New("required client id header is missing")
-	ErrNoProxyTarget = errors.New("no proxy target found for request")
-	ErrInvalidData   = errors.New("invalid data received")
+	ErrInvalidKey      = errors.New("invalid secret key provided")
+	ErrNoClientID      = errors.New("required client id header is missing")
+	ErrNoProxyTarget   = errors.New("no proxy target found for request")
+	ErrInvalidData     = errors.New("invalid data received")
+	ErrKeyExpired      = errors.New("secret key is expired")
+	ErrDegraded        = errors.New("client reported its backend is unhealthy")
+	ErrDraining        = errors.New("server is draining")
+	ErrUpgradeRequired = errors.New("client does not support frames")
 )
 
 // StartDispatcher dispatches connections from available pools to client requests.
@@ -26,13 +31,18 @@ func (s *Server) StartDispatcher() {
 	defer cleaner.Stop()
 
 	for threadID := s.Config.Dispatchers; threadID > 0; threadID-- {
+		s.threadCount[threadID] = &atomic.Uint64{}
+	}
+
+	for threadID := s.Config.Dispatchers; threadID > 0; threadID-- {
+		s.dispatchers.Add(1)
+
 		go func(threadID uint) {
+			defer s.dispatchers.Done() // notify shutdown() that dispatcher is closed.
+
 			for r := range s.dispatcher {
 				s.dispatchRequest(r, threadID)
 			}
-
-			// notify shutdown() that dispatcher is closed.
-			s.getPool <- nil
 		}(threadID)
 	}
 
@@ -47,41 +57,36 @@ func (s *Server) StartDispatcher() {
 			}
 
 			s.registerPool(newPool)
-		case req := <-s.getPool:
-			s.threadCount[req.threadID]++
-			s.repPool <- s.pools[req.clientID]
 		case now := <-cleaner.C:
 			s.cleanPools(now)
-		case clientID := <-s.getStats:
-			s.repStats <- &Stats{
-				Pools:   s.poolStats(clientID),
-				Threads: s.threadStats(),
-			}
+		case query := <-s.getStats:
+			s.repStats <- s.stats(query)
+		case <-s.getState:
+			s.repState <- s.state()
+		case reply := <-s.getClients:
+			reply <- s.clients()
+		case <-s.getHistory:
+			s.repHistory <- s.history.list()
+		case request := <-s.disconnect:
+			s.disconnectPools(request)
 		}
 	}
 }
 
 // poolStats provides data about running pools and connections.
 // Useful for a web handler to show an operator what's happening.
-func (s *Server) poolStats(cID clientID) map[clientID]any {
-	if cID != "" {
-		if s.pools[cID] == nil {
-			return map[clientID]any{"id not found": nil}
-		}
-
-		return map[clientID]any{cID: s.pools[cID].size(time.Now())}
-	}
-
+func (s *Server) poolStats(ids []clientID) map[clientID]any {
 	now := time.Now()
 
-	pools := make(map[clientID]any, len(s.pools))
-	for target, pool := range s.pools {
+	pools := make(map[clientID]any, len(ids))
+	for _, target := range ids {
+		pool := s.pools.get(target)
 		pools[target] = map[string]any{ // becomes json.
 			"connected":    pool.connected,
 			"duration":     time.Since(pool.connected).Round(time.Second).String(),
-			"idlePoolWait": len(pool.idle),
-			"idlePoolSize": cap(pool.idle),
-			"client":       pool.handshake,
+			"idlePoolWait": len(pool.idleChan()),
+			"idlePoolSize": cap(pool.idleChan()),
+			"client":       pool.Handshake(),
 			"sizes":        pool.size(now),
 		}
 	}
@@ -93,7 +98,7 @@ func (s *Server) threadStats() map[uint]uint64 {
 	threadCount := make(map[uint]uint64, len(s.threadCount))
 
 	for k, v := range s.threadCount {
-		threadCount[k] = v
+		threadCount[k] = v.Load()
 	}
 
 	return threadCount
@@ -103,36 +108,38 @@ func (s *Server) threadStats() map[uint]uint64 {
 // This also shoves pool counters into prometheus if it's enabled.
 // It is invoked every 5 sesconds and at shutdown.
 func (s *Server) cleanPools(now time.Time) {
-	if len(s.pools) == 0 {
+	if s.pools.len() == 0 {
+		s.saveHistory(now, &PoolSize{Closed: s.closed})
 		return
 	}
 
 	totals := &PoolSize{}
 	connsPerPool := make(map[int]int)
-	pools := make(map[clientID]*Pool, len(s.pools))
 
-	for target, pool := range s.pools {
+	for target, pool := range s.pools.snapshot() {
 		if pool.IsEmpty() {
 			s.Config.Logger.Debugf("Removing empty connection pool: %s", pool.id)
+			s.pools.remove(target)
 			pool.Shutdown()
 			s.closed += pool.closed
 
 			continue
 		}
 
-		pools[target] = pool
 		ps := pool.Size(now)
 		totals.Total += ps.Total
 		totals.Idle += ps.Idle
 		totals.Busy += ps.Busy
 		totals.Closed += ps.Closed
+		totals.BytesSent += ps.BytesSent
+		totals.BytesRecv += ps.BytesRecv
 		connsPerPool[ps.Total]++
 	}
 
 	totals.Closed += s.closed
-	s.pools = pools
 	s.Config.Logger.Debugf("%d pools, %d connections, %d idle, %d busy, %d closed",
-		len(s.pools), totals.Total, totals.Idle, totals.Busy, totals.Closed)
+		s.pools.len(), totals.Total, totals.Idle, totals.Busy, totals.Closed)
+	s.saveHistory(now, totals)
 	s.saveMetrics(totals, connsPerPool)
 }
 
@@ -163,7 +170,7 @@ func (s *Server) saveMetrics(totals *PoolSize, connsPerPool map[int]int) {
 	s.metrics.Conns.WithLabelValues("busy").Set(float64(totals.Busy))
 	s.metrics.Conns.WithLabelValues("idle").Set(float64(totals.Idle))
 	s.metrics.Conns.WithLabelValues("closed").Set(float64(totals.Closed))
-	s.metrics.Pools.Set(float64(len(s.pools)))
+	s.metrics.Pools.Set(float64(s.pools.len()))
 }
 
 // dispatchRequest runs every time an http request comes into the server.
@@ -176,49 +183,94 @@ func (s *Server) dispatchRequest(request *dispatchRequest, threadID uint) {
 	defer close(request.connection)
 
 	for {
-		s.Config.Logger.Debugf("[%d] dispatchRequest: 1 ask %s", threadID, request.client)
-		// Ask the main thread for this pool by ID.
-		s.getPool <- &getPoolRequest{clientID: request.client, threadID: threadID}
-		s.Config.Logger.Debugf("[%d] dispatchRequest: 2 wait %s", threadID, request.client)
-		// Get the pool reply from the main thread.
-		pool := <-s.repPool
-		s.Config.Logger.Debugf("[%d] dispatchRequest: 3 got %s", threadID, request.client)
+		s.debugDispatch(threadID, "1 lookup", request.client)
+		// Look up the pool by ID. This does not wait on the main loop.
+		s.threadCount[threadID].Add(1)
+		pool := s.pools.get(request.client)
+		s.debugDispatch(threadID, "2 got", request.client)
 
 		if pool == nil {
-			s.Config.Logger.Debugf("[%d] dispatchRequest: 4 empty pool %s", threadID, request.client)
+			s.debugDispatch(threadID, "4 empty pool", request.client)
+			s.metrics.observeDispatch(dispatchNoPool, request.created)
+
 			return // no client pool with that name.
 		}
 
-		// This blocks until an idle connection is available.
-		conn := <-pool.idle
+		if pool.Degraded() {
+			s.debugDispatch(threadID, "4 degraded pool", request.client)
+			s.metrics.observeDispatch(dispatchDegraded, request.created)
+			request.degraded = true
+
+			return // fail fast, the client would fail this request anyway.
+		}
+
+		var conn *Connection
+
+		idle := pool.idleChan()
+		// This blocks until an idle connection is available, or the requester gives up.
+		select {
+		case conn = <-idle:
+		case <-request.ctx.Done():
+			s.debugDispatch(threadID, "4 requester gave up", request.client)
+			s.metrics.observeDispatch(dispatchTimeout, request.created)
+
+			return
+		}
+
+		if conn == nil && idle != pool.idleChan() {
+			s.debugDispatch(threadID, "4 resized conn channel", request.client)
+			continue // pool was resized as request came in.
+		}
+
 		if conn == nil {
-			s.Config.Logger.Debugf("[%d] dispatchRequest: 4 empty conn channel %s", threadID, request.client)
+			s.debugDispatch(threadID, "4 empty conn channel", request.client)
+			s.metrics.observeDispatch(dispatchNoPool, request.created)
+
 			return // pool was shutdown as request came in.
 		}
 
-		s.Config.Logger.Debugf("[%d] dispatchRequest: 4 take %s", threadID, request.client)
+		s.debugDispatch(threadID, "4 take", request.client)
 		// Verify that we can use this connection and take it.
 		if connection := conn.Take(); connection != nil {
+			s.metrics.observeDispatch(dispatchOK, request.created)
 			request.connection <- connection
-			s.Config.Logger.Debugf("[%d] dispatchRequest: 5 done %s", threadID, request.client)
+			s.debugDispatch(threadID, "5 done", request.client)
 
 			return
 		}
 
-		s.Config.Logger.Debugf("[%d] dispatchRequest: 5 restart %s", threadID, request.client)
+		s.debugDispatch(threadID, "5 restart", request.client)
+	}
+}
+
+// debugDispatch logs a dispatcher step. This runs several times per request,
+// so it checks IsDebug before boxing the arguments into a Debugf call.
+func (s *Server) debugDispatch(threadID uint, step string, cID clientID) {
+	if s.Config.Logger.IsDebug() {
+		s.Config.Logger.Debugf("[%d] dispatchRequest: %s %s", threadID, step, cID)
 	}
 }
 
 // Register the connection into server pools.
 // This is called through a channel from the register handler.
 func (s *Server) registerPool(client *PoolConfig) {
-	cID := mulch.HashKeyID(client.secret, client.ID)
-	if pool := s.pools[clientID(cID)]; pool == nil {
-		s.pools[clientID(cID)] = NewPool(s, client, cID+" ["+client.Name+"]")
+	cID := mulch.HashKeyIDWith(s.Config.KeyHasher, client.secret, client.ID)
+
+	pool := s.pools.get(clientID(cID))
+	if pool == nil {
+		pool = NewPool(s, client, cID+" ["+client.Name+"]")
+		s.pools.set(clientID(cID), pool)
+
+		if client.Version != "" {
+			pool.Printf("New client pool %s, version %s (%s)", pool.id, client.Version, platform(client.Handshake))
+		}
+	} else {
+		pool.Resize(client.Handshake)
 	}
 
 	// Add the WebSocket connection to the pool
-	s.pools[clientID(cID)].Register(client.Sock)
+	pool.register(client.Sock, client.expires, client.Frames)
+	audit(s.Config.Auditor, AuditRegister, cID, client.Name, client.Sock.RemoteAddr().String(), "")
 }
 
 // Shutdown stops the Server.
@@ -230,17 +282,19 @@ func (s *Server) Shutdown() {
 func (s *Server) shutdown() {
 	close(s.dispatcher)
 
-	for i := s.Config.Dispatchers; i > 0; i-- {
-		<-s.getPool // wait for dispatchers to finish.
-	}
+	s.dispatchers.Wait() // wait for dispatchers to finish.
 
-	close(s.getPool)
-	close(s.repPool)
 	close(s.getStats)
 	close(s.repStats)
-
-	for target, pool := range s.pools {
+	close(s.getState)
+	close(s.repState)
+	close(s.getClients)
+	close(s.getHistory)
+	close(s.repHistory)
+	close(s.disconnect)
+
+	for target, pool := range s.pools.snapshot() {
 		pool.Shutdown()
-		delete(s.pools, target)
+		s.pools.remove(target)
 	}
 }
@@ -0,0 +1,161 @@
+package server_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golift.io/mulery/client"
+	"golift.io/mulery/mulch"
+	"golift.io/mulery/server"
+)
+
+// testClientID is the client connected to the test server. Without a key validator, it is also the pool ID.
+const testClientID = "test-client"
+
+// testIDHeader routes requests sent to the server's HandleRequest.
+const testIDHeader = "X-Client-Id"
+
+// bigBody is the size of the /big response. It is larger than any buffer in the tunnel.
+const bigBody = 4 << 20
+
+// The server registers prometheus metrics, so only one may exist per process.
+//
+//nolint:gochecknoglobals
+var (
+	env     *testEnv
+	envOnce sync.Once
+)
+
+// testEnv is a server with one connected client.
+type testEnv struct {
+	srv      *server.Server
+	url      string
+	observer *observer
+}
+
+// observer records how requests finished, by request ID.
+type observer struct {
+	mu   sync.Mutex
+	done map[string]chan error
+}
+
+func (o *observer) wait(requestID string) chan error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	done := make(chan error, 1)
+	o.done[requestID] = done
+
+	return done
+}
+
+func (o *observer) finish(requestID string, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if done := o.done[requestID]; done != nil {
+		done <- err
+		delete(o.done, requestID)
+	}
+}
+
+func (o *observer) OnDispatch(*server.RequestEvent) {}
+
+func (o *observer) OnResponse(event *server.RequestEvent) {
+	o.finish(event.RequestID, nil)
+}
+
+func (o *observer) OnError(event *server.RequestEvent, err error) {
+	o.finish(event.RequestID, err)
+}
+
+// startEnv starts the shared server and client, and waits for the client to connect.
+func startEnv(tb testing.TB) *testEnv {
+	tb.Helper()
+
+	envOnce.Do(func() {
+		config := server.NewConfig()
+		config.IDHeader = testIDHeader
+		config.Timeout = time.Minute
+		config.IdleTimeout = time.Hour
+		config.Logger = &mulch.DefaultLogger{Silent: true}
+		config.RequestObserver = &observer{done: make(map[string]chan error)}
+		srv := server.NewServer(config)
+
+		go srv.StartDispatcher()
+
+		mux := http.NewServeMux()
+		mux.Handle("/register", srv.HandleRegister())
+		mux.Handle("/", srv.HandleRequest("test"))
+		httpSrv := httptest.NewServer(mux)
+		env = &testEnv{srv: srv, url: httpSrv.URL, observer: config.RequestObserver.(*observer)}
+
+		clientConfig := client.NewConfig()
+		clientConfig.ID = testClientID
+		clientConfig.Targets = []string{"ws" + strings.TrimPrefix(httpSrv.URL, "http") + "/register"}
+		clientConfig.PoolIdleSize = 1
+		clientConfig.PoolMaxSize = 1
+		clientConfig.Logger = &mulch.DefaultLogger{Silent: true}
+		clientConfig.Handler = testHandler
+
+		tunnel, err := client.NewClient(clientConfig)
+		if err != nil {
+			panic(err)
+		}
+
+		tunnel.Start(context.Background())
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second) //nolint:gomnd
+	defer cancel()
+
+	httpClient := &http.Client{Transport: server.NewTransport(env.srv, testClientID)}
+
+	for {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "http://backend/ping", nil)
+		if resp, err := httpClient.Do(req); err == nil {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+
+			if resp.StatusCode == http.StatusOK {
+				return env
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			tb.Fatalf("client did not connect: %v", ctx.Err())
+		case <-time.After(10 * time.Millisecond): //nolint:gomnd
+		}
+	}
+}
+
+// testHandler is the client's backend.
+func testHandler(resp http.ResponseWriter, req *http.Request) {
+	switch req.URL.Path {
+	case "/echo":
+		resp.Header().Set("X-Method", req.Method)
+		_, _ = io.Copy(resp, req.Body)
+	case "/big":
+		chunk := []byte(strings.Repeat("x", 32<<10)) //nolint:gomnd
+		for size := 0; size < bigBody; size += len(chunk) {
+			_, _ = resp.Write(chunk)
+		}
+	case "/json":
+		resp.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(resp, `{"status":"ok","items":[1,2,3]}`)
+	case "/teapot":
+		resp.WriteHeader(http.StatusTeapot)
+	case "/panic":
+		_, _ = io.WriteString(resp, "partial")
+		panic("test handler panic")
+	default:
+		_, _ = io.WriteString(resp, "pong")
+	}
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// redacted replaces secrets in state dumps.
+const redacted = "********"
+
+// State is a consistent snapshot of the server's internal state.
+// Useful for support engineers to capture what's happening during an incident.
+type State struct {
+	Time    time.Time               `json:"time"`
+	Config  *Config                 `json:"config"`
+	Pools   map[clientID]*PoolState `json:"pools"`
+	Threads map[uint]uint64         `json:"threads"`
+	Closed  int                     `json:"closed"`
+	Queues  *QueueState             `json:"queues"`
+}
+
+// PoolState is the internal state of a single client pool.
+type PoolState struct {
+	ID        string    `json:"id"`
+	Connected time.Time `json:"connected"`
+	MinSize   int       `json:"minSize"`
+	IdleWait  int       `json:"idleWait"`
+	IdleSize  int       `json:"idleSize"`
+	Client    any       `json:"client"`
+	Sizes     *PoolSize `json:"sizes"`
+	Degraded  bool      `json:"degraded"`
+}
+
+// QueueState contains the depths of the server's internal queues.
+type QueueState struct {
+	Registrations    int   `json:"registrations"`
+	RegistrationsCap int   `json:"registrationsCap"`
+	Dispatching      int64 `json:"dispatching"`
+}
+
+// State returns a snapshot of the server's internal state with secrets redacted.
+// Do not call this before StartDispatcher, or after Shutdown.
+func (s *Server) State() *State {
+	s.getState <- struct{}{}
+	return <-s.repState
+}
+
+// HandleState dumps the server's internal state as a single JSON document.
+func (s *Server) HandleState(resp http.ResponseWriter, _ *http.Request) {
+	resp.Header().Set("Content-Type", "application/json")
+
+	encoder := json.NewEncoder(resp)
+	encoder.SetIndent("", " ")
+
+	if err := encoder.Encode(s.State()); err != nil {
+		http.Error(resp, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// state runs in the main dispatcher loop, so it is consistent.
+func (s *Server) state() *State {
+	now := time.Now()
+	state := &State{
+		Time:    now,
+		Config:  s.Config.Redacted(),
+		Pools:   make(map[clientID]*PoolState, s.pools.len()),
+		Threads: s.threadStats(),
+		Closed:  s.closed,
+		Queues: &QueueState{
+			Registrations:    len(s.newPool),
+			RegistrationsCap: cap(s.newPool),
+			Dispatching:      s.dispatching.Load(),
+		},
+	}
+
+	for cID, pool := range s.pools.snapshot() {
+		pool.idleMu.RLock()
+		state.Pools[cID] = &PoolState{
+			ID:        pool.id,
+			Connected: pool.connected,
+			MinSize:   pool.minSize,
+			IdleWait:  len(pool.idle),
+			IdleSize:  cap(pool.idle),
+			Client:    pool.handshake,
+			Degraded:  pool.Degraded(),
+		}
+		pool.idleMu.RUnlock()
+		state.Pools[cID].Sizes = pool.Size(now)
+	}
+
+	return state
+}
+
+// Redacted returns a copy of the config with secrets removed.
+func (c *Config) Redacted() *Config {
+	config := *c
+	if config.SecretKey != "" {
+		config.SecretKey = redacted
+	}
+
+	return &config
+}
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// statsQuery filters and paginates the pools in the stats output.
+type statsQuery struct {
+	client  clientID // only this pool.
+	prefix  string   // only pools with IDs starting with this.
+	limit   int      // at most this many pools, 0 is unlimited.
+	offset  int      // skip this many pools (sorted by ID).
+	summary bool     // only totals, no pools.
+}
+
+// HandleStats returns pool and connection stats as JSON. Supported query parameters:
+//   - client: only show this client ID (also read from the IDHeader).
+//   - prefix: only show client IDs that begin with this prefix.
+//   - limit and offset: paginate the pools, sorted by client ID.
+//   - summary: if true, only show totals, and no pools.
+func (s *Server) HandleStats(resp http.ResponseWriter, req *http.Request) {
+	query, err := parseStatsQuery(req)
+	if err != nil {
+		http.Error(resp, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if query.client == "" {
+		query.client = clientID(req.Header.Get(s.Config.IDHeader))
+	}
+
+	s.getStats <- query                                                // ask for stats.
+	if err := json.NewEncoder(resp).Encode(<-s.repStats); err != nil { // send stats
+		http.Error(resp, err.Error(), http.StatusInternalServerError) // oops, error.
+	}
+}
+
+func parseStatsQuery(req *http.Request) (*statsQuery, error) {
+	var (
+		values = req.URL.Query()
+		query  = &statsQuery{
+			client: clientID(values.Get("client")),
+			prefix: values.Get("prefix"),
+		}
+		err error
+	)
+
+	if val := values.Get("limit"); val != "" {
+		if query.limit, err = strconv.Atoi(val); err != nil || query.limit < 0 {
+			return nil, fmt.Errorf("%w: invalid limit: %s", ErrInvalidData, val)
+		}
+	}
+
+	if val := values.Get("offset"); val != "" {
+		if query.offset, err = strconv.Atoi(val); err != nil || query.offset < 0 {
+			return nil, fmt.Errorf("%w: invalid offset: %s", ErrInvalidData, val)
+		}
+	}
+
+	if val := values.Get("summary"); val != "" {
+		if query.summary, err = strconv.ParseBool(val); err != nil {
+			return nil, fmt.Errorf("%w: invalid summary: %s", ErrInvalidData, val)
+		}
+	}
+
+	return query, nil
+}
+
+// stats runs in the main dispatcher loop.
+func (s *Server) stats(query *statsQuery) *Stats {
+	ids := s.matchPools(query)
+	stats := &Stats{
+		Threads: s.threadStats(),
+		Matched: len(ids),
+	}
+
+	if query.summary {
+		stats.Summary = s.summarize(ids)
+		return stats
+	}
+
+	if query.client != "" {
+		if len(ids) == 0 {
+			stats.Pools = map[clientID]any{"id not found": nil}
+		} else {
+			stats.Pools = map[clientID]any{query.client: s.pools.get(query.client).size(time.Now())}
+		}
+
+		return stats
+	}
+
+	if query.offset >= len(ids) {
+		ids = nil
+	} else {
+		ids = ids[query.offset:]
+	}
+
+	if query.limit > 0 && query.limit < len(ids) {
+		ids = ids[:query.limit]
+	}
+
+	stats.Pools = s.poolStats(ids)
+
+	return stats
+}
+
+// matchPools returns the sorted IDs of the pools matching the query filters.
+func (s *Server) matchPools(query *statsQuery) []clientID {
+	if query.client != "" {
+		if s.pools.get(query.client) == nil || !strings.HasPrefix(string(query.client), query.prefix) {
+			return nil
+		}
+
+		return []clientID{query.client}
+	}
+
+	pools := s.pools.snapshot()
+	ids := make([]clientID, 0, len(pools))
+
+	for cID := range pools {
+		if strings.HasPrefix(string(cID), query.prefix) {
+			ids = append(ids, cID)
+		}
+	}
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	return ids
+}
+
+// summarize adds up the sizes of the provided pools.
+func (s *Server) summarize(ids []clientID) *PoolSize {
+	now := time.Now()
+	totals := &PoolSize{Conns: []*ConnStats{}}
+
+	for _, cID := range ids {
+		size := s.pools.get(cID).size(now)
+		totals.Total += size.Total
+		totals.Idle += size.Idle
+		totals.Busy += size.Busy
+		totals.Closed += size.Closed
+		totals.BytesSent += size.BytesSent
+		totals.BytesRecv += size.BytesRecv
+	}
+
+	return totals
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "golift.io/mulery/server"
+
+// tracer wraps the optional OpenTelemetry instrumentation.
+// A nil tracer means tracing is disabled, and all methods are no-ops.
+type tracer struct {
+	trace.Tracer
+	propagation.TextMapPropagator
+}
+
+func newTracer(config *Config) *tracer {
+	if config.TracerProvider == nil {
+		return nil
+	}
+
+	propagator := config.Propagator
+	if propagator == nil {
+		propagator = propagation.TraceContext{}
+	}
+
+	return &tracer{
+		Tracer:            config.TracerProvider.Tracer(tracerName),
+		TextMapPropagator: propagator,
+	}
+}
+
+// start continues an upstream trace (if any) and starts a span for a proxied request.
+// The returned request carries the span in its context.
+func (t *tracer) start(req *http.Request, name string) (*http.Request, trace.Span) {
+	if t == nil {
+		return req, trace.SpanFromContext(req.Context())
+	}
+
+	ctx := t.Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+	ctx, span := t.Start(ctx, "mulery.proxy "+name, trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("http.request.method", req.Method),
+			attribute.String("url.full", req.URL.String()),
+		))
+
+	return req.WithContext(ctx), span
+}
+
+// inject returns the propagation fields for the span in ctx.
+// These are sent to the client in the serialized request.
+func (t *tracer) inject(ctx context.Context) map[string]string {
+	if t == nil {
+		return nil
+	}
+
+	carrier := propagation.MapCarrier{}
+	t.Inject(ctx, carrier)
+
+	return carrier
+}
+
+// fail marks a span as failed.
+func fail(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
@@ -0,0 +1,182 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golift.io/mulery/mulch"
+)
+
+// Transport is an http.RoundTripper that sends requests through a connected client's tunnel.
+// Go programs that embed the server use this to reach a client's backend without a round trip
+// through the HTTP request handler. Requests need an absolute URL, unless the client has a LocalTarget.
+type Transport struct {
+	server *Server
+	client clientID
+}
+
+// NewTransport returns a RoundTripper for a client. The client ID is the pool ID, like the value of
+// the server's IDHeader, and it is required: requests through a transport without one return ErrNoClientID.
+// The dispatcher must be running.
+//
+//	httpClient := &http.Client{Transport: server.NewTransport(srv, clientID)}
+func NewTransport(srv *Server, client string) http.RoundTripper {
+	return &Transport{server: srv, client: clientID(client)}
+}
+
+// RoundTrip sends a request through a tunnel connection to the client. The response body streams
+// from the tunnel, and the connection is returned to its pool when the body is read to the end.
+// If the body is closed early, the rest of it is read from the tunnel and discarded, so the
+// connection can be returned. A client that cannot execute the request answers with a 502 or
+// 504 response, not an error.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.client == "" {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+
+		return nil, fmt.Errorf("%w: transport has no client ID", ErrNoClientID)
+	}
+
+	original := req
+	req, span := t.server.tracer.start(req.Clone(req.Context()), "transport")
+	if req.Body == nil {
+		req.Body = http.NoBody
+	}
+
+	req.Header.Set(mulch.RequestIDHeader, mulch.RequestID(req.Header))
+	event := &RequestEvent{
+		ClientID:  string(t.client),
+		Method:    req.Method,
+		URL:       req.URL.String(),
+		Start:     time.Now(),
+		RequestID: req.Header.Get(mulch.RequestIDHeader),
+	}
+
+	connection, degraded := t.server.getConnection(req.Context(), t.client)
+	if connection == nil {
+		err := fmt.Errorf("%w: %s", ErrNoProxyTarget, t.client)
+		if degraded {
+			err = fmt.Errorf("%w: %s", ErrDegraded, t.client)
+		}
+
+		req.Body.Close()
+		fail(span, err)
+		span.End()
+		t.server.observeError(event, err)
+
+		return nil, err
+	}
+
+	t.server.observeDispatch(event)
+
+	reader, writer := io.Pipe()
+	resp := &transportWriter{
+		req:    original,
+		header: http.Header{mulch.RequestIDHeader: {event.RequestID}},
+		body:   reader,
+		pipe:   writer,
+		ready:  make(chan *http.Response, 1),
+		failed: make(chan error, 1),
+	}
+
+	go func() {
+		defer span.End()
+		defer req.Body.Close()
+
+		err := connection.proxyRequest(resp, req, event)
+		if err == nil {
+			t.server.observeResponse(event)
+			writer.Close()
+
+			return
+		}
+
+		connection.Close(mulch.CloseProxyError, err.Error())
+		err = fmt.Errorf("tunneling failure, connection closed: %w", err)
+		fail(span, err)
+		t.server.observeError(event, err)
+		writer.CloseWithError(err)
+
+		if !resp.wrote {
+			resp.failed <- err
+		}
+	}()
+
+	select {
+	case response := <-resp.ready:
+		return response, nil
+	case err := <-resp.failed:
+		return nil, err
+	}
+}
+
+// transportWriter is the http.ResponseWriter a Transport's tunneled response is written to.
+// The response is sent to RoundTrip when the headers are written, and the body streams through a pipe.
+// Only the proxy go routine uses it, so it needs no lock.
+type transportWriter struct {
+	req    *http.Request
+	header http.Header
+	body   *io.PipeReader
+	pipe   *io.PipeWriter
+	ready  chan *http.Response
+	failed chan error
+	wrote  bool
+	closed bool // the caller closed the body; the rest is discarded.
+}
+
+func (w *transportWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *transportWriter) WriteHeader(statusCode int) {
+	if w.wrote {
+		return
+	}
+
+	w.wrote = true
+
+	size, err := strconv.ParseInt(w.header.Get("Content-Length"), 10, 64)
+	if err != nil {
+		size = -1
+	}
+
+	w.ready <- &http.Response{
+		Status:        strconv.Itoa(statusCode) + " " + http.StatusText(statusCode),
+		StatusCode:    statusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        w.header.Clone(),
+		Body:          w.body,
+		ContentLength: size,
+		Request:       w.req,
+	}
+}
+
+func (w *transportWriter) Write(data []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+
+	if w.closed {
+		return len(data), nil
+	}
+
+	size, err := w.pipe.Write(data)
+	if errors.Is(err, io.ErrClosedPipe) {
+		// The caller is done with the body. Drain the tunnel, so the connection stays usable.
+		w.closed = true
+		return len(data), nil
+	}
+
+	return size, err //nolint:wrapcheck // the proxy wraps it.
+}
+
+// Flush sends the response to RoundTrip if the headers were not written yet.
+// Written data is always flushed: the pipe has no buffer.
+func (w *transportWriter) Flush() {
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,125 @@
+package server_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"golift.io/mulery/mulch"
+	"golift.io/mulery/server"
+)
+
+func TestTransport(t *testing.T) {
+	t.Parallel()
+
+	env := startEnv(t)
+	httpClient := &http.Client{Transport: server.NewTransport(env.srv, testClientID)}
+
+	req, _ := http.NewRequestWithContext(context.Background(), http.MethodPost, "http://backend/echo", strings.NewReader("hello"))
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if string(body) != "hello" || resp.Header.Get("X-Method") != http.MethodPost || resp.StatusCode != http.StatusOK {
+		t.Errorf("echo: got %d %q %q", resp.StatusCode, resp.Header.Get("X-Method"), body)
+	}
+
+	if resp.Header.Get(mulch.RequestIDHeader) == "" {
+		t.Errorf("response has no request ID")
+	}
+
+	req, _ = http.NewRequestWithContext(context.Background(), http.MethodGet, "http://backend/teapot", nil)
+
+	resp, err = httpClient.Do(req)
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusTeapot {
+		t.Errorf("teapot: got %d", resp.StatusCode)
+	}
+}
+
+func TestTransportNoClient(t *testing.T) {
+	t.Parallel()
+
+	env := startEnv(t)
+
+	for clientID, want := range map[string]error{"": server.ErrNoClientID, "missing": server.ErrNoProxyTarget} {
+		req, _ := http.NewRequestWithContext(context.Background(), http.MethodGet, "http://backend/", nil)
+
+		resp, err := server.NewTransport(env.srv, clientID).RoundTrip(req)
+		if err == nil {
+			resp.Body.Close()
+		}
+
+		if !errors.Is(err, want) {
+			t.Errorf("client %q: got error %v, want %v", clientID, err, want)
+		}
+	}
+}
+
+// TestTransportBodyClosed makes sure a body closed early does not close the tunnel connection.
+func TestTransportBodyClosed(t *testing.T) {
+	t.Parallel()
+
+	env := startEnv(t)
+	httpClient := &http.Client{Transport: server.NewTransport(env.srv, testClientID)}
+
+	const requestID = "transport-body-closed"
+
+	done := env.observer.wait(requestID)
+	req, _ := http.NewRequestWithContext(context.Background(), http.MethodGet, "http://backend/big", nil)
+	req.Header.Set(mulch.RequestIDHeader, requestID)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+
+	if _, err := io.ReadFull(resp.Body, make([]byte, 1024)); err != nil { //nolint:gomnd
+		t.Fatalf("reading body: %v", err)
+	}
+
+	resp.Body.Close()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("closing the body early failed the request: %v", err)
+		}
+	case <-time.After(10 * time.Second): //nolint:gomnd
+		t.Fatal("request did not finish after the body was closed")
+	}
+}
+
+// TestTransportHandlerPanic makes sure a handler that panics after it sent the headers does not send a complete body.
+func TestTransportHandlerPanic(t *testing.T) {
+	t.Parallel()
+
+	env := startEnv(t)
+	httpClient := &http.Client{Transport: server.NewTransport(env.srv, testClientID)}
+
+	req, _ := http.NewRequestWithContext(context.Background(), http.MethodGet, "http://backend/panic", nil)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return // the headers did not arrive; that is not a complete response either.
+	}
+	defer resp.Body.Close()
+
+	if body, err := io.ReadAll(resp.Body); err == nil {
+		t.Errorf("panic after the headers: got a complete %d response: %q", resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,95 @@
+package mulery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Service states sent to systemd. See sd_notify(3).
+const (
+	sdReady     = "READY=1"
+	sdReloading = "RELOADING=1"
+	sdStopping  = "STOPPING=1"
+	sdWatchdog  = "WATCHDOG=1"
+)
+
+// notify sends a state to systemd. Does nothing unless systemd started us with a NOTIFY_SOCKET,
+// like it does for Type=notify services.
+func (c *Config) notify(state string) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return
+	}
+
+	if err := sdNotify(socket, state); err != nil {
+		c.Errorf("Notifying systemd (%s): %v", state, err)
+	}
+}
+
+func sdNotify(socket, state string) error {
+	if socket[0] == '@' { // abstract socket.
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return fmt.Errorf("dialing notify socket: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err = conn.Write([]byte(state)); err != nil {
+		return fmt.Errorf("writing notify socket: %w", err)
+	}
+
+	return nil
+}
+
+// watchdogInterval returns how often systemd expects a watchdog ping, or 0 if it does not.
+// We ping twice as often, like sd_watchdog_enabled(3) recommends.
+func watchdogInterval() time.Duration {
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0 // for another process.
+	}
+
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+
+	return time.Duration(usec) * time.Microsecond / 2 //nolint:gomnd
+}
+
+// startWatchdog pings the systemd watchdog while the dispatcher answers, so systemd
+// restarts us if it deadlocks. Set WatchdogSec in the unit file to enable this.
+func (c *Config) startWatchdog() {
+	interval := watchdogInterval()
+	if interval == 0 || os.Getenv("NOTIFY_SOCKET") == "" {
+		return
+	}
+
+	c.watchdog = make(chan struct{})
+	c.Printf("Pinging systemd watchdog every %v", interval)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-c.watchdog:
+				return
+			case <-ticker.C:
+				if check, _ := c.checkDispatcher(context.Background()); check.Status != HealthOK {
+					c.Errorf("Skipping systemd watchdog ping: %s", check.Message)
+					continue
+				}
+
+				c.notify(sdWatchdog)
+			}
+		}
+	}()
+}
@@ -0,0 +1,161 @@
+package mulery
+
+import (
+	"context"
+	"crypto/tls"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/caddyserver/certmagic"
+	"github.com/libdns/cloudflare"
+)
+
+// redirectTimeout is used for every timeout on the HTTP redirect listener.
+const redirectTimeout = 5 * time.Second
+
+// ACME challenge types for Config.ACMEChallenge.
+const (
+	ChallengeDNS     = "dns"      // DNS-01 with cloudflare. This is the default, and requires cf_token.
+	ChallengeHTTP    = "http"     // HTTP-01. Port 80 must be reachable; use redirect_http, or certmagic binds it.
+	ChallengeTLSALPN = "tls-alpn" // TLS-ALPN-01. Port 443 must be reachable on the TLS listener.
+)
+
+// certFilesEnabled returns true if static certificate files are configured.
+func (c *Config) certFilesEnabled() bool {
+	return c.SSLCertFile != "" && c.SSLKeyFile != ""
+}
+
+// needsCFToken returns true if acme uses dns challenges, which is the default for unknown values.
+func (c *Config) needsCFToken() bool {
+	return c.ACMEChallenge != ChallengeHTTP && c.ACMEChallenge != ChallengeTLSALPN
+}
+
+// acmeEnabled returns true if certmagic should manage the certificates.
+func (c *Config) acmeEnabled() bool {
+	return c.CacheDir != "" && len(c.SSLNames) > 0 && (c.CFToken != "" || !c.needsCFToken())
+}
+
+// tlsEnabled returns true if the TLS listeners will have a certificate.
+func (c *Config) tlsEnabled() bool {
+	return c.certFilesEnabled() || c.acmeEnabled()
+}
+
+// setupTLS loads the SSL certificate files if they are provided, otherwise it
+// creates TLS certificates if a Cache dir, SSL Names, and CF Token (for dns challenges) are provided.
+// Returns nil if TLS is not configured. Starts the HTTP redirect listener if one is configured.
+func (c *Config) setupTLS() *tls.Config {
+	if c.certFilesEnabled() {
+		return c.setupCertFiles()
+	}
+
+	if !c.acmeEnabled() {
+		return nil
+	}
+
+	certmagic.DefaultACME.Email = c.Email
+	certmagic.DefaultACME.Agreed = true
+	certmagic.Default.Storage = &certmagic.FileStorage{Path: c.CacheDir}
+
+	switch c.ACMEChallenge {
+	case ChallengeHTTP:
+		certmagic.DefaultACME.DisableTLSALPNChallenge = true
+	case ChallengeTLSALPN:
+		certmagic.DefaultACME.DisableHTTPChallenge = true
+	default:
+		certmagic.DefaultACME.DNS01Solver = &certmagic.DNS01Solver{
+			DNSProvider: &cloudflare.Provider{APIToken: c.CFToken},
+		}
+		// Like certmagic.TLS(), unless we have a listener on port 80 to answer them.
+		certmagic.DefaultACME.DisableHTTPChallenge = c.RedirectHTTP == ""
+	}
+
+	cfg := certmagic.NewDefault()
+
+	if c.RedirectHTTP != "" {
+		handler := http.Handler(http.HandlerFunc(c.redirectHTTPS))
+
+		if len(cfg.Issuers) > 0 {
+			if issuer, ok := cfg.Issuers[0].(*certmagic.ACMEIssuer); ok {
+				handler = issuer.HTTPChallengeHandler(handler)
+			}
+		}
+
+		// The challenge handler must be listening before we ask for certificates.
+		c.startRedirect(handler)
+	}
+
+	if err := cfg.ManageSync(context.Background(), c.SSLNames); err != nil {
+		log.Fatalln("CertMagic TLS config failed:", err)
+	}
+
+	// This includes the acme-tls/1 protocol, so the TLS listener answers TLS-ALPN challenges.
+	return cfg.TLSConfig()
+}
+
+// setupCertFiles returns a TLS config that serves the certificate files, and reloads them when they change.
+func (c *Config) setupCertFiles() *tls.Config {
+	var err error
+	if c.certs, err = newCertReloader(c); err != nil {
+		log.Fatalln("SSL certificate files failed:", err)
+	}
+
+	if c.RedirectHTTP != "" {
+		c.startRedirect(http.HandlerFunc(c.redirectHTTPS))
+	}
+
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: c.certs.getCertificate,
+	}
+}
+
+// startRedirect starts the plain HTTP redirect listener.
+// This runs before the other listeners, so acme can solve challenges before they start.
+func (c *Config) startRedirect(handler http.Handler) {
+	srv := &http.Server{
+		ErrorLog:          c.log,
+		Addr:              c.RedirectHTTP,
+		Handler:           handler,
+		ReadHeaderTimeout: redirectTimeout,
+		ReadTimeout:       redirectTimeout,
+		WriteTimeout:      redirectTimeout,
+		IdleTimeout:       redirectTimeout,
+	}
+
+	c.redirect = srv
+	go c.runWebServer(srv, c.listen(srv))
+}
+
+// Reload re-reads the SSL certificate files, if they are configured. Call this on SIGHUP.
+func (c *Config) Reload() {
+	c.notify(sdReloading)
+	defer c.notify(sdReady)
+
+	if c.certs == nil {
+		return
+	}
+
+	if err := c.certs.reload(); err != nil {
+		c.Errorf("Reloading SSL certificate (keeping the old one): %v", err)
+	} else {
+		c.Printf("Reloaded SSL certificate: %s", c.SSLCertFile)
+	}
+}
+
+// redirectHTTPS sends plain HTTP requests to the TLS listener.
+func (c *Config) redirectHTTPS(resp http.ResponseWriter, req *http.Request) {
+	host := req.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	// Keep the listen port in the redirect, unless it's the default.
+	if _, port, err := net.SplitHostPort(c.ListenAddr); err == nil && port != "443" && port != "" {
+		host = net.JoinHostPort(host, port)
+	}
+
+	resp.Header().Set("Connection", "close")
+	http.Redirect(resp, req, "https://"+host+req.URL.RequestURI(), http.StatusMovedPermanently)
+}
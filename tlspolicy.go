@@ -0,0 +1,85 @@
+package mulery
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+)
+
+// ErrTLSPolicy is returned when the TLS policy configuration is invalid.
+var ErrTLSPolicy = errors.New("invalid tls policy")
+
+// tlsVersions are the accepted values for TLSMinVersion.
+//
+//nolint:gochecknoglobals
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// parseTLSPolicy checks the TLS policy configuration, so typos are found at startup.
+func (c *Config) parseTLSPolicy() error {
+	if _, ok := tlsVersions[c.TLSMinVersion]; c.TLSMinVersion != "" && !ok {
+		return fmt.Errorf("%w: tls_min_version '%s' is not 1.0, 1.1, 1.2 or 1.3", ErrTLSPolicy, c.TLSMinVersion)
+	}
+
+	_, err := cipherSuites(c.TLSCiphers)
+
+	return err
+}
+
+// cipherSuites returns the IDs for cipher suite names. Insecure suites are allowed, but must be named.
+func cipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	known := make(map[string]uint16)
+	for _, suite := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		known[suite.Name] = suite.ID
+	}
+
+	ids := make([]uint16, len(names))
+
+	for idx, name := range names {
+		id, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown tls cipher suite: %s", ErrTLSPolicy, name)
+		}
+
+		ids[idx] = id
+	}
+
+	return ids, nil
+}
+
+// applyTLSPolicy sets the configured minimum version, cipher suites and ALPN protocols on a TLS config.
+func (c *Config) applyTLSPolicy(tlsConfig *tls.Config) *tls.Config {
+	if tlsConfig == nil {
+		return nil
+	}
+
+	if version, ok := tlsVersions[c.TLSMinVersion]; ok {
+		tlsConfig.MinVersion = version
+	}
+
+	if ciphers, _ := cipherSuites(c.TLSCiphers); len(ciphers) > 0 {
+		tlsConfig.CipherSuites = ciphers // TLS 1.3 suites are not configurable, and ignore this.
+	}
+
+	if len(c.TLSALPN) > 0 {
+		// Keep acme-tls/1 (from certmagic) so TLS-ALPN challenges still work.
+		protos := []string(c.TLSALPN)
+		for _, proto := range tlsConfig.NextProtos {
+			if proto == "acme-tls/1" && !c.TLSALPN.Contains(proto) {
+				protos = append(protos, proto)
+			}
+		}
+
+		tlsConfig.NextProtos = protos
+	}
+
+	return tlsConfig
+}
@@ -1,9 +1,12 @@
 package mulery
 
 import (
+	"crypto/subtle"
+	"encoding/json"
 	"fmt"
 	"net"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
 )
@@ -24,6 +27,56 @@ func (c *Config) HandleOK(resp http.ResponseWriter, _ *http.Request) {
 	http.Error(resp, "OK", http.StatusOK)
 }
 
+// HandleState dumps the app config and the server's internal state with secrets redacted.
+func (c *Config) HandleState(resp http.ResponseWriter, req *http.Request) {
+	c.auditAdmin(AuditAdminState, req)
+	resp.Header().Set("Content-Type", "application/json")
+
+	encoder := json.NewEncoder(resp)
+	encoder.SetIndent("", " ")
+
+	err := encoder.Encode(map[string]any{
+		"app":    c.Redacted(),
+		"server": c.dispatch.State(),
+	})
+	if err != nil {
+		http.Error(resp, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// Redacted returns a copy of the app config with secrets removed.
+func (c *Config) Redacted() *Config {
+	const redacted = "********"
+
+	config := *c
+	config.Config = c.Config.Redacted()
+
+	if config.CFToken != "" {
+		config.CFToken = redacted
+	}
+
+	if parsed, err := url.Parse(config.KeyCacheRedis); err == nil && config.KeyCacheRedis != "" {
+		config.KeyCacheRedis = parsed.Redacted()
+	}
+
+	if config.Discovery != nil && config.Discovery.Token != "" {
+		discovery := *config.Discovery
+		discovery.Token = redacted
+		config.Discovery = &discovery
+	}
+
+	if config.PeerToken != "" {
+		config.PeerToken = redacted
+	}
+
+	if len(config.MonitorTokens) > 0 {
+		config.MonitorTokens = StringSlice{redacted}
+	}
+
+	return &config
+}
+
+// ValidateUpstream only allows requests from the upstream allow list.
 func (c *Config) ValidateUpstream(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
 		if c.allow.Contains(req.RemoteAddr) {
@@ -34,6 +87,34 @@ func (c *Config) ValidateUpstream(next http.Handler) http.Handler {
 	})
 }
 
+// ValidateMonitor allows requests from the upstream allow list, or with a valid bearer token.
+// This protects the stats, state and metrics handlers. Tokens do not allow tunnel requests.
+func (c *Config) ValidateMonitor(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if c.allow.Contains(req.RemoteAddr) || c.validToken(req) {
+			next.ServeHTTP(resp, req)
+		} else {
+			c.HandleAll(resp, req)
+		}
+	})
+}
+
+// validToken returns true if the request has a bearer token that matches a monitor token.
+func (c *Config) validToken(req *http.Request) bool {
+	token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return false
+	}
+
+	for _, valid := range c.MonitorTokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(valid)) == 1 {
+			return true
+		}
+	}
+
+	return false
+}
+
 // AllowedIPs determines who make can requests.
 type AllowedIPs struct {
 	askIP chan string
@@ -82,8 +163,11 @@ func MakeIPs(upstreams []string) *AllowedIPs {
 		nets:  make([]*net.IPNet, len(upstreams)),
 	}
 	allowed.parseAndLookup(upstreams)
+	// Make the channels here, so Contains works as soon as this returns.
+	allowed.askIP = make(chan string)
+	allowed.allow = make(chan bool)
 
-	go allowed.Start()
+	go allowed.run()
 
 	return allowed
 }
@@ -124,6 +208,10 @@ func (n *AllowedIPs) Start() {
 
 	n.askIP = make(chan string)
 	n.allow = make(chan bool)
+	n.run()
+}
+
+func (n *AllowedIPs) run() {
 	ticker := time.NewTicker(dnsRefreshInterval)
 
 	defer func() {
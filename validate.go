@@ -0,0 +1,76 @@
+package mulery
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidConfig is wrapped by every error Validate returns.
+var ErrInvalidConfig = errors.New("invalid config")
+
+// Validate returns an error for every setting that keeps the app from working. This binds no ports.
+// LoadConfigFile already returned errors for settings it could not parse; Lint returns warnings.
+func (c *Config) Validate() error {
+	var errs []error
+
+	invalid := func(format string, v ...any) {
+		errs = append(errs, fmt.Errorf("%w: "+format, append([]any{ErrInvalidConfig}, v...)...))
+	}
+
+	for idx, input := range c.allow.input {
+		if c.allow.nets[idx] == nil {
+			invalid("upstream '%s' is not a valid IP or CIDR and failed DNS lookup", input)
+		}
+	}
+
+	for idx, input := range c.trusted.input {
+		if c.trusted.nets[idx] == nil {
+			invalid("trusted proxy '%s' is not a valid IP or CIDR and failed DNS lookup", input)
+		}
+	}
+
+	if len(c.authProxies) == 0 {
+		invalid("auth_url is empty: every client registration will fail")
+	} else if c.AuthHeader == "" {
+		invalid("auth_url is set without auth_header: the auth proxy will not receive client keys")
+	}
+
+	if c.ListenAddr == "" && len(c.Listeners) == 0 {
+		invalid("listen_addr is empty and no listeners are configured: nothing is served")
+	}
+
+	if err := c.uniquePaths(); err != nil {
+		errs = append(errs, fmt.Errorf("%w: %w", ErrInvalidConfig, err))
+	}
+
+	c.validateTLS(invalid)
+
+	return errors.Join(errs...)
+}
+
+// validateTLS reports TLS settings that cannot work together.
+func (c *Config) validateTLS(invalid func(format string, v ...any)) {
+	switch {
+	case (c.SSLCertFile == "") != (c.SSLKeyFile == ""):
+		invalid("ssl_cert_file and ssl_key_file must both be set to use a static certificate")
+	case c.SSLCertFile != "":
+		if _, err := tls.LoadX509KeyPair(c.SSLCertFile, c.SSLKeyFile); err != nil {
+			invalid("loading ssl_cert_file and ssl_key_file: %v", err)
+		}
+	}
+
+	if c.SSLCertFile != "" && len(c.SSLNames) > 0 {
+		invalid("ssl_cert_file and ssl_names are both set: use a static certificate or acme, not both")
+	}
+
+	for _, listener := range c.Listeners {
+		if listener.TLS && !c.tlsEnabled() {
+			invalid("listener %s has tls enabled, but SSL is not configured", listener.Addr)
+		}
+	}
+
+	if c.RedirectHTTP != "" && c.RedirectHTTP == c.ListenAddr {
+		invalid("redirect_http and listen_addr are both %s: they cannot share an address", c.ListenAddr)
+	}
+}
@@ -0,0 +1,17 @@
+package mulery
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"golift.io/mulery/mulch"
+)
+
+// HandleVersion reports the version, commit, Go version and start time of this binary.
+func (c *Config) HandleVersion(resp http.ResponseWriter, _ *http.Request) {
+	resp.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(resp).Encode(mulch.ReadBuildInfo()); err != nil {
+		c.Errorf("Encoding version: %v", err)
+	}
+}
@@ -0,0 +1,94 @@
+package mulery
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"golift.io/mulery/mulch"
+)
+
+// vhostHandler is the label used in request metrics for requests routed by hostname.
+const vhostHandler = "/vhost"
+
+// proxyDestinationHeader is the header the request handler reads the destination URL from.
+const proxyDestinationHeader = "X-Proxy-Destination"
+
+// vhostClient returns the client ID configured for the request's Host header, if there is one.
+// Explicit vhosts are checked first, then the wildcard pattern's label in VhostLabels.
+func (c *Config) vhostClient(req *http.Request) string {
+	if len(c.Vhosts) == 0 && c.wildcard == "" {
+		return ""
+	}
+
+	host := req.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	host = normalizeHost(host)
+	if clientID := c.vhosts[host]; clientID != "" {
+		return clientID
+	}
+
+	if c.wildcard == "" || !strings.HasSuffix(host, c.wildcard) {
+		return ""
+	}
+
+	// Only one label is allowed in place of the *.
+	if label := strings.TrimSuffix(host, c.wildcard); label != "" && !strings.Contains(label, ".") {
+		return c.labels[label]
+	}
+
+	return ""
+}
+
+// parseVhosts normalizes the configured hostnames so lookups ignore case and trailing dots.
+func (c *Config) parseVhosts() {
+	c.vhosts = make(map[string]string, len(c.Vhosts))
+
+	for host, clientID := range c.Vhosts {
+		c.vhosts[normalizeHost(host)] = clientID
+	}
+
+	if strings.HasPrefix(c.VhostWildcard, "*.") {
+		c.wildcard = normalizeHost(strings.TrimPrefix(c.VhostWildcard, "*"))
+	}
+
+	c.labels = make(map[string]string, len(c.VhostLabels))
+
+	for label, clientID := range c.VhostLabels {
+		c.labels[normalizeHost(label)] = clientID
+	}
+}
+
+func normalizeHost(host string) string {
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+// VhostRouter sends requests for configured hostnames directly to the matching client pool.
+// These requests are not checked against the upstream allow list; this is a public ingress.
+// Only requests from the upstream allow list may pick the destination URL or the timeout.
+// All other requests are passed to next.
+func (c *Config) VhostRouter(next, vhost http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		clientID := c.vhostClient(req)
+		if clientID == "" {
+			next.ServeHTTP(resp, req)
+			return
+		}
+
+		// Overwrite whatever the requester put here, so they cannot pick another client.
+		if c.IDHeader != "" {
+			req.Header.Set(c.IDHeader, clientID)
+		}
+
+		// The public cannot pick a backend on the client's network, or hold a tunnel open.
+		if !c.allow.Contains(req.RemoteAddr) {
+			req.Header.Del(proxyDestinationHeader)
+			req.Header.Del(mulch.TimeoutHeader)
+		}
+
+		vhost.ServeHTTP(resp, req)
+	})
+}
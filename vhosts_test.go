@@ -0,0 +1,91 @@
+package mulery
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golift.io/mulery/mulch"
+	"golift.io/mulery/server"
+)
+
+func TestVhostRouterDestination(t *testing.T) {
+	t.Parallel()
+
+	config := &Config{
+		Config: &server.Config{IDHeader: "X-Client-Id"},
+		Vhosts: map[string]string{"app.example.com": "client1"},
+		allow:  MakeIPs([]string{"10.0.0.1"}),
+	}
+	config.parseVhosts()
+	t.Cleanup(config.allow.Stop)
+
+	var got http.Header
+
+	router := config.VhostRouter(http.NotFoundHandler(), http.HandlerFunc(func(_ http.ResponseWriter, req *http.Request) {
+		got = req.Header.Clone()
+	}))
+
+	tests := []struct {
+		name   string
+		remote string
+		keep   bool
+	}{
+		{name: "public", remote: "192.0.2.1:1234", keep: false},
+		{name: "upstream", remote: "10.0.0.1:1234", keep: true},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, "http://app.example.com/path", nil)
+		req.RemoteAddr = test.remote
+		req.Header.Set(proxyDestinationHeader, "http://169.254.169.254/latest/meta-data/")
+		req.Header.Set(mulch.TimeoutHeader, "1h")
+		req.Header.Set("X-Client-Id", "client2")
+
+		got = nil
+		router.ServeHTTP(httptest.NewRecorder(), req)
+
+		if got == nil {
+			t.Fatalf("%s: vhost handler was not called", test.name)
+		}
+
+		if id := got.Get("X-Client-Id"); id != "client1" {
+			t.Errorf("%s: client ID header: got %q, want client1", test.name, id)
+		}
+
+		if dst := got.Get(proxyDestinationHeader); (dst != "") != test.keep {
+			t.Errorf("%s: destination header: got %q, keep: %v", test.name, dst, test.keep)
+		}
+
+		if timeout := got.Get(mulch.TimeoutHeader); (timeout != "") != test.keep {
+			t.Errorf("%s: timeout header: got %q, keep: %v", test.name, timeout, test.keep)
+		}
+	}
+}
+
+func TestVhostClientWildcard(t *testing.T) {
+	t.Parallel()
+
+	poolID := strings.Repeat("a", 64) //nolint:gomnd // pool IDs are sha256 hex.
+	config := &Config{
+		VhostWildcard: "*.tunnel.example.com",
+		VhostLabels:   map[string]string{"Agent1": poolID},
+	}
+	config.parseVhosts()
+
+	for host, want := range map[string]string{
+		"agent1.tunnel.example.com":      poolID,
+		"AGENT1.tunnel.example.com.:443": poolID,
+		"agent2.tunnel.example.com":      "",
+		"a.agent1.tunnel.example.com":    "",
+		"tunnel.example.com":             "",
+	} {
+		req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+		req.Host = host
+
+		if got := config.vhostClient(req); got != want {
+			t.Errorf("host %s: got %q, want %q", host, got, want)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package mulery
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// exampleKey is the map key used to show the settings in a table of tables, like error_pages.
+const exampleKey = "*"
+
+// WriteConfigFile writes an example config file with every setting commented out and set to its default.
+// The settings come from the Config struct, so the example never falls behind the code.
+// An existing file is not overwritten. Use - to write to stdout.
+func WriteConfigFile(path string) error {
+	config := newConfig()
+	if err := config.setup(); err != nil {
+		return err
+	}
+
+	if path == "-" {
+		return config.WriteConfig(os.Stdout)
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600) //nolint:gomnd
+	if err != nil {
+		return fmt.Errorf("creating config file: %w", err)
+	}
+
+	if err := config.WriteConfig(file); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("writing config file: %w", err)
+	}
+
+	return nil
+}
+
+// WriteConfig writes the config as TOML, with every line commented out.
+func (c *Config) WriteConfig(output io.Writer) error {
+	var buf bytes.Buffer
+
+	buf.WriteString("# Mulery config file. Every setting is commented out, and set to its default.\n" +
+		"# Uncomment the settings you want to change.\n")
+	writeTable(&buf, "", reflect.ValueOf(c).Elem())
+
+	if _, err := output.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("writing config: %w", err)
+	}
+
+	return nil
+}
+
+// tomlField is a struct field with a toml tag.
+type tomlField struct {
+	key   string
+	value reflect.Value
+}
+
+// tomlFields returns the settings in a struct, including the ones in embedded structs.
+func tomlFields(value reflect.Value) []tomlField {
+	var fields []tomlField
+
+	for idx := 0; idx < value.NumField(); idx++ {
+		field, fieldValue := value.Type().Field(idx), value.Field(idx)
+
+		switch key, _, _ := strings.Cut(field.Tag.Get("toml"), ","); {
+		case !field.IsExported() || key == "-":
+		case field.Anonymous && key == "":
+			if fieldValue.Kind() == reflect.Pointer {
+				if fieldValue.IsNil() {
+					continue
+				}
+
+				fieldValue = fieldValue.Elem()
+			}
+
+			fields = append(fields, tomlFields(fieldValue)...)
+		case key != "":
+			fields = append(fields, tomlField{key: key, value: fieldValue})
+		}
+	}
+
+	return fields
+}
+
+// writeTable writes a struct's settings, then its tables. TOML needs the tables last.
+func writeTable(buf *bytes.Buffer, prefix string, value reflect.Value) {
+	var tables []tomlField
+
+	for _, field := range tomlFields(value) {
+		if line, ok := tomlValue(field.value); ok {
+			fmt.Fprintf(buf, "# %s = %s\n", field.key, line)
+		} else {
+			tables = append(tables, field)
+		}
+	}
+
+	for _, field := range tables {
+		writeNested(buf, prefix+field.key, field.value)
+	}
+}
+
+// writeNested writes a setting that is a table, an array of tables or a map.
+func writeNested(buf *bytes.Buffer, name string, value reflect.Value) {
+	typ := value.Type()
+
+	switch {
+	case typ.Kind() == reflect.Map && typ.Elem().Kind() != reflect.Pointer && typ.Elem().Kind() != reflect.Struct:
+		fmt.Fprintf(buf, "#\n# [%s]\n", name)
+
+		keys := value.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+
+		for _, key := range keys {
+			line, _ := tomlValue(value.MapIndex(key))
+			fmt.Fprintf(buf, "# %s = %s\n", strconv.Quote(key.String()), line)
+		}
+	case typ.Kind() == reflect.Map:
+		fmt.Fprintf(buf, "#\n# [%s.%s]\n", name, strconv.Quote(exampleKey))
+		writeTable(buf, name+"."+strconv.Quote(exampleKey)+".", structOf(typ.Elem()))
+	case typ.Kind() == reflect.Slice:
+		fmt.Fprintf(buf, "#\n# [[%s]]\n", name)
+		writeTable(buf, name+".", structOf(typ.Elem()))
+	case value.Kind() == reflect.Pointer && !value.IsNil():
+		fmt.Fprintf(buf, "#\n# [%s]\n", name)
+		writeTable(buf, name+".", value.Elem())
+	default:
+		fmt.Fprintf(buf, "#\n# [%s]\n", name)
+		writeTable(buf, name+".", structOf(typ))
+	}
+}
+
+// structOf returns an empty struct for a struct or struct pointer type, to show its settings.
+func structOf(typ reflect.Type) reflect.Value {
+	if typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+
+	return reflect.New(typ).Elem()
+}
+
+// tomlValue formats a setting that fits on one line. Returns false for tables.
+func tomlValue(value reflect.Value) (string, bool) {
+	if value.Type() == reflect.TypeOf(time.Duration(0)) {
+		return strconv.Quote(time.Duration(value.Int()).String()), true
+	}
+
+	switch value.Kind() { //nolint:exhaustive // the rest are not settings.
+	case reflect.String:
+		return strconv.Quote(value.String()), true
+	case reflect.Bool:
+		return strconv.FormatBool(value.Bool()), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(value.Int(), 10), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(value.Uint(), 10), true
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(value.Float(), 'f', -1, 64), true
+	case reflect.Slice:
+		if elem := value.Type().Elem(); elem.Kind() == reflect.Pointer || elem.Kind() == reflect.Struct {
+			return "", false
+		}
+
+		items := make([]string, value.Len())
+		for idx := range items {
+			items[idx], _ = tomlValue(value.Index(idx))
+		}
+
+		return "[" + strings.Join(items, ", ") + "]", true
+	default:
+		return "", false
+	}
+}